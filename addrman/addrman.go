@@ -0,0 +1,501 @@
+package addrman
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+)
+
+// Table sizes, as in Bitcoin Core's addrman.
+const (
+	NewBuckets       = 1024
+	TriedBuckets     = 256
+	BucketSize       = 64
+	newBucketsPerSrc = 64 // new buckets a single source group can reach
+	triedPerGroup    = 8  // tried buckets a single address group can reach
+)
+
+const (
+	horizonDays    = 30 // AddressMsg older than this is terrible
+	retries        = 3  // failed attempts before a never-connected entry is terrible
+	maxFailures    = 10 // failed attempts since last success before terrible
+	minFailDays    = 7  // ... if the last success was this long ago
+	recentAttempt  = 10 * time.Minute
+	maxSelectTries = 1000
+)
+
+var ErrInvalidAddress = errors.New("invalid AddressMsg")
+
+// InvalidError is returned by Add for an AddressMsg that fails
+// node.AddressMsg.Validate; it matches ErrInvalidAddress with errors.Is.
+type InvalidError struct {
+	Reasons []node.AddrReason
+}
+
+func (e *InvalidError) Error() string {
+	s := ErrInvalidAddress.Error() + ":"
+	for _, r := range e.Reasons {
+		s += " " + r.String()
+	}
+	return s
+}
+
+func (e *InvalidError) Is(target error) bool {
+	return target == ErrInvalidAddress
+}
+
+// Manager keeps node addresses learned from signed AddressMsg and
+// AddressV2Msg gossip, keyed by the node public key from the message
+// header.
+//
+// Like Bitcoin's addrman, addresses start in the "new" table, bucketed
+// by the netgroups of the source that told us and of the address, so a
+// single source cannot fill the table. Addresses we have connected to
+// successfully move to the "tried" table, bucketed by their own netgroup.
+// Select favours tried addresses and those without recent failures.
+type Manager struct {
+	mu      sync.Mutex
+	key     [32]byte // secret bucket hashing key
+	entries map[[32]byte]*Entry
+	newTbl  [NewBuckets][BucketSize]*Entry
+	tried   [TriedBuckets][BucketSize]*Entry
+	nNew    int
+	nTried  int
+	cfg     Config
+	rand    *mrand.Rand
+}
+
+// Entry is a known node address.
+//
+// Msg holds the address for either message version; for a Tor or I2P
+// address (only carried by node.TagAddrV2) its Address is zero, and
+// V2.Address holds the overlay address.
+type Entry struct {
+	PubKey      [32]byte
+	Msg         node.AddressMsg
+	V2          node.AddressV2Msg // the address with its network type
+	Raw         dnet.RawMessage   // original signed message, for re-sending
+	Source      dnet.Address      // peer we learned the address from
+	Tried       bool
+	Attempts    int // connection attempts since the last success
+	LastAttempt time.Time
+	LastSuccess time.Time
+	prevAttempt time.Time // LastAttempt before the current dial
+	dialing     bool      // Attempt was recorded and not yet resolved
+	bucket      int
+	slot        int
+}
+
+// Addr returns the node's advertised IP address
+// (the zero Address for an overlay address.)
+func (e *Entry) Addr() dnet.Address {
+	return e.Msg.Address
+}
+
+// HostPort is the address to dial: an IP or overlay name, and the port.
+func (e *Entry) HostPort() string {
+	return e.V2.HostPort()
+}
+
+// IsOverlay reports whether the node has a Tor or I2P address.
+func (e *Entry) IsOverlay() bool {
+	return e.V2.Address.IsOverlay()
+}
+
+// IsAddrV2 reports whether Raw is a node.TagAddrV2 message, which can
+// only be sent to peers that negotiated node.FeatureAddrV2.
+func (e *Entry) IsAddrV2() bool {
+	_, tag := dnet.MsgView(e.Raw.Header).ChanTag()
+	return tag == node.TagAddrV2
+}
+
+// netGroup is the group an address is bucketed by: the /16 or /32
+// prefix of an IP, or the network and first 4 bits of an overlay address.
+func (e *Entry) netGroup() []byte {
+	if a := e.V2.Address; a.IsOverlay() {
+		return []byte{byte(a.Network), a.Addr[0] >> 4}
+	}
+	return e.Msg.Address.NetGroup()
+}
+
+type Config struct {
+	Path  string          // file to persist the table to (optional)
+	Rules *node.AddrRules // checks for new addresses (default node.DefaultAddrRules)
+	Clock dnet.Clock      // optional; defaults to dnet.SystemClock
+}
+
+func New(cfg Config) *Manager {
+	if cfg.Rules == nil {
+		cfg.Rules = &node.DefaultAddrRules
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = dnet.SystemClock{}
+	}
+	m := &Manager{
+		entries: make(map[[32]byte]*Entry),
+		cfg:     cfg,
+		rand:    mrand.New(mrand.NewSource(seed())),
+	}
+	if _, err := rand.Read(m.key[:]); err != nil {
+		panic("addrman: no entropy: " + err.Error())
+	}
+	return m
+}
+
+func seed() int64 {
+	var b [8]byte
+	rand.Read(b[:])
+	return int64(binary.LittleEndian.Uint64(b[:]))
+}
+
+// Add records a verified node.TagAddress or node.TagAddrV2 message
+// received from source. A newer message for a known node replaces the
+// previous one. Messages that fail validation are rejected with an
+// *InvalidError; private and loopback addresses are only accepted from
+// a source that is itself on a private or local network.
+func (m *Manager) Add(msg dnet.Message, source dnet.Address) error {
+	if msg.Chan != node.ChannelNode {
+		return ErrInvalidAddress
+	}
+	addr, v2, ok := decodeAddr(msg.Tag, msg.Payload)
+	if !ok {
+		return ErrInvalidAddress
+	}
+	rules := *m.cfg.Rules
+	if source.Host().IsValid() && !source.IsRoutable() {
+		rules.AllowLocal = true
+	}
+	if reasons := v2.Validate(m.cfg.Clock.Now(), rules); len(reasons) > 0 {
+		return &InvalidError{Reasons: reasons}
+	}
+	raw := dnet.RawMessage{Header: msg.RawHdr, Payload: msg.Payload}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.add(*(*[32]byte)(msg.PubKey), addr, v2, raw, source)
+	return nil
+}
+
+// m.mu must be held.
+func (m *Manager) add(pub [32]byte, addr node.AddressMsg, v2 node.AddressV2Msg, raw dnet.RawMessage, source dnet.Address) {
+	if e := m.entries[pub]; e != nil {
+		if addr.Time <= e.Msg.Time {
+			return // not newer
+		}
+		moved := e.HostPort() != v2.HostPort()
+		e.Msg = addr
+		e.V2 = v2
+		e.Raw = raw
+		if moved {
+			// a new address must prove itself again
+			m.unlink(e)
+			e.Tried = false
+			e.Attempts = 0
+			e.Source = source
+			m.insertNew(e)
+		}
+		return
+	}
+	e := &Entry{PubKey: pub, Msg: addr, V2: v2, Raw: raw, Source: source}
+	m.entries[pub] = e
+	m.insertNew(e)
+}
+
+// Attempt records a connection attempt to a node. Call Good or Failed
+// with the outcome.
+func (m *Manager) Attempt(pub [32]byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.entries[pub]; e != nil {
+		m.attempt(e, m.cfg.Clock.Now())
+	}
+}
+
+// attempt counts a connection attempt; m.mu must be held.
+func (m *Manager) attempt(e *Entry, now time.Time) {
+	e.Attempts++
+	e.prevAttempt = e.LastAttempt
+	e.LastAttempt = now
+	e.dialing = true
+}
+
+// Good records a successful connection, moving the node to the tried table.
+func (m *Manager) Good(pub [32]byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entries[pub]
+	if e == nil {
+		return
+	}
+	now := m.cfg.Clock.Now()
+	e.Attempts = 0
+	e.LastAttempt = now
+	e.LastSuccess = now
+	e.dialing = false
+	if !e.Tried {
+		m.unlink(e)
+		m.insertTried(e)
+	}
+}
+
+// Failed records a failed connection attempt, counting it unless
+// Attempt already did. New entries that are now terrible are removed;
+// whether an entry is terrible is judged on the attempt before this
+// one, so a failure right after Attempt still counts.
+func (m *Manager) Failed(pub [32]byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.entries[pub]
+	if e == nil {
+		return
+	}
+	if !e.dialing {
+		m.attempt(e, m.cfg.Clock.Now())
+	}
+	e.dialing = false
+	if !e.Tried && m.terrible(e, e.prevAttempt, e.LastAttempt) {
+		m.unlink(e)
+		delete(m.entries, pub)
+	}
+}
+
+// Remove forgets a node.
+func (m *Manager) Remove(pub [32]byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.entries[pub]; e != nil {
+		m.unlink(e)
+		delete(m.entries, pub)
+	}
+}
+
+// Get returns a copy of the entry for a node.
+func (m *Manager) Get(pub [32]byte) (Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e := m.entries[pub]; e != nil {
+		return *e, true
+	}
+	return Entry{}, false
+}
+
+// Len returns the number of entries in the new and tried tables.
+func (m *Manager) Len() (newCount int, triedCount int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.nNew, m.nTried
+}
+
+// All returns a copy of every entry.
+func (m *Manager) All() []Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	all := make([]Entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		all = append(all, *e)
+	}
+	return all
+}
+
+// Select picks an address to dial. Tried and new entries are chosen
+// with equal probability (newOnly selects only new entries), then
+// entries with recent or repeated failures are skipped with
+// increasing probability.
+func (m *Manager) Select(newOnly bool) (Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.nNew+m.nTried == 0 || (newOnly && m.nNew == 0) {
+		return Entry{}, false
+	}
+	useTried := !newOnly && m.nTried > 0 && (m.nNew == 0 || m.rand.Intn(2) == 0)
+	now := m.cfg.Clock.Now()
+	factor := 1.0
+	for i := 0; i < maxSelectTries; i++ {
+		var e *Entry
+		if useTried {
+			e = m.tried[m.rand.Intn(TriedBuckets)][m.rand.Intn(BucketSize)]
+		} else {
+			e = m.newTbl[m.rand.Intn(NewBuckets)][m.rand.Intn(BucketSize)]
+		}
+		if e == nil {
+			continue
+		}
+		if m.rand.Float64() < factor*chance(e, now) {
+			return *e, true
+		}
+		factor *= 1.2
+	}
+	return Entry{}, false
+}
+
+// chance is the relative probability of selecting an entry.
+func chance(e *Entry, now time.Time) float64 {
+	c := 1.0
+	if now.Sub(e.LastAttempt) < recentAttempt {
+		c *= 0.01
+	}
+	attempts := e.Attempts
+	if attempts > 8 {
+		attempts = 8
+	}
+	return c * math.Pow(0.66, float64(attempts))
+}
+
+// isTerrible reports whether an entry is not worth keeping.
+func (m *Manager) isTerrible(e *Entry, now time.Time) bool {
+	return m.terrible(e, e.LastAttempt, now)
+}
+
+// terrible is isTerrible with the last attempt made at lastAttempt.
+func (m *Manager) terrible(e *Entry, lastAttempt, now time.Time) bool {
+	if now.Sub(lastAttempt) < time.Minute {
+		return false // never remove things tried in the last minute
+	}
+	age := now.Sub(e.Msg.Time.Local())
+	if age > horizonDays*24*time.Hour {
+		return true
+	}
+	if e.LastSuccess.IsZero() && e.Attempts >= retries {
+		return true
+	}
+	if now.Sub(e.LastSuccess) > minFailDays*24*time.Hour && e.Attempts >= maxFailures {
+		return true
+	}
+	return false
+}
+
+// newPos returns an entry's bucket and slot in the new table.
+func (m *Manager) newPos(e *Entry) (bucket int, slot int) {
+	dst := e.netGroup()
+	src := e.Source.NetGroup()
+	b := int(m.hash([]byte("new"), src, uint64Bytes(m.hash(dst, src)%newBucketsPerSrc)) % NewBuckets)
+	return b, int(m.hash([]byte("slot"), uint64Bytes(uint64(b)), e.PubKey[:]) % BucketSize)
+}
+
+// triedPos returns an entry's bucket and slot in the tried table.
+func (m *Manager) triedPos(e *Entry) (bucket int, slot int) {
+	dst := e.netGroup()
+	b := int(m.hash([]byte("tried"), dst, uint64Bytes(m.hash(e.PubKey[:])%triedPerGroup)) % TriedBuckets)
+	return b, int(m.hash([]byte("slot"), uint64Bytes(uint64(b)), e.PubKey[:]) % BucketSize)
+}
+
+// insertNew places an entry in the new table; m.mu must be held.
+func (m *Manager) insertNew(e *Entry) {
+	b, s := m.newPos(e)
+	if old := m.newTbl[b][s]; old != nil {
+		if !m.isTerrible(old, m.cfg.Clock.Now()) {
+			delete(m.entries, e.PubKey) // keep the existing entry
+			return
+		}
+		m.unlink(old)
+		delete(m.entries, old.PubKey)
+	}
+	e.Tried = false
+	e.bucket, e.slot = b, s
+	m.newTbl[b][s] = e
+	m.nNew++
+}
+
+// insertTried places an entry in the tried table, moving any entry in
+// the way back to the new table; m.mu must be held.
+func (m *Manager) insertTried(e *Entry) {
+	b, s := m.triedPos(e)
+	old := m.tried[b][s]
+	e.Tried = true
+	e.bucket, e.slot = b, s
+	m.tried[b][s] = e
+	m.nTried++
+	if old != nil {
+		m.nTried--
+		old.Tried = false
+		m.insertNew(old)
+	}
+}
+
+// unlink removes an entry from whichever table holds it; m.mu must be held.
+func (m *Manager) unlink(e *Entry) {
+	if e.Tried {
+		if m.tried[e.bucket][e.slot] == e {
+			m.tried[e.bucket][e.slot] = nil
+			m.nTried--
+		}
+	} else if m.newTbl[e.bucket][e.slot] == e {
+		m.newTbl[e.bucket][e.slot] = nil
+		m.nNew--
+	}
+}
+
+func (m *Manager) hash(parts ...[]byte) uint64 {
+	h := sha256.New()
+	h.Write(m.key[:])
+	for _, p := range parts {
+		h.Write(p)
+	}
+	var sum [32]byte
+	h.Sum(sum[:0])
+	return binary.LittleEndian.Uint64(sum[0:8])
+}
+
+func uint64Bytes(v uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	return b[:]
+}
+
+// decodeAddr decodes a node.TagAddress or node.TagAddrV2 payload as
+// both message versions; for an overlay address, msg.Address is zero.
+func decodeAddr(tag dnet.Tag4CC, payload []byte) (msg node.AddressMsg, v2 node.AddressV2Msg, ok bool) {
+	switch tag {
+	case node.TagAddress:
+		ok = codec.TryDecode(func() { msg = node.DecodeAddrMsg(payload) }) && msg.IsValid()
+		if ok {
+			v2, ok = msg.ToV2()
+		}
+	case node.TagAddrV2:
+		ok = codec.TryDecode(func() { v2 = node.DecodeAddrV2Msg(payload) }) && v2.IsValid()
+		if ok {
+			var ip bool
+			if msg, ip = v2.ToV1(); !ip {
+				msg = node.AddressMsg{Time: v2.Time, Owner: v2.Owner, Channels: v2.Channels, Services: v2.Services}
+			}
+		}
+	}
+	return
+}
+
+func (e Entry) String() string {
+	return fmt.Sprintf("%x %v", e.PubKey[:4], e.HostPort())
+}
+
+// Sample returns up to max random entries accepted by filter (which may
+// be nil), skipping entries that are not worth sharing.
+func (m *Manager) Sample(max int, filter func(e *Entry) bool) []Entry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.cfg.Clock.Now()
+	all := make([]*Entry, 0, len(m.entries))
+	for _, e := range m.entries {
+		if !m.isTerrible(e, now) && (filter == nil || filter(e)) {
+			all = append(all, e)
+		}
+	}
+	if max > len(all) {
+		max = len(all)
+	}
+	res := make([]Entry, max)
+	for i := 0; i < max; i++ {
+		j := i + m.rand.Intn(len(all)-i)
+		all[i], all[j] = all[j], all[i]
+		res[i] = *all[i]
+	}
+	return res
+}
@@ -0,0 +1,271 @@
+package addrman
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/internal/testutil"
+	"code.dogecoin.org/gossip/node"
+)
+
+func newTestManager(cfg Config) (*Manager, *testutil.Clock) {
+	clock := testutil.NewClock()
+	cfg.Clock = clock
+	return New(cfg), clock
+}
+
+var source = dnet.AddressFromIP(net.IPv4(1, 2, 3, 4), 22556)
+
+func addrMsg(clock dnet.Clock, ip net.IP) node.AddressMsg {
+	return node.AddressMsg{
+		Time:    dnet.DogeNowAt(clock),
+		Address: dnet.AddressFromIP(ip, 22556),
+		Owner:   make([]byte, 32),
+	}
+}
+
+// signedAddr returns a verified node.TagAddress message.
+func signedAddr(t *testing.T, clock dnet.Clock, ip net.IP) dnet.Message {
+	t.Helper()
+	key, err := dnet.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := dnet.EncodeMessage(node.ChannelNode, node.TagAddress, key, addrMsg(clock, ip).Encode())
+	msg, err := dnet.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// colliding returns entries for pub keys 1, 2, ... that land in the
+// same slot of a table, as reported by pos.
+func colliding(m *Manager, clock dnet.Clock, n int, pos func(*Entry) (int, int)) []*Entry {
+	var found []*Entry
+	var b0, s0 int
+	for i := 1; len(found) < n; i++ {
+		e := &Entry{Msg: addrMsg(clock, net.IPv4(8, 8, 8, 8)), Source: source}
+		e.V2, _ = e.Msg.ToV2()
+		e.PubKey[0], e.PubKey[1] = byte(i), byte(i>>8)
+		b, s := pos(e)
+		if len(found) == 0 {
+			b0, s0 = b, s
+		}
+		if b == b0 && s == s0 {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+func TestAddValidates(t *testing.T) {
+	m, clock := newTestManager(Config{})
+	err := m.Add(signedAddr(t, clock, net.IPv4(127, 0, 0, 1)), source)
+	var invalid *InvalidError
+	if !errors.Is(err, ErrInvalidAddress) || !errors.As(err, &invalid) || invalid.Reasons[0] != node.AddrUnroutable {
+		t.Fatalf("loopback from a public source: %v", err)
+	}
+	local := dnet.AddressFromIP(net.IPv4(192, 168, 1, 2), 22556)
+	if err := m.Add(signedAddr(t, clock, net.IPv4(192, 168, 1, 3)), local); err != nil {
+		t.Fatalf("private address from a private source: %v", err)
+	}
+	if err := m.Add(signedAddr(t, clock, net.IPv4(8, 8, 8, 8)), source); err != nil {
+		t.Fatal(err)
+	}
+	if n, tried := m.Len(); n != 2 || tried != 0 {
+		t.Fatalf("Len: %d new %d tried", n, tried)
+	}
+}
+
+func TestFailedRemovesTerrible(t *testing.T) {
+	m, clock := newTestManager(Config{})
+	msg := signedAddr(t, clock, net.IPv4(8, 8, 8, 8))
+	if err := m.Add(msg, source); err != nil {
+		t.Fatal(err)
+	}
+	pub := *(*[32]byte)(msg.PubKey)
+	m.Failed(pub)
+	m.Failed(pub)
+	clock.Advance(2 * time.Minute)
+	m.Failed(pub)
+	if _, ok := m.Get(pub); ok {
+		t.Fatal("entry that never connected was kept after 3 failures")
+	}
+}
+
+func TestDialFailEvicts(t *testing.T) {
+	m, clock := newTestManager(Config{})
+	msg := signedAddr(t, clock, net.IPv4(8, 8, 8, 8))
+	if err := m.Add(msg, source); err != nil {
+		t.Fatal(err)
+	}
+	pub := *(*[32]byte)(msg.PubKey)
+	for i := 1; i < retries; i++ {
+		m.Attempt(pub)
+		m.Failed(pub)
+		e, ok := m.Get(pub)
+		if !ok || e.Attempts != i {
+			t.Fatalf("after %d dials: kept %v, attempts %d", i, ok, e.Attempts)
+		}
+		clock.Advance(2 * time.Minute)
+	}
+	m.Attempt(pub)
+	m.Failed(pub)
+	if _, ok := m.Get(pub); ok {
+		t.Fatalf("entry that never connected was kept after %d failed dials", retries)
+	}
+}
+
+func TestFailedKeepsTried(t *testing.T) {
+	m, clock := newTestManager(Config{})
+	msg := signedAddr(t, clock, net.IPv4(8, 8, 8, 8))
+	m.Add(msg, source)
+	pub := *(*[32]byte)(msg.PubKey)
+	m.Good(pub)
+	for i := 0; i < maxFailures; i++ {
+		clock.Advance(2 * time.Minute)
+		m.Failed(pub)
+	}
+	e, ok := m.Get(pub)
+	if !ok || !e.Tried || e.Attempts != maxFailures {
+		t.Fatalf("tried entry: %v %v", ok, e.Attempts)
+	}
+}
+
+func TestNewSlotEviction(t *testing.T) {
+	m, clock := newTestManager(Config{})
+	es := colliding(m, clock, 3, m.newPos)
+	m.mu.Lock()
+	m.add(es[0].PubKey, es[0].Msg, es[0].V2, dnet.RawMessage{}, source)
+	m.add(es[1].PubKey, es[1].Msg, es[1].V2, dnet.RawMessage{}, source)
+	m.mu.Unlock()
+	if _, ok := m.Get(es[0].PubKey); !ok {
+		t.Fatal("good entry was evicted")
+	}
+	if _, ok := m.Get(es[1].PubKey); ok {
+		t.Fatal("colliding entry was added")
+	}
+
+	// once the first entry is past the horizon it can be replaced
+	clock.Advance((horizonDays + 1) * 24 * time.Hour)
+	es[2].Msg.Time = dnet.DogeNowAt(clock)
+	es[2].V2.Time = es[2].Msg.Time
+	m.mu.Lock()
+	m.add(es[2].PubKey, es[2].Msg, es[2].V2, dnet.RawMessage{}, source)
+	m.mu.Unlock()
+	if _, ok := m.Get(es[0].PubKey); ok {
+		t.Fatal("terrible entry was kept")
+	}
+	if _, ok := m.Get(es[2].PubKey); !ok {
+		t.Fatal("entry did not replace the terrible one")
+	}
+	if n, _ := m.Len(); n != 1 {
+		t.Fatalf("new count %d", n)
+	}
+}
+
+func TestTriedCollisionMovesBack(t *testing.T) {
+	m, clock := newTestManager(Config{})
+	es := colliding(m, clock, 2, m.triedPos)
+	m.mu.Lock()
+	for _, e := range es {
+		m.entries[e.PubKey] = e
+		m.insertTried(e)
+	}
+	m.mu.Unlock()
+	first, ok := m.Get(es[0].PubKey)
+	if !ok || first.Tried {
+		t.Fatalf("displaced entry: kept %v tried %v", ok, first.Tried)
+	}
+	if second, _ := m.Get(es[1].PubKey); !second.Tried {
+		t.Fatal("new tried entry is not tried")
+	}
+	if n, tried := m.Len(); n != 1 || tried != 1 {
+		t.Fatalf("Len: %d new %d tried", n, tried)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "addr.dat")
+	m, clock := newTestManager(Config{Path: path})
+	a := signedAddr(t, clock, net.IPv4(8, 8, 8, 8))
+	b := signedAddr(t, clock, net.IPv4(9, 9, 9, 9))
+	m.Add(a, source)
+	m.Add(b, source)
+	m.Good(*(*[32]byte)(b.PubKey))
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := New(Config{Path: path, Clock: clock})
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if n, tried := loaded.Len(); n != 1 || tried != 1 {
+		t.Fatalf("Len: %d new %d tried", n, tried)
+	}
+	e, ok := loaded.Get(*(*[32]byte)(b.PubKey))
+	if !ok || !e.Tried || !e.LastSuccess.Equal(clock.Now()) || !e.Source.Equal(source) {
+		t.Fatalf("loaded entry: %+v", e)
+	}
+}
+
+// signedAddrV2 returns a verified node.TagAddrV2 message.
+func signedAddrV2(t *testing.T, clock dnet.Clock, addr node.NetAddr) dnet.Message {
+	t.Helper()
+	key, err := dnet.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2 := node.AddressV2Msg{Time: dnet.DogeNowAt(clock), Address: addr, Port: 42069, Owner: make([]byte, 32)}
+	msg, err := dnet.ReadMessage(bytes.NewReader(dnet.EncodeMessage(node.ChannelNode, node.TagAddrV2, key, v2.Encode())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestAddV2(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "addr.dat")
+	m, clock := newTestManager(Config{Path: path})
+	tor := node.NetAddr{Network: node.NetTorV3, Addr: bytes.Repeat([]byte{7}, 32)}
+	onion := signedAddrV2(t, clock, tor)
+	ip := signedAddrV2(t, clock, node.NetAddrFromIP(net.IPv4(8, 8, 8, 8)))
+	if err := m.Add(onion, source); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add(ip, source); err != nil {
+		t.Fatal(err)
+	}
+	private := signedAddrV2(t, clock, node.NetAddrFromIP(net.IPv4(10, 0, 0, 1)))
+	if err := m.Add(private, source); !errors.Is(err, ErrInvalidAddress) {
+		t.Fatalf("private address: %v", err)
+	}
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := New(Config{Path: path, Clock: clock})
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	e, ok := loaded.Get(*(*[32]byte)(onion.PubKey))
+	if !ok || !e.IsOverlay() || !e.IsAddrV2() || e.Addr().IsValid() || e.HostPort() != tor.String()+":42069" {
+		t.Fatalf("onion entry: %v %v", ok, e)
+	}
+	e, ok = loaded.Get(*(*[32]byte)(ip.PubKey))
+	if !ok || e.IsOverlay() || !e.IsAddrV2() || e.Addr().String() != "8.8.8.8:42069" {
+		t.Fatalf("ip entry: %v %v", ok, e)
+	}
+	v1 := signedAddr(t, clock, net.IPv4(9, 9, 9, 9))
+	m.Add(v1, source)
+	if e, _ := m.Get(*(*[32]byte)(v1.PubKey)); e.IsAddrV2() || e.V2.Address.Network != node.NetIPv4 || e.HostPort() != "9.9.9.9:22556" {
+		t.Fatalf("v1 entry: %v", e)
+	}
+}
@@ -0,0 +1,145 @@
+package addrman
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/internal/atomicfile"
+)
+
+var fileMagic = dnet.NewTag("DNAM")
+
+const fileVersion = 1
+
+// Save writes the address table to Config.Path.
+// The file is replaced atomically.
+func (m *Manager) Save() error {
+	if m.cfg.Path == "" {
+		return nil
+	}
+	m.mu.Lock()
+	e := codec.Encode(64 + len(m.entries)*256)
+	e.UInt32be(uint32(fileMagic))
+	e.UInt32le(fileVersion)
+	e.Bytes(m.key[:])
+	e.VarUInt(uint64(len(m.entries)))
+	for _, ent := range m.entries {
+		e.Bool(ent.Tried)
+		e.Bytes(ent.Source.ToBytes())
+		e.UInt32le(uint32(ent.Attempts))
+		e.Int64le(unixOrZero(ent.LastAttempt))
+		e.Int64le(unixOrZero(ent.LastSuccess))
+		e.Bytes(ent.Raw.Header)
+		e.VarUInt(uint64(len(ent.Raw.Payload)))
+		e.Bytes(ent.Raw.Payload)
+	}
+	m.mu.Unlock()
+	return atomicfile.Write(m.cfg.Path, e.Result())
+}
+
+// Load replaces the table with the contents of Config.Path.
+// A missing file is not an error. Entries with invalid signatures
+// are skipped.
+func (m *Manager) Load() (err error) {
+	if m.cfg.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.cfg.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("addrman: corrupt file %v: %v", m.cfg.Path, r)
+		}
+	}()
+	d := codec.Decode(data)
+	if dnet.Tag4CC(d.UInt32be()) != fileMagic {
+		return fmt.Errorf("addrman: not an address file: %v", m.cfg.Path)
+	}
+	if ver := d.UInt32le(); ver != fileVersion {
+		return fmt.Errorf("addrman: unsupported file version %d: %v", ver, m.cfg.Path)
+	}
+	fresh := New(m.cfg)
+	copy(fresh.key[:], d.Bytes(32))
+	count := d.VarUInt()
+	var tried []*Entry
+	for i := uint64(0); i < count; i++ {
+		isTried := d.Bool()
+		source, _ := dnet.AddressFromBytes(d.Bytes(18))
+		attempts := int(d.UInt32le())
+		lastAttempt := timeOrZero(d.Int64le())
+		lastSuccess := timeOrZero(d.Int64le())
+		hdr := d.Bytes(dnet.HeaderSize)
+		size := d.VarUInt()
+		if size > dnet.MaxMsgSize {
+			return fmt.Errorf("addrman: corrupt file %v: entry too large", m.cfg.Path)
+		}
+		payload := d.Bytes(int(size))
+		frame := make([]byte, 0, len(hdr)+len(payload))
+		frame = append(append(frame, hdr...), payload...)
+		view := dnet.MsgView(frame)
+		if !view.Valid() {
+			continue
+		}
+		msg := dnet.DecodeHeader(view.Header())
+		msg.Payload = view.Payload()
+		addr, v2, ok := decodeAddr(msg.Tag, msg.Payload)
+		if !ok {
+			continue
+		}
+		pub := *view.PubKey()
+		if fresh.entries[pub] != nil {
+			continue
+		}
+		e := &Entry{
+			PubKey:      pub,
+			Msg:         addr,
+			V2:          v2,
+			Raw:         dnet.RawMessage{Header: view.Header(), Payload: view.Payload()},
+			Source:      source,
+			Attempts:    attempts,
+			LastAttempt: lastAttempt,
+			LastSuccess: lastSuccess,
+		}
+		fresh.entries[pub] = e
+		if isTried {
+			tried = append(tried, e)
+		} else {
+			fresh.insertNew(e)
+		}
+	}
+	for _, e := range tried {
+		fresh.insertTried(e)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.key = fresh.key
+	m.entries = fresh.entries
+	m.newTbl = fresh.newTbl
+	m.tried = fresh.tried
+	m.nNew = fresh.nNew
+	m.nTried = fresh.nTried
+	return nil
+}
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func timeOrZero(unix int64) time.Time {
+	if unix == 0 {
+		return time.Time{}
+	}
+	return time.Unix(unix, 0)
+}
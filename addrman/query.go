@@ -0,0 +1,83 @@
+package addrman
+
+import (
+	"net"
+	"sort"
+	"strconv"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+)
+
+// ServiceQuery selects nodes offering a service.
+type ServiceQuery struct {
+	Service dnet.Tag4CC // service tag, e.g. dnet.ServiceCore
+	Chain   node.Chain  // only services on this chain (0: any); requires node.ChainData
+	Limit   int         // most results (0: all)
+	// Filter is optional; return false to skip a match.
+	// It is called with the Manager locked and must not call into it.
+	Filter func(e *Entry, svc node.Service, data node.ServiceData) bool
+}
+
+// ServiceMatch is a node offering the queried service.
+type ServiceMatch struct {
+	Entry    Entry
+	Service  node.Service
+	Data     node.ServiceData // decoded Data (nil if the service has no schema)
+	Addr     dnet.Address     // the node's IP with the service's port (zero for an overlay address)
+	HostPort string           // the node's IP or overlay name with the service's port
+}
+
+// FindService returns nodes offering a service, skipping entries that
+// are not worth trying. Tried nodes come first, then the most recently
+// signed addresses.
+//
+// Chain only matches services whose Data decodes with a registered
+// schema (see node.RegisterService.)
+func (m *Manager) FindService(q ServiceQuery) []ServiceMatch {
+	m.mu.Lock()
+	now := m.cfg.Clock.Now()
+	var res []ServiceMatch
+	for _, e := range m.entries {
+		if m.isTerrible(e, now) {
+			continue
+		}
+		svc, ok := e.Msg.Service(q.Service)
+		if !ok {
+			continue
+		}
+		data, _ := svc.Decode()
+		if q.Chain != 0 {
+			cd, ok := data.(node.ChainData)
+			if !ok || cd.ServiceChain() != q.Chain {
+				continue
+			}
+		}
+		if q.Filter != nil && !q.Filter(e, svc, data) {
+			continue
+		}
+		var addr dnet.Address
+		if e.V2.Address.IsIP() {
+			addr = dnet.NewAddress(e.Msg.Address.Host(), svc.Port)
+		}
+		res = append(res, ServiceMatch{
+			Entry:    *e,
+			Service:  svc,
+			Data:     data,
+			Addr:     addr,
+			HostPort: net.JoinHostPort(e.V2.Address.String(), strconv.Itoa(int(svc.Port))),
+		})
+	}
+	m.mu.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		a, b := &res[i].Entry, &res[j].Entry
+		if a.Tried != b.Tried {
+			return a.Tried
+		}
+		return a.Msg.Time > b.Msg.Time
+	})
+	if q.Limit > 0 && len(res) > q.Limit {
+		res = res[:q.Limit]
+	}
+	return res
+}
@@ -0,0 +1,104 @@
+package addrman
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+)
+
+// addService adds a node offering services, returning its key.
+func addService(t *testing.T, m *Manager, clock dnet.Clock, addr node.NetAddr, services ...node.Service) [32]byte {
+	t.Helper()
+	key, err := dnet.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2 := node.AddressV2Msg{Time: dnet.DogeNowAt(clock), Address: addr, Port: 42069, Owner: make([]byte, 32), Services: services}
+	msg, err := dnet.ReadMessage(bytes.NewReader(dnet.EncodeMessage(node.ChannelNode, node.TagAddrV2, key, v2.Encode())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add(msg, source); err != nil {
+		t.Fatal(err)
+	}
+	return *key.Pub
+}
+
+func core(chain node.Chain, port uint16) node.Service {
+	return node.Service{Tag: dnet.ServiceCore, Port: port, Data: node.CoreService{Chain: chain, Version: 70015}.Encode()}
+}
+
+func TestFindService(t *testing.T) {
+	m, clock := newTestManager(Config{})
+	ip := func(b byte) node.NetAddr { return node.NetAddrFromIP(net.IPv4(8, 8, 8, b)) }
+	tor := node.NetAddr{Network: node.NetTorV3, Addr: bytes.Repeat([]byte{7}, 32)}
+	tried := addService(t, m, clock, ip(1), core(node.ChainMainnet, 22556))
+	clock.Advance(time.Minute)
+	testnet := addService(t, m, clock, ip(2), core(node.ChainTestnet, 44556))
+	clock.Advance(time.Minute)
+	malformed := addService(t, m, clock, ip(3), node.Service{Tag: dnet.ServiceCore, Port: 22556, Data: "x"})
+	clock.Advance(time.Minute)
+	addService(t, m, clock, ip(4), node.Service{Tag: dnet.NewTag("Othr"), Port: 1})
+	clock.Advance(time.Minute)
+	onion := addService(t, m, clock, tor, core(node.ChainMainnet, 22556))
+	m.Good(tried)
+
+	keys := func(res []ServiceMatch) [][32]byte {
+		var ks [][32]byte
+		for _, r := range res {
+			ks = append(ks, r.Entry.PubKey)
+		}
+		return ks
+	}
+	same := func(got [][32]byte, want ...[32]byte) bool {
+		if len(got) != len(want) {
+			return false
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				return false
+			}
+		}
+		return true
+	}
+
+	// tried first, then the newest
+	all := m.FindService(ServiceQuery{Service: dnet.ServiceCore})
+	if !same(keys(all), tried, onion, malformed, testnet) {
+		t.Fatalf("all core nodes: %d", len(all))
+	}
+	if all[2].Data != nil {
+		t.Fatal("malformed data decoded")
+	}
+
+	main := m.FindService(ServiceQuery{Service: dnet.ServiceCore, Chain: node.ChainMainnet})
+	if !same(keys(main), tried, onion) {
+		t.Fatalf("mainnet nodes: %d", len(main))
+	}
+	if main[0].Addr.String() != "8.8.8.1:22556" || main[0].HostPort != "8.8.8.1:22556" {
+		t.Fatalf("ip match %v %v", main[0].Addr, main[0].HostPort)
+	}
+	if main[1].Addr != (dnet.Address{}) || main[1].HostPort != tor.String()+":22556" {
+		t.Fatalf("onion match %v %v", main[1].Addr, main[1].HostPort)
+	}
+	if cs, ok := main[0].Data.(node.CoreService); !ok || cs.Version != 70015 {
+		t.Fatalf("data %+v", main[0].Data)
+	}
+
+	if res := m.FindService(ServiceQuery{Service: dnet.ServiceCore, Limit: 1}); !same(keys(res), tried) {
+		t.Fatal("limit")
+	}
+	res := m.FindService(ServiceQuery{Service: dnet.ServiceCore, Filter: func(e *Entry, svc node.Service, data node.ServiceData) bool {
+		return !e.IsOverlay()
+	}})
+	if !same(keys(res), tried, malformed, testnet) {
+		t.Fatal("filter")
+	}
+	if res := m.FindService(ServiceQuery{Service: dnet.NewTag("None")}); len(res) != 0 {
+		t.Fatal("found a service not offered")
+	}
+}
@@ -0,0 +1,413 @@
+package ban
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+)
+
+// Reason is a kind of misbehavior.
+type Reason uint8
+
+const (
+	ReasonBadSignature Reason = iota + 1 // message signature did not verify
+	ReasonOversized                      // frame larger than dnet.MaxMsgSize
+	ReasonDecode                         // payload failed to decode
+	ReasonStaleTime                      // message timestamp too old or too far in the future
+	ReasonSpam                           // message rate over the limit
+	ReasonManual                         // banned by the operator
+)
+
+func (r Reason) String() string {
+	switch r {
+	case ReasonBadSignature:
+		return "bad-signature"
+	case ReasonOversized:
+		return "oversized"
+	case ReasonDecode:
+		return "decode"
+	case ReasonStaleTime:
+		return "stale-time"
+	case ReasonSpam:
+		return "spam"
+	case ReasonManual:
+		return "manual"
+	}
+	return "unknown"
+}
+
+// DefaultScores are the misbehavior points given for each reason.
+var DefaultScores = map[Reason]int{
+	ReasonBadSignature: 100,
+	ReasonOversized:    100,
+	ReasonDecode:       20,
+	ReasonStaleTime:    10,
+	ReasonSpam:         5,
+}
+
+// Ban is a banned address or public key.
+type Ban struct {
+	Host    string   // banned IP address (empty for a key ban)
+	PubKey  [32]byte // banned public key (zero for an address ban)
+	Reason  Reason   // the misbehavior that pushed the score over the threshold
+	Created time.Time
+	Expires time.Time
+}
+
+func (b Ban) IsKey() bool {
+	return b.Host == ""
+}
+
+func (b Ban) String() string {
+	if b.IsKey() {
+		return "key:" + hex.EncodeToString(b.PubKey[:])
+	}
+	return "host:" + b.Host
+}
+
+type Config struct {
+	Path        string         // file to persist bans to (optional)
+	Threshold   int            // score at which to ban (default 100)
+	BanTime     time.Duration  // ban duration (default 24h)
+	ScoreWindow time.Duration  // scores decay by Threshold points over this long (default 24h)
+	Scores      map[Reason]int // points per reason (default DefaultScores)
+	MaxScores   int            // most addresses and most keys with a score (default 10000)
+	Clock       dnet.Clock     // optional; defaults to dnet.SystemClock
+}
+
+// Manager scores misbehavior by peer address and by public key,
+// and bans addresses and keys whose score reaches the threshold.
+type Manager struct {
+	cfg       Config
+	mu        sync.Mutex
+	hostScore map[string]*score
+	keyScore  map[[32]byte]*score
+	hostBans  map[string]*Ban
+	keyBans   map[[32]byte]*Ban
+	listeners []func(Ban)
+	lastSweep time.Time
+}
+
+// score is a misbehavior score; it decays linearly from points at last.
+type score struct {
+	points int
+	last   time.Time
+}
+
+const sweepInterval = time.Minute
+
+var ErrNotBanned = errors.New("not banned")
+
+func New(cfg Config) *Manager {
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 100
+	}
+	if cfg.BanTime <= 0 {
+		cfg.BanTime = 24 * time.Hour
+	}
+	if cfg.ScoreWindow <= 0 {
+		cfg.ScoreWindow = 24 * time.Hour
+	}
+	if cfg.Scores == nil {
+		cfg.Scores = DefaultScores
+	}
+	if cfg.MaxScores <= 0 {
+		cfg.MaxScores = 10000
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = dnet.SystemClock{}
+	}
+	return &Manager{
+		cfg:       cfg,
+		hostScore: make(map[string]*score),
+		keyScore:  make(map[[32]byte]*score),
+		hostBans:  make(map[string]*Ban),
+		keyBans:   make(map[[32]byte]*Ban),
+	}
+}
+
+// Notify registers fn to be called whenever a ban is added,
+// e.g. to disconnect matching peers. fn must not block.
+func (m *Manager) Notify(fn func(Ban)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, fn)
+}
+
+// Misbehaving adds points for a peer address and/or a public key
+// (either may be nil.) Returns true if either is now banned.
+func (m *Manager) Misbehaving(host net.IP, pub *[32]byte, reason Reason) bool {
+	points := m.cfg.Scores[reason]
+	now := m.cfg.Clock.Now()
+	var added []Ban
+	m.mu.Lock()
+	m.sweep(now)
+	if host != nil {
+		key := host.String()
+		if m.hostBans[key] == nil && m.addHostScore(key, points, now) {
+			b := &Ban{Host: key, Reason: reason, Created: now, Expires: now.Add(m.cfg.BanTime)}
+			m.hostBans[key] = b
+			delete(m.hostScore, key)
+			added = append(added, *b)
+		}
+	}
+	if pub != nil {
+		if m.keyBans[*pub] == nil && m.addKeyScore(*pub, points, now) {
+			b := &Ban{PubKey: *pub, Reason: reason, Created: now, Expires: now.Add(m.cfg.BanTime)}
+			m.keyBans[*pub] = b
+			delete(m.keyScore, *pub)
+			added = append(added, *b)
+		}
+	}
+	listeners := m.listeners
+	m.mu.Unlock()
+	m.notify(listeners, added)
+	return len(added) > 0 || m.IsBanned(host) || (pub != nil && m.IsKeyBanned(*pub))
+}
+
+// MisbehavingError scores an error returned by dnet.ReadMessage.
+// Only the address is scored: the public key in a message with a bad
+// signature is not proof of who sent it.
+func (m *Manager) MisbehavingError(host net.IP, err error) bool {
+	switch {
+	case errors.Is(err, dnet.ErrBadSignature):
+		return m.Misbehaving(host, nil, ReasonBadSignature)
+	case errors.Is(err, dnet.ErrMessageTooLarge):
+		return m.Misbehaving(host, nil, ReasonOversized)
+	}
+	return false
+}
+
+// addHostScore adds points for an address, returning true if it
+// reached the threshold; m.mu must be held.
+func (m *Manager) addHostScore(key string, points int, now time.Time) bool {
+	s := m.hostScore[key]
+	if s == nil {
+		if len(m.hostScore) >= m.cfg.MaxScores {
+			for k := range m.hostScore {
+				delete(m.hostScore, k) // full of active scores: forget any one
+				break
+			}
+		}
+		s = &score{}
+		m.hostScore[key] = s
+	}
+	return m.add(s, points, now)
+}
+
+// addKeyScore adds points for a public key, returning true if it
+// reached the threshold; m.mu must be held.
+func (m *Manager) addKeyScore(key [32]byte, points int, now time.Time) bool {
+	s := m.keyScore[key]
+	if s == nil {
+		if len(m.keyScore) >= m.cfg.MaxScores {
+			for k := range m.keyScore {
+				delete(m.keyScore, k) // full of active scores: forget any one
+				break
+			}
+		}
+		s = &score{}
+		m.keyScore[key] = s
+	}
+	return m.add(s, points, now)
+}
+
+// add decays a score to now and adds points, capped at the threshold.
+func (m *Manager) add(s *score, points int, now time.Time) bool {
+	s.points = m.decayed(s, now) + points
+	if s.points > m.cfg.Threshold {
+		s.points = m.cfg.Threshold
+	}
+	s.last = now
+	return s.points >= m.cfg.Threshold
+}
+
+// decayed returns a score's points at now: they fall by Threshold
+// every ScoreWindow.
+func (m *Manager) decayed(s *score, now time.Time) int {
+	elapsed := now.Sub(s.last)
+	if elapsed <= 0 {
+		return s.points
+	}
+	if elapsed >= m.cfg.ScoreWindow {
+		return 0
+	}
+	lost := int(int64(m.cfg.Threshold) * int64(elapsed) / int64(m.cfg.ScoreWindow))
+	if lost >= s.points {
+		return 0
+	}
+	return s.points - lost
+}
+
+// sweep forgets scores that have decayed to zero, at most once per
+// sweepInterval; m.mu must be held.
+func (m *Manager) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for k, s := range m.hostScore {
+		if m.decayed(s, now) == 0 {
+			delete(m.hostScore, k)
+		}
+	}
+	for k, s := range m.keyScore {
+		if m.decayed(s, now) == 0 {
+			delete(m.keyScore, k)
+		}
+	}
+}
+
+// Score returns the current misbehavior score for an address.
+func (m *Manager) Score(host net.IP) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s := m.hostScore[host.String()]; s != nil {
+		return m.decayed(s, m.cfg.Clock.Now())
+	}
+	return 0
+}
+
+// KeyScore returns the current misbehavior score for a public key.
+func (m *Manager) KeyScore(pub [32]byte) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s := m.keyScore[pub]; s != nil {
+		return m.decayed(s, m.cfg.Clock.Now())
+	}
+	return 0
+}
+
+// IsBanned reports whether an address is banned.
+func (m *Manager) IsBanned(host net.IP) bool {
+	if host == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := host.String()
+	if b := m.hostBans[key]; b != nil {
+		if m.cfg.Clock.Now().Before(b.Expires) {
+			return true
+		}
+		delete(m.hostBans, key)
+	}
+	return false
+}
+
+// IsKeyBanned reports whether a public key is banned.
+func (m *Manager) IsKeyBanned(pub [32]byte) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if b := m.keyBans[pub]; b != nil {
+		if m.cfg.Clock.Now().Before(b.Expires) {
+			return true
+		}
+		delete(m.keyBans, pub)
+	}
+	return false
+}
+
+// BanHost bans an address for the given duration (0: Config.BanTime.)
+func (m *Manager) BanHost(host net.IP, duration time.Duration) {
+	m.addBan(Ban{Host: host.String()}, duration)
+}
+
+// BanKey bans a public key for the given duration (0: Config.BanTime.)
+func (m *Manager) BanKey(pub [32]byte, duration time.Duration) {
+	m.addBan(Ban{PubKey: pub}, duration)
+}
+
+func (m *Manager) addBan(b Ban, duration time.Duration) {
+	if duration <= 0 {
+		duration = m.cfg.BanTime
+	}
+	b.Reason = ReasonManual
+	b.Created = m.cfg.Clock.Now()
+	b.Expires = b.Created.Add(duration)
+	m.mu.Lock()
+	if b.IsKey() {
+		m.keyBans[b.PubKey] = &b
+		delete(m.keyScore, b.PubKey)
+	} else {
+		m.hostBans[b.Host] = &b
+		delete(m.hostScore, b.Host)
+	}
+	listeners := m.listeners
+	m.mu.Unlock()
+	m.notify(listeners, []Ban{b})
+}
+
+// UnbanHost lifts a ban on an address.
+func (m *Manager) UnbanHost(host net.IP) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := host.String()
+	if m.hostBans[key] == nil {
+		return ErrNotBanned
+	}
+	delete(m.hostBans, key)
+	return nil
+}
+
+// UnbanKey lifts a ban on a public key.
+func (m *Manager) UnbanKey(pub [32]byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.keyBans[pub] == nil {
+		return ErrNotBanned
+	}
+	delete(m.keyBans, pub)
+	return nil
+}
+
+// List returns all current bans, dropping expired ones.
+func (m *Manager) List() []Ban {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expire(m.cfg.Clock.Now())
+	bans := make([]Ban, 0, len(m.hostBans)+len(m.keyBans))
+	for _, b := range m.hostBans {
+		bans = append(bans, *b)
+	}
+	for _, b := range m.keyBans {
+		bans = append(bans, *b)
+	}
+	return bans
+}
+
+// Clear lifts all bans and resets all scores.
+func (m *Manager) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hostScore = make(map[string]*score)
+	m.keyScore = make(map[[32]byte]*score)
+	m.hostBans = make(map[string]*Ban)
+	m.keyBans = make(map[[32]byte]*Ban)
+}
+
+// expire drops expired bans; m.mu must be held.
+func (m *Manager) expire(now time.Time) {
+	for k, b := range m.hostBans {
+		if !now.Before(b.Expires) {
+			delete(m.hostBans, k)
+		}
+	}
+	for k, b := range m.keyBans {
+		if !now.Before(b.Expires) {
+			delete(m.keyBans, k)
+		}
+	}
+}
+
+func (m *Manager) notify(listeners []func(Ban), bans []Ban) {
+	for _, b := range bans {
+		for _, fn := range listeners {
+			fn(b)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package ban
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/internal/testutil"
+)
+
+func newTestManager(cfg Config) (*Manager, *testutil.Clock) {
+	clock := testutil.NewClock()
+	cfg.Clock = clock
+	return New(cfg), clock
+}
+
+var host = net.IPv4(1, 2, 3, 4)
+
+func TestBanAtThreshold(t *testing.T) {
+	m, clock := newTestManager(Config{})
+	var notified []Ban
+	m.Notify(func(b Ban) { notified = append(notified, b) })
+	for i := 0; i < 4; i++ {
+		if m.Misbehaving(host, nil, ReasonDecode) {
+			t.Fatalf("banned after %d decode errors", i+1)
+		}
+	}
+	if m.Score(host) != 80 {
+		t.Fatalf("score %d", m.Score(host))
+	}
+	if !m.Misbehaving(host, nil, ReasonDecode) || !m.IsBanned(host) {
+		t.Fatal("not banned at the threshold")
+	}
+	if len(notified) != 1 || notified[0].Reason != ReasonDecode || !notified[0].Expires.Equal(clock.Now().Add(24*time.Hour)) {
+		t.Fatalf("notified %v", notified)
+	}
+	clock.Advance(24 * time.Hour)
+	if m.IsBanned(host) {
+		t.Fatal("ban did not expire")
+	}
+}
+
+func TestKeyBan(t *testing.T) {
+	m, _ := newTestManager(Config{})
+	pub := [32]byte{1}
+	if !m.Misbehaving(nil, &pub, ReasonBadSignature) || !m.IsKeyBanned(pub) {
+		t.Fatal("key not banned")
+	}
+	if m.IsBanned(host) {
+		t.Fatal("address banned for a key's misbehavior")
+	}
+	if err := m.UnbanKey(pub); err != nil || m.IsKeyBanned(pub) {
+		t.Fatalf("unban: %v", err)
+	}
+	if err := m.UnbanKey(pub); err != ErrNotBanned {
+		t.Fatalf("second unban: %v", err)
+	}
+}
+
+func TestScoreDecays(t *testing.T) {
+	m, clock := newTestManager(Config{})
+	pub := [32]byte{2}
+	for i := 0; i < 4; i++ {
+		m.Misbehaving(host, &pub, ReasonDecode)
+	}
+	clock.Advance(6 * time.Hour) // a quarter of the window: 25 points
+	if s := m.Score(host); s != 55 {
+		t.Fatalf("decayed score %d, want 55", s)
+	}
+	if s := m.KeyScore(pub); s != 55 {
+		t.Fatalf("decayed key score %d, want 55", s)
+	}
+	if m.Misbehaving(host, nil, ReasonDecode) {
+		t.Fatal("banned on a decayed score")
+	}
+	clock.Advance(24 * time.Hour)
+	if s := m.Score(host); s != 0 {
+		t.Fatalf("score %d after the window", s)
+	}
+	m.Misbehaving(net.IPv4(5, 6, 7, 8), nil, ReasonSpam) // sweeps
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.hostScore) != 1 || len(m.keyScore) != 0 {
+		t.Fatalf("kept %d host and %d key scores", len(m.hostScore), len(m.keyScore))
+	}
+}
+
+func TestScoreCapped(t *testing.T) {
+	m, clock := newTestManager(Config{})
+	s := &score{}
+	if !m.add(s, 1000, clock.Now()) || s.points != 100 {
+		t.Fatalf("points %d, want the threshold", s.points)
+	}
+
+	// a custom threshold; the score is dropped once banned
+	m, _ = newTestManager(Config{Threshold: 1000})
+	for i := 0; i < 10; i++ {
+		m.Misbehaving(host, nil, ReasonOversized)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n := len(m.hostBans); n != 1 {
+		t.Fatalf("%d bans", n)
+	}
+	if _, ok := m.hostScore[host.String()]; ok {
+		t.Fatal("score kept after the ban")
+	}
+}
+
+func TestMaxScores(t *testing.T) {
+	m, _ := newTestManager(Config{MaxScores: 10})
+	for i := 0; i < 100; i++ {
+		m.Misbehaving(net.IPv4(10, 0, 0, byte(i)), &[32]byte{byte(i)}, ReasonSpam)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.hostScore) > 10 || len(m.keyScore) > 10 {
+		t.Fatalf("kept %d host and %d key scores", len(m.hostScore), len(m.keyScore))
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.dat")
+	m, clock := newTestManager(Config{Path: path})
+	m.BanHost(host, time.Hour)
+	m.BanKey([32]byte{3}, 2*time.Hour)
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(90 * time.Minute)
+	loaded := New(Config{Path: path, Clock: clock})
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.IsBanned(host) {
+		t.Fatal("expired ban was loaded")
+	}
+	bans := loaded.List()
+	if len(bans) != 1 || !bans[0].IsKey() || bans[0].Reason != ReasonManual {
+		t.Fatalf("loaded %v", bans)
+	}
+}
@@ -0,0 +1,97 @@
+package ban
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/internal/atomicfile"
+)
+
+var fileMagic = dnet.NewTag("DNBL")
+
+const fileVersion = 1
+
+// Save writes the current bans to Config.Path.
+// The file is replaced atomically.
+func (m *Manager) Save() error {
+	if m.cfg.Path == "" {
+		return nil
+	}
+	bans := m.List()
+	e := codec.Encode(16 + len(bans)*64)
+	e.UInt32be(uint32(fileMagic))
+	e.UInt32le(fileVersion)
+	e.VarUInt(uint64(len(bans)))
+	for _, b := range bans {
+		e.Bool(b.IsKey())
+		if b.IsKey() {
+			e.Bytes(b.PubKey[:])
+		} else {
+			e.VarString(b.Host)
+		}
+		e.UInt8(uint8(b.Reason))
+		e.Int64le(b.Created.Unix())
+		e.Int64le(b.Expires.Unix())
+	}
+	return atomicfile.Write(m.cfg.Path, e.Result())
+}
+
+// Load adds the bans in Config.Path, skipping expired ones.
+// A missing file is not an error.
+func (m *Manager) Load() (err error) {
+	if m.cfg.Path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.cfg.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("ban: corrupt file %v: %v", m.cfg.Path, r)
+		}
+	}()
+	d := codec.Decode(data)
+	if dnet.Tag4CC(d.UInt32be()) != fileMagic {
+		return fmt.Errorf("ban: not a ban list: %v", m.cfg.Path)
+	}
+	if ver := d.UInt32le(); ver != fileVersion {
+		return fmt.Errorf("ban: unsupported file version %d: %v", ver, m.cfg.Path)
+	}
+	count := d.VarUInt()
+	var bans []Ban
+	for i := uint64(0); i < count; i++ {
+		var b Ban
+		if d.Bool() {
+			copy(b.PubKey[:], d.Bytes(32))
+		} else {
+			b.Host = d.VarString()
+		}
+		b.Reason = Reason(d.UInt8())
+		b.Created = time.Unix(d.Int64le(), 0)
+		b.Expires = time.Unix(d.Int64le(), 0)
+		bans = append(bans, b)
+	}
+	now := m.cfg.Clock.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range bans {
+		b := bans[i]
+		if !now.Before(b.Expires) {
+			continue
+		}
+		if b.IsKey() {
+			m.keyBans[b.PubKey] = &b
+		} else {
+			m.hostBans[b.Host] = &b
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package bootstrap
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/peer"
+)
+
+// Resolver looks up the addresses of a DNS seed.
+// *net.Resolver implements this; to use a local stand-in DNS server,
+// pass a net.Resolver with PreferGo set and a Dial func that connects
+// to it, or any other implementation.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) (addrs []string, err error)
+}
+
+// DNSSeeds are the default DNS seed hostnames, optionally "host:port".
+var DNSSeeds = []string{}
+
+// FallbackSeeds are the hardcoded "host:port" seeds used when no other
+// seeds are found.
+var FallbackSeeds = []string{}
+
+// Config lists where to find seeds. A nil DNSSeeds or Fallback uses the
+// package defaults; set an empty slice to disable them.
+type Config struct {
+	DNSSeeds   []string                   // seed hostnames, optionally "host:port" (default DNSSeeds)
+	SeedFile   string                     // optional file of "host:port" lines ('#' starts a comment)
+	Fallback   []string                   // "host:port" seeds to use if no others are found (default FallbackSeeds)
+	Resolver   Resolver                   // default net.DefaultResolver
+	Timeout    time.Duration              // timeout per DNS lookup (default 10s)
+	Discovered func(addrs []dnet.Address) // optional; called with the addresses found
+}
+
+var ErrNoSeeds = errors.New("bootstrap: no seed addresses found")
+var ErrNoSeedConfig = errors.New("bootstrap: no DNS seeds, seed file or fallback seeds configured")
+
+// Bootstrap gathers peer addresses from the DNS seeds and the seed file,
+// falling back to the hardcoded seeds if those yield nothing.
+// Errors from individual seeds are returned alongside any addresses found.
+// Returns ErrNoSeedConfig if there are no seeds to try at all.
+func Bootstrap(ctx context.Context, cfg Config) ([]dnet.Address, []error) {
+	if cfg.DNSSeeds == nil {
+		cfg.DNSSeeds = DNSSeeds
+	}
+	if cfg.Fallback == nil {
+		cfg.Fallback = FallbackSeeds
+	}
+	if len(cfg.DNSSeeds) == 0 && cfg.SeedFile == "" && len(cfg.Fallback) == 0 {
+		return nil, []error{ErrNoSeedConfig}
+	}
+	var found []dnet.Address
+	var errs []error
+	if cfg.SeedFile != "" {
+		addrs, err := ReadSeedFile(cfg.SeedFile)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		found = append(found, addrs...)
+	}
+	addrs, lookupErrs := LookupSeeds(ctx, cfg.Resolver, cfg.DNSSeeds, cfg.Timeout)
+	found = append(found, addrs...)
+	errs = append(errs, lookupErrs...)
+	if len(found) == 0 {
+		addrs, err := ParseSeeds(strings.NewReader(strings.Join(cfg.Fallback, "\n")))
+		if err != nil {
+			errs = append(errs, err)
+		}
+		found = addrs
+	}
+	found = dedup(found)
+	if len(found) == 0 {
+		errs = append(errs, ErrNoSeeds)
+	} else if cfg.Discovered != nil {
+		cfg.Discovered(found)
+	}
+	return found, errs
+}
+
+// LookupSeeds resolves DNS seed hostnames. Seeds without a port use
+// dnet.DogeNetDefaultPort.
+func LookupSeeds(ctx context.Context, resolver Resolver, seeds []string, timeout time.Duration) ([]dnet.Address, []error) {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	var found []dnet.Address
+	var errs []error
+	for _, seed := range seeds {
+		host, port, err := splitSeed(seed)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		lctx, cancel := context.WithTimeout(ctx, timeout)
+		ips, err := resolver.LookupHost(lctx, host)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("bootstrap: seed %v: %w", host, err))
+			continue
+		}
+		for _, ip := range ips {
+			addr, err := dnet.ParseAddress(net.JoinHostPort(ip, strconv.Itoa(int(port))))
+			if err == nil && addr.IsValid() {
+				found = append(found, addr)
+			}
+		}
+	}
+	return found, errs
+}
+
+func splitSeed(seed string) (string, uint16, error) {
+	host, ports, err := net.SplitHostPort(seed)
+	if err != nil {
+		// no port
+		return seed, dnet.DogeNetDefaultPort, nil
+	}
+	port, err := strconv.ParseUint(ports, 10, 16)
+	if err != nil || port == 0 {
+		return "", 0, fmt.Errorf("bootstrap: seed %v: bad port", seed)
+	}
+	return host, uint16(port), nil
+}
+
+// ReadSeedFile reads a static seed list.
+func ReadSeedFile(path string) ([]dnet.Address, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	addrs, err := ParseSeeds(f)
+	if err != nil {
+		return addrs, fmt.Errorf("%v: %w", path, err)
+	}
+	return addrs, nil
+}
+
+// ParseSeeds parses "host:port" lines with dnet.ParseAddress.
+// Blank lines and '#' comments are ignored. Parsing continues past
+// bad lines; the first error is returned with the good addresses.
+func ParseSeeds(r io.Reader) ([]dnet.Address, error) {
+	var addrs []dnet.Address
+	var first error
+	scan := bufio.NewScanner(r)
+	line := 0
+	for scan.Scan() {
+		line++
+		text := scan.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		addr, err := dnet.ParseAddress(text)
+		if err == nil && !addr.IsValid() {
+			err = errors.New("invalid address")
+		}
+		if err != nil {
+			if first == nil {
+				first = fmt.Errorf("line %d: %q: %v", line, text, err)
+			}
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	if err := scan.Err(); err != nil && first == nil {
+		first = err
+	}
+	return addrs, first
+}
+
+// ConnectSeeds dials seed addresses in random order until the
+// manager's outbound slots are full or the seeds run out.
+// Returns the number of peers connected.
+func ConnectSeeds(ctx context.Context, mgr *peer.Manager, addrs []dnet.Address) int {
+	order := rand.New(rand.NewSource(time.Now().UnixNano())).Perm(len(addrs))
+	connected := 0
+	for _, i := range order {
+		if mgr.FreeOutbound() <= 0 || ctx.Err() != nil {
+			break
+		}
+		if _, err := mgr.ConnectContext(ctx, addrs[i]); err == nil {
+			connected++
+		}
+	}
+	return connected
+}
+
+func dedup(addrs []dnet.Address) []dnet.Address {
+	seen := make(map[string]bool, len(addrs))
+	out := addrs[:0]
+	for _, a := range addrs {
+		key := a.String()
+		if !seen[key] {
+			seen[key] = true
+			out = append(out, a)
+		}
+	}
+	return out
+}
@@ -0,0 +1,197 @@
+package bootstrap
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"code.dogecoin.org/gossip/dnet"
+)
+
+// fakeResolver answers from a map of host names.
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if addrs, ok := r[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// dnsServer is a stand-in DNS server answering A queries from a map,
+// over net.Pipe connections using DNS-over-TCP framing.
+type dnsServer map[string][]net.IP
+
+// resolver returns a net.Resolver that sends every query to s.
+func (s dnsServer) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go s.serve(server)
+			return client, nil
+		},
+	}
+}
+
+func (s dnsServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [2]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+		reply := s.answer(query)
+		binary.BigEndian.PutUint16(size[:], uint16(len(reply)))
+		if _, err := conn.Write(append(size[:], reply...)); err != nil {
+			return
+		}
+	}
+}
+
+// answer builds the reply to a single-question query.
+func (s dnsServer) answer(query []byte) []byte {
+	// question: labels, then type and class
+	i := 12
+	var labels []string
+	for query[i] != 0 {
+		n := int(query[i])
+		labels = append(labels, string(query[i+1:i+1+n]))
+		i += 1 + n
+	}
+	qtype := binary.BigEndian.Uint16(query[i+1:])
+	question := query[12 : i+5]
+	name := strings.ToLower(strings.Join(labels, "."))
+
+	var ips []net.IP
+	for _, ip := range s[name] {
+		if ip4 := ip.To4(); ip4 != nil && qtype == 1 {
+			ips = append(ips, ip4)
+		}
+	}
+	rcode := uint16(0)
+	if _, ok := s[name]; !ok {
+		rcode = 3 // NXDOMAIN
+	}
+	reply := make([]byte, 12, 512)
+	copy(reply, query[:2])                              // ID
+	binary.BigEndian.PutUint16(reply[2:], 0x8180|rcode) // response, recursion available
+	binary.BigEndian.PutUint16(reply[4:], 1)
+	binary.BigEndian.PutUint16(reply[6:], uint16(len(ips)))
+	reply = append(reply, question...)
+	for _, ip := range ips {
+		reply = append(reply, 0xc0, 12) // name: pointer to the question
+		reply = append(reply, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+		reply = append(reply, ip...)
+	}
+	return reply
+}
+
+func sorted(addrs []string) []string {
+	sort.Strings(addrs)
+	return addrs
+}
+
+func TestLookupSeedsStandInDNS(t *testing.T) {
+	server := dnsServer{
+		"seed.example.test": {net.IPv4(8, 8, 8, 8), net.IPv4(9, 9, 9, 9)},
+		"port.example.test": {net.IPv4(1, 1, 1, 1)},
+	}
+	seeds := []string{"seed.example.test", "port.example.test:1234", "missing.example.test"}
+	addrs, errs := LookupSeeds(context.Background(), server.resolver(), seeds, 0)
+	var got []string
+	for _, a := range addrs {
+		got = append(got, a.String())
+	}
+	want := []string{"1.1.1.1:1234", "8.8.8.8:42069", "9.9.9.9:42069"}
+	if strings.Join(sorted(got), " ") != strings.Join(want, " ") {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	var dnsErr *net.DNSError
+	if len(errs) != 1 || !errors.As(errs[0], &dnsErr) || !dnsErr.IsNotFound {
+		t.Fatalf("errors %v", errs)
+	}
+}
+
+func TestBootstrapFallback(t *testing.T) {
+	var discovered int
+	addrs, errs := Bootstrap(context.Background(), Config{
+		DNSSeeds:   []string{"down.example.test"},
+		Fallback:   []string{"8.8.4.4:42069", "8.8.4.4:42069"},
+		Resolver:   fakeResolver{},
+		Discovered: func(a []dnet.Address) { discovered = len(a) },
+	})
+	if len(addrs) != 1 || addrs[0].String() != "8.8.4.4:42069" || discovered != 1 {
+		t.Fatalf("addresses %v, discovered %d", addrs, discovered)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("errors %v", errs)
+	}
+}
+
+func TestBootstrapSeedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seeds.txt")
+	data := "# seeds\n8.8.8.8:42069\n\n[2001:db8::1]:22556 # v6\nnot an address\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	addrs, errs := Bootstrap(context.Background(), Config{
+		SeedFile: path,
+		DNSSeeds: []string{"seed.example.test"},
+		Fallback: []string{"1.1.1.1:1"},
+		Resolver: fakeResolver{"seed.example.test": {"8.8.8.8", "9.9.9.9"}},
+	})
+	var got []string
+	for _, a := range addrs {
+		got = append(got, a.String())
+	}
+	want := []string{"8.8.8.8:42069", "9.9.9.9:42069", "[2001:db8::1]:22556"}
+	if strings.Join(sorted(got), " ") != strings.Join(sorted(want), " ") {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "line 5") {
+		t.Fatalf("errors %v", errs)
+	}
+}
+
+func TestBootstrapDefaultSeeds(t *testing.T) {
+	defer func(dns, fallback []string) { DNSSeeds, FallbackSeeds = dns, fallback }(DNSSeeds, FallbackSeeds)
+	DNSSeeds = []string{"seed.example.test"}
+	FallbackSeeds = []string{"1.1.1.1:1"}
+	resolver := fakeResolver{"seed.example.test": {"8.8.8.8"}}
+
+	addrs, errs := Bootstrap(context.Background(), Config{Resolver: resolver})
+	if len(addrs) != 1 || addrs[0].String() != "8.8.8.8:42069" || len(errs) != 0 {
+		t.Fatalf("DNS seeds: got %v %v", addrs, errs)
+	}
+	addrs, _ = Bootstrap(context.Background(), Config{Resolver: fakeResolver{}})
+	if len(addrs) != 1 || addrs[0].String() != "1.1.1.1:1" {
+		t.Fatalf("fallback seeds: got %v", addrs)
+	}
+	addrs, errs = Bootstrap(context.Background(), Config{DNSSeeds: []string{}, Fallback: []string{}, Resolver: resolver})
+	if len(addrs) != 0 || len(errs) != 1 || errs[0] != ErrNoSeedConfig {
+		t.Fatalf("defaults disabled: got %v %v", addrs, errs)
+	}
+}
+
+func TestBootstrapRequiresSeeds(t *testing.T) {
+	addrs, errs := Bootstrap(context.Background(), Config{})
+	if len(addrs) != 0 || len(errs) != 1 || errs[0] != ErrNoSeedConfig {
+		t.Fatalf("got %v %v", addrs, errs)
+	}
+	_, errs = Bootstrap(context.Background(), Config{DNSSeeds: []string{"x.test"}, Resolver: fakeResolver{}})
+	if errs[len(errs)-1] != ErrNoSeeds {
+		t.Fatalf("errors %v", errs)
+	}
+}
@@ -114,3 +114,15 @@ func (d *Decoder) PadString(size int) string {
 	}
 	return ""
 }
+
+// TryDecode runs a decoder, reporting false if it panicked on
+// malformed input (Decoder methods panic when they run out of bytes.)
+func TryDecode(decode func()) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	decode()
+	return true
+}
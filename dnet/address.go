@@ -4,59 +4,188 @@ import (
 	"encoding/binary"
 	"errors"
 	"net"
-	"strconv"
+	"net/netip"
 )
 
 // Address is an IP:Port combination.
+//
+// IPv4-mapped IPv6 addresses are stored as IPv4, so addresses compare
+// equal however they were parsed or decoded. On the wire (ToBytes) an
+// address is 16 bytes, IPv4 being IPv4-mapped, then a big-endian port.
 type Address struct {
-	Host net.IP
-	Port uint16
+	ap netip.AddrPort
+}
+
+// NewAddress returns the Address for an IP and port.
+func NewAddress(ip netip.Addr, port uint16) Address {
+	return Address{ap: netip.AddrPortFrom(ip.Unmap(), port)}
+}
+
+// AddressFromIP returns the Address for a net.IP and port
+// (the zero Address if ip is not a valid IP.)
+func AddressFromIP(ip net.IP, port uint16) Address {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return Address{}
+	}
+	return NewAddress(addr, port)
+}
+
+// AddressFromAddrPort returns the Address for a netip.AddrPort.
+func AddressFromAddrPort(ap netip.AddrPort) Address {
+	return NewAddress(ap.Addr(), ap.Port())
+}
+
+// Host is the IP address.
+func (a Address) Host() netip.Addr {
+	return a.ap.Addr()
+}
+
+// IP is the IP address as a net.IP (nil for the zero Address.)
+func (a Address) IP() net.IP {
+	if !a.ap.Addr().IsValid() {
+		return nil
+	}
+	return net.IP(a.ap.Addr().AsSlice())
+}
+
+func (a Address) Port() uint16 {
+	return a.ap.Port()
+}
+
+func (a Address) AddrPort() netip.AddrPort {
+	return a.ap
 }
 
 func (a Address) String() string {
-	return net.JoinHostPort(a.Host.String(), strconv.Itoa(int(a.Port)))
+	if !a.ap.Addr().IsValid() {
+		return "invalid"
+	}
+	return a.ap.String()
 }
 
+// IsValid reports whether the address has an IP and a non-zero port.
 func (a Address) IsValid() bool {
-	return a.Port != 0 && (len(a.Host) == 16 || len(a.Host) == 4)
+	return a.ap.Addr().IsValid() && a.ap.Port() != 0
 }
 
+// ToBytes encodes the address as 16 bytes (IPv4 is IPv4-mapped)
+// followed by a big-endian port.
 func (a Address) ToBytes() []byte {
 	buf := [18]byte{}
-	copy(buf[0:16], a.Host)
-	binary.BigEndian.PutUint16(buf[16:], a.Port)
+	if a.ap.Addr().IsValid() {
+		ip16 := a.ap.Addr().As16() // IPv4-mapped for IPv4
+		copy(buf[0:16], ip16[:])
+	}
+	binary.BigEndian.PutUint16(buf[16:], a.ap.Port())
 	return buf[:]
 }
 
 func (a Address) Equal(other Address) bool {
-	return a.Host.Equal(other.Host) && a.Port == other.Port
+	return a.ap == other.ap
 }
 
-func ParseAddress(hostport string) (Address, error) {
-	hosts, ports, err := net.SplitHostPort(hostport)
-	if err != nil {
-		return Address{}, err
+// IsLoopback reports whether the IP is a loopback address.
+func (a Address) IsLoopback() bool {
+	return a.ap.Addr().IsLoopback()
+}
+
+// IsPrivate reports whether the IP is in a private range
+// (RFC 1918 for IPv4, RFC 4193 for IPv6.)
+func (a Address) IsPrivate() bool {
+	return a.ap.Addr().IsPrivate()
+}
+
+// IsLocal reports whether the IP refers to this host or link:
+// unspecified, 0.0.0.0/8, loopback or link-local.
+func (a Address) IsLocal() bool {
+	ip := a.ap.Addr()
+	return ip.IsUnspecified() || ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		(ip.Is4() && ip.As4()[0] == 0)
+}
+
+// Reserved ranges that are not reachable on the public internet.
+var unroutable = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),   // RFC 6598 shared address space
+	netip.MustParsePrefix("192.0.0.0/24"),    // RFC 6890 IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // RFC 5737 documentation
+	netip.MustParsePrefix("198.18.0.0/15"),   // RFC 2544 benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // RFC 5737 documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // RFC 5737 documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     // RFC 1112 reserved (and broadcast)
+	netip.MustParsePrefix("2001:db8::/32"),   // RFC 3849 documentation
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // RFC 8215 local-use translation
+	netip.MustParsePrefix("fec0::/10"),       // RFC 3879 deprecated site-local
+}
+
+// IsRoutable reports whether the IP can be reached over the public
+// internet: not local, private, multicast or in a reserved range.
+func (a Address) IsRoutable() bool {
+	ip := a.ap.Addr()
+	if !ip.IsValid() || a.IsLocal() || ip.IsPrivate() || ip.IsMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsLinkLocalMulticast() {
+		return false
+	}
+	for _, p := range unroutable {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// NetGroup identifies the network the IP belongs to: the /16 of an
+// IPv4 address or the /32 of an IPv6 address, with a type prefix.
+// Unspecified and invalid addresses are all in group {0}.
+func (a Address) NetGroup() []byte {
+	ip := a.ap.Addr()
+	if !ip.IsValid() || ip.IsUnspecified() {
+		return []byte{0}
 	}
-	host := net.ParseIP(hosts)
-	if host == nil {
-		return Address{}, errors.New("bad ip")
+	if ip.Is4() {
+		b := ip.As4()
+		return []byte{4, b[0], b[1]}
 	}
-	port, err := strconv.Atoi(ports)
+	b := ip.As16()
+	return []byte{6, b[0], b[1], b[2], b[3]}
+}
+
+// MarshalText implements encoding.TextMarshaler ("ip:port", "[ip6]:port".)
+func (a Address) MarshalText() ([]byte, error) {
+	if a.ap == (netip.AddrPort{}) {
+		return []byte{}, nil
+	}
+	return []byte(a.ap.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (a *Address) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*a = Address{}
+		return nil
+	}
+	addr, err := ParseAddress(string(text))
+	if err != nil {
+		return err
+	}
+	*a = addr
+	return nil
+}
+
+func ParseAddress(hostport string) (Address, error) {
+	ap, err := netip.ParseAddrPort(hostport)
 	if err != nil {
 		return Address{}, err
 	}
-	if port < 0 || port > 65535 {
-		return Address{}, errors.New("range")
+	if ap.Addr().Zone() != "" {
+		return Address{}, errors.New("bad ip: zones are not supported")
 	}
-	return Address{Host: host, Port: uint16(port)}, nil
+	return AddressFromAddrPort(ap), nil
 }
 
 func AddressFromBytes(addr []byte) (Address, error) {
 	if len(addr) != 18 {
 		return Address{}, errors.New("wrong address length")
 	}
-	return Address{
-		Host: net.IP(addr[0:16]),
-		Port: binary.BigEndian.Uint16(addr[16:]),
-	}, nil
+	return NewAddress(netip.AddrFrom16(*(*[16]byte)(addr[0:16])), binary.BigEndian.Uint16(addr[16:])), nil
 }
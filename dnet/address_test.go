@@ -0,0 +1,80 @@
+package dnet
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestIsRoutable(t *testing.T) {
+	for ip, want := range map[string]bool{
+		"8.8.8.8":         true,
+		"2a00:1450::1":    true,
+		"0.1.2.3":         false,
+		"10.1.2.3":        false,
+		"100.64.0.1":      false,
+		"127.0.0.1":       false,
+		"169.254.1.1":     false,
+		"172.16.0.1":      false,
+		"192.0.2.1":       false,
+		"192.168.1.1":     false,
+		"198.18.0.1":      false,
+		"203.0.113.9":     false,
+		"224.0.0.1":       false,
+		"255.255.255.255": false,
+		"::":              false,
+		"::1":             false,
+		"::ffff:10.0.0.1": false,
+		"::ffff:8.8.8.8":  true,
+		"2001:db8::1":     false,
+		"64:ff9b:1::1":    false,
+		"fc00::1":         false,
+		"fe80::1":         false,
+		"fec0::1":         false,
+		"feff:ffff::1":    false,
+		"ff02::1":         false,
+	} {
+		a := NewAddress(netip.MustParseAddr(ip), 22556)
+		if a.IsRoutable() != want {
+			t.Errorf("%v routable: %v, want %v", ip, a.IsRoutable(), want)
+		}
+	}
+	if (Address{}).IsRoutable() {
+		t.Error("zero Address is routable")
+	}
+}
+
+func TestAddressBytes(t *testing.T) {
+	for _, s := range []string{"8.8.8.8:22556", "[2a00:1450::1]:42069"} {
+		a, err := ParseAddress(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := a.ToBytes()
+		if len(b) != 18 || a.String() != s {
+			t.Fatalf("%v: %x %v", s, b, a)
+		}
+		ip, _ := netip.AddrFromSlice(b[:16])
+		if back := NewAddress(ip, a.Port()); !back.Equal(a) {
+			t.Fatalf("%v decoded as %v", s, back)
+		}
+	}
+	mapped := NewAddress(netip.MustParseAddr("::ffff:8.8.8.8"), 1)
+	if !mapped.Equal(NewAddress(netip.MustParseAddr("8.8.8.8"), 1)) || !mapped.Host().Is4() {
+		t.Fatalf("mapped address %v", mapped)
+	}
+}
+
+func TestNetGroup(t *testing.T) {
+	group := func(s string) string {
+		return string(NewAddress(netip.MustParseAddr(s), 1).NetGroup())
+	}
+	if group("8.8.8.8") != group("8.8.4.4") || group("8.8.8.8") == group("8.9.8.8") {
+		t.Fatal("IPv4 groups are not /16")
+	}
+	if group("2a00:1450::1") != group("2a00:1450:ffff::1") || group("2a00:1450::1") == group("2a00:1451::1") {
+		t.Fatal("IPv6 groups are not /32")
+	}
+	if group("::") != "\x00" || string(Address{}.NetGroup()) != "\x00" {
+		t.Fatal("unspecified group")
+	}
+}
@@ -0,0 +1,22 @@
+package dnet
+
+import "time"
+
+// Clock supplies the current time.
+// Components take a Clock so a simulation can drive them (and
+// DogeTime) from a virtual clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the real wall clock.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// DogeNowAt returns the current Doge Epoch time according to a clock.
+func DogeNowAt(clock Clock) DogeTime {
+	return UnixToDoge(clock.Now())
+}
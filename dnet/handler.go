@@ -1,6 +1,13 @@
 package dnet
 
-import "encoding/binary"
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"code.dogecoin.org/gossip/codec"
+)
 
 const BindMessageSize = 4 + 4 + 32
 
@@ -27,3 +34,260 @@ func DecodeBindMessage(payload []byte) (msg BindMessage, ok bool) {
 	}
 	return BindMessage{}, false
 }
+
+// Bind v2 allows a handler to bind several channels on one connection,
+// filter each channel by tag and sender, and negotiate capabilities.
+//
+// Wire format: [4] Version (2, little-endian) [4] Size (little-endian) [Size] body.
+// A v1 BindMessage is recognised by a Version of 0 or 1; other versions
+// are rejected.
+
+const BindVersion2 = 2
+const MaxBindSize = 0x10000 // 64K body limit for v2 binds and acks
+
+// Bind capability flags.
+const (
+	BindCapCompression uint32 = 1 << 0 // payload compression
+	BindCapSigV2       uint32 = 1 << 1 // v2 signatures
+	BindCapHistory     uint32 = 1 << 2 // history replay on bind
+)
+
+// Bind ack status codes.
+const (
+	BindStatusOK       uint8 = 0
+	BindStatusRejected uint8 = 1
+)
+
+type BindMessageV2 struct {
+	Version  uint32 // 1 if decoded from a v1 BindMessage
+	Caps     uint32 // requested capability flags
+	PubKey   [32]byte
+	Channels []BindChannel
+}
+
+type BindChannel struct {
+	Chan    Tag4CC
+	Tags    []Tag4CC   // only receive these tags (empty: all tags)
+	Senders [][32]byte // only receive messages signed by these keys (empty: all senders)
+}
+
+type BindAck struct {
+	Status   uint8
+	Caps     uint32   // capabilities accepted by the node
+	PubKey   [32]byte // node public key
+	Channels []Tag4CC // channels the node bound
+}
+
+// Wants reports whether a message with this tag and sender passes the channel filters.
+func (b BindChannel) Wants(tag Tag4CC, pub []byte) bool {
+	if len(b.Tags) > 0 {
+		found := false
+		for _, t := range b.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(b.Senders) > 0 {
+		for _, s := range b.Senders {
+			if string(s[:]) == string(pub) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Channel returns the binding for a channel, if bound.
+func (msg BindMessageV2) Channel(ch Tag4CC) (BindChannel, bool) {
+	for _, b := range msg.Channels {
+		if b.Chan == ch {
+			return b, true
+		}
+	}
+	return BindChannel{}, false
+}
+
+// ChannelTags returns the bound channel tags.
+func (msg BindMessageV2) ChannelTags() []Tag4CC {
+	tags := make([]Tag4CC, len(msg.Channels))
+	for i, b := range msg.Channels {
+		tags[i] = b.Chan
+	}
+	return tags
+}
+
+func (msg BindMessageV2) Encode() []byte {
+	e := codec.Encode(8 + 4 + 32 + 1 + 6*len(msg.Channels))
+	e.UInt32le(BindVersion2)
+	e.UInt32le(0) // size, filled in below
+	e.UInt32le(msg.Caps)
+	e.Bytes(msg.PubKey[:])
+	e.VarUInt(uint64(len(msg.Channels)))
+	for _, b := range msg.Channels {
+		e.UInt32be(uint32(b.Chan))
+		e.VarUInt(uint64(len(b.Tags)))
+		for _, t := range b.Tags {
+			e.UInt32be(uint32(t))
+		}
+		e.VarUInt(uint64(len(b.Senders)))
+		for _, s := range b.Senders {
+			e.Bytes(s[:])
+		}
+	}
+	return withBindSize(e.Result())
+}
+
+func (ack BindAck) Encode() []byte {
+	e := codec.Encode(8 + 1 + 4 + 32 + 1 + 4*len(ack.Channels))
+	e.UInt32le(BindVersion2)
+	e.UInt32le(0) // size, filled in below
+	e.UInt8(ack.Status)
+	e.UInt32le(ack.Caps)
+	e.Bytes(ack.PubKey[:])
+	e.VarUInt(uint64(len(ack.Channels)))
+	for _, ch := range ack.Channels {
+		e.UInt32be(uint32(ch))
+	}
+	return withBindSize(e.Result())
+}
+
+func withBindSize(buf []byte) []byte {
+	if len(buf)-8 > MaxBindSize {
+		panic("Invalid bind: larger than MaxBindSize")
+	}
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(len(buf)-8))
+	return buf
+}
+
+// DecodeBindMessageV2 decodes the body of a v2 bind (after Version and Size.)
+func DecodeBindMessageV2(body []byte) (msg BindMessageV2, err error) {
+	defer recoverDecode(&err)
+	d := codec.Decode(body)
+	msg.Version = BindVersion2
+	msg.Caps = d.UInt32le()
+	copy(msg.PubKey[:], d.Bytes(32))
+	nchan := d.VarUInt()
+	if nchan > uint64(len(body)) {
+		return BindMessageV2{}, errors.New("bind: too many channels")
+	}
+	msg.Channels = make([]BindChannel, nchan)
+	for n := range msg.Channels {
+		b := &msg.Channels[n]
+		b.Chan = Tag4CC(d.UInt32be())
+		for _, prev := range msg.Channels[:n] {
+			if prev.Chan == b.Chan {
+				return BindMessageV2{}, fmt.Errorf("bind: duplicate channel [%s]", b.Chan)
+			}
+		}
+		ntag := d.VarUInt()
+		if ntag > uint64(len(body)) {
+			return BindMessageV2{}, errors.New("bind: too many tags")
+		}
+		for i := uint64(0); i < ntag; i++ {
+			b.Tags = append(b.Tags, Tag4CC(d.UInt32be()))
+		}
+		nsend := d.VarUInt()
+		if nsend > uint64(len(body)) {
+			return BindMessageV2{}, errors.New("bind: too many senders")
+		}
+		for i := uint64(0); i < nsend; i++ {
+			b.Senders = append(b.Senders, *(*[32]byte)(d.Bytes(32)))
+		}
+	}
+	return msg, nil
+}
+
+// DecodeBindAck decodes the body of a bind ack (after Version and Size.)
+func DecodeBindAck(body []byte) (ack BindAck, err error) {
+	defer recoverDecode(&err)
+	d := codec.Decode(body)
+	ack.Status = d.UInt8()
+	ack.Caps = d.UInt32le()
+	copy(ack.PubKey[:], d.Bytes(32))
+	nchan := d.VarUInt()
+	if nchan > uint64(len(body)) {
+		return BindAck{}, errors.New("bind ack: too many channels")
+	}
+	for i := uint64(0); i < nchan; i++ {
+		ack.Channels = append(ack.Channels, Tag4CC(d.UInt32be()))
+	}
+	return ack, nil
+}
+
+// ReadBind reads a v1 or v2 bind from a handler connection.
+// A v1 BindMessage is returned as a BindMessageV2 with Version 1
+// and a single unfiltered channel.
+func ReadBind(r io.Reader) (BindMessageV2, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[0:4]); err != nil {
+		return BindMessageV2{}, err
+	}
+	version := binary.LittleEndian.Uint32(hdr[0:4])
+	if version > BindVersion2 {
+		return BindMessageV2{}, fmt.Errorf("bind: unsupported version %d", version)
+	}
+	if version < BindVersion2 {
+		buf := [BindMessageSize]byte{}
+		copy(buf[0:4], hdr[0:4])
+		if _, err := io.ReadFull(r, buf[4:]); err != nil {
+			return BindMessageV2{}, err
+		}
+		v1, _ := DecodeBindMessage(buf[:])
+		return BindMessageV2{
+			Version:  1,
+			PubKey:   v1.PubKey,
+			Channels: []BindChannel{{Chan: v1.Chan}},
+		}, nil
+	}
+	body, err := readBindBody(r, hdr[:])
+	if err != nil {
+		return BindMessageV2{}, err
+	}
+	return DecodeBindMessageV2(body)
+}
+
+// ReadBindAck reads a v2 bind ack from the node.
+func ReadBindAck(r io.Reader) (BindAck, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(r, hdr[0:4]); err != nil {
+		return BindAck{}, err
+	}
+	if version := binary.LittleEndian.Uint32(hdr[0:4]); version != BindVersion2 {
+		if version < BindVersion2 {
+			return BindAck{}, errors.New("bind ack: node does not support bind v2")
+		}
+		return BindAck{}, fmt.Errorf("bind ack: unsupported version %d", version)
+	}
+	body, err := readBindBody(r, hdr[:])
+	if err != nil {
+		return BindAck{}, err
+	}
+	return DecodeBindAck(body)
+}
+
+func readBindBody(r io.Reader, hdr []byte) ([]byte, error) {
+	if _, err := io.ReadFull(r, hdr[4:8]); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(hdr[4:8])
+	if size > MaxBindSize {
+		return nil, fmt.Errorf("bind: too large: %d bytes", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func recoverDecode(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("bind: malformed: %v", r)
+	}
+}
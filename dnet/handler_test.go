@@ -0,0 +1,90 @@
+package dnet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestReadBindV1(t *testing.T) {
+	for _, version := range []uint32{0, 1} {
+		msg := BindMessage{Version: version, Chan: ChannelChat, PubKey: [32]byte{7}}
+		bind, err := ReadBind(bytes.NewReader(msg.Encode()))
+		if err != nil {
+			t.Fatalf("version %d: %v", version, err)
+		}
+		if bind.Version != 1 || bind.PubKey != msg.PubKey || len(bind.Channels) != 1 || bind.Channels[0].Chan != ChannelChat {
+			t.Fatalf("version %d: got %+v", version, bind)
+		}
+	}
+}
+
+func TestReadBindV2(t *testing.T) {
+	msg := BindMessageV2{
+		Version: BindVersion2,
+		Caps:    BindCapHistory,
+		PubKey:  [32]byte{1, 2, 3},
+		Channels: []BindChannel{
+			{Chan: ChannelChat, Tags: []Tag4CC{NewTag("Msg ")}},
+			{Chan: ChannelB0rk, Senders: [][32]byte{{9}}},
+		},
+	}
+	bind, err := ReadBind(bytes.NewReader(msg.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(bind, msg) {
+		t.Fatalf("got %+v, want %+v", bind, msg)
+	}
+}
+
+func TestReadBindRejectsUnknownVersion(t *testing.T) {
+	buf := BindMessageV2{Channels: []BindChannel{{Chan: ChannelChat}}}.Encode()
+	binary.LittleEndian.PutUint32(buf[0:4], 3)
+	if _, err := ReadBind(bytes.NewReader(buf)); err == nil {
+		t.Fatal("version 3 bind accepted")
+	}
+	ack := BindAck{Channels: []Tag4CC{ChannelChat}}.Encode()
+	binary.LittleEndian.PutUint32(ack[0:4], 3)
+	if _, err := ReadBindAck(bytes.NewReader(ack)); err == nil {
+		t.Fatal("version 3 ack accepted")
+	}
+}
+
+func TestReadBindRejectsDuplicateChannel(t *testing.T) {
+	msg := BindMessageV2{Channels: []BindChannel{{Chan: ChannelChat}, {Chan: ChannelChat}}}
+	if _, err := ReadBind(bytes.NewReader(msg.Encode())); err == nil {
+		t.Fatal("duplicate channel accepted")
+	}
+}
+
+func TestReadBindAck(t *testing.T) {
+	ack := BindAck{Status: BindStatusOK, Caps: BindCapHistory, PubKey: [32]byte{5}, Channels: []Tag4CC{ChannelChat, ChannelB0rk}}
+	got, err := ReadBindAck(bytes.NewReader(ack.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, ack) {
+		t.Fatalf("got %+v, want %+v", got, ack)
+	}
+}
+
+func TestBindChannelWants(t *testing.T) {
+	tag := NewTag("Msg ")
+	sender := [32]byte{1}
+	b := BindChannel{Chan: ChannelChat, Tags: []Tag4CC{tag}, Senders: [][32]byte{sender}}
+	if !b.Wants(tag, sender[:]) {
+		t.Fatal("matching message filtered")
+	}
+	if b.Wants(NewTag("Othr"), sender[:]) {
+		t.Fatal("other tag passed the filter")
+	}
+	other := [32]byte{2}
+	if b.Wants(tag, other[:]) {
+		t.Fatal("other sender passed the filter")
+	}
+	if !(BindChannel{Chan: ChannelChat}).Wants(tag, other[:]) {
+		t.Fatal("unfiltered channel filtered a message")
+	}
+}
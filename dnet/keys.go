@@ -1,6 +1,7 @@
 package dnet
 
 import (
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
 	"github.com/dogeorg/doge"
 )
 
@@ -29,3 +30,16 @@ func GenerateKeyPair() (KeyPair, error) {
 	}
 	return KeyPairFromPrivKey(priv), nil
 }
+
+// ValidPubKey reports whether pub is a usable Schnorr public key:
+// the X coordinate of a point on the secp256k1 curve.
+func ValidPubKey(pub []byte) bool {
+	if len(pub) != 32 {
+		return false
+	}
+	var compressed [33]byte
+	compressed[0] = secp256k1.PubKeyFormatCompressedEven
+	copy(compressed[1:], pub)
+	_, err := secp256k1.ParsePubKey(compressed[:])
+	return err == nil
+}
@@ -0,0 +1,19 @@
+package dnet
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestValidPubKey(t *testing.T) {
+	key, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ValidPubKey(key.Pub[:]) {
+		t.Fatal("generated key is not valid")
+	}
+	if ValidPubKey(key.Pub[:31]) || ValidPubKey(bytes.Repeat([]byte{0xff}, 32)) || ValidPubKey(make([]byte, 32)) {
+		t.Fatal("accepted an invalid key")
+	}
+}
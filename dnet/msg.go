@@ -2,6 +2,7 @@ package dnet
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -12,6 +13,10 @@ import (
 const MaxMsgSize = 0x1000080 // 16MB (block size is 1MB; 16x=16MB + 128 header 0x80)
 const HeaderSize = 108
 
+// Errors returned by ReadMessage (wrapped; use errors.Is)
+var ErrMessageTooLarge = errors.New("message too large")
+var ErrBadSignature = errors.New("incorrect signature")
+
 type Message struct { // 108 bytes fixed size header
 	Chan      Tag4CC // [4] Channel Name [big-endian]
 	Tag       Tag4CC // [4] Message Name [big-endian]
@@ -99,7 +104,7 @@ func ReadMessage(reader io.Reader) (Message, error) {
 	// Decode the header
 	msg := DecodeHeader(buf[:])
 	if msg.Size > MaxMsgSize {
-		return Message{}, fmt.Errorf("message too large: [%s] size is %d bytes", msg.Tag, msg.Size)
+		return Message{}, fmt.Errorf("%w: [%s] size is %d bytes", ErrMessageTooLarge, msg.Tag, msg.Size)
 	}
 	// Read the message payload
 	msg.Payload = make([]byte, msg.Size)
@@ -111,7 +116,7 @@ func ReadMessage(reader io.Reader) (Message, error) {
 	pub := (*[32]byte)(msg.PubKey)
 	sig := (*[64]byte)(msg.Signature)
 	if !doge.VerifyMessage(pub, msg.Payload, sig) {
-		return Message{}, fmt.Errorf("incorrect signature: [%s] message", msg.Tag)
+		return Message{}, fmt.Errorf("%w: [%s] message", ErrBadSignature, msg.Tag)
 	}
 	return msg, nil
 }
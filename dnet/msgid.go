@@ -0,0 +1,34 @@
+package dnet
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// MsgID identifies a message: the SHA-256 of the header fields
+// (channel, tag, size, pubkey) and the payload.
+// The signature is not included, so a message has the same ID
+// no matter which valid signature it carries.
+type MsgID [32]byte
+
+func MessageID(header []byte, payload []byte) (id MsgID) {
+	h := sha256.New()
+	h.Write(header[0:44])
+	h.Write(payload)
+	h.Sum(id[:0])
+	return
+}
+
+func (id MsgID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// ID returns the message ID.
+func (m Message) ID() MsgID {
+	return MessageID(m.RawHdr, m.Payload)
+}
+
+// ID returns the message ID.
+func (m RawMessage) ID() MsgID {
+	return MessageID(m.Header, m.Payload)
+}
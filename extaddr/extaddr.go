@@ -0,0 +1,221 @@
+// Package extaddr discovers our external address from the addresses
+// peers report seeing us at, and keeps our signed AddressMsg up to date.
+package extaddr
+
+import (
+	"net/netip"
+	"sort"
+	"sync"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+	"code.dogecoin.org/gossip/peer"
+)
+
+type Config struct {
+	Key      dnet.KeyPair    // node key used to sign the AddressMsg
+	Port     uint16          // our listening port (default DogeNetDefaultPort)
+	MinPeers int             // peers that must agree on an address (default 3)
+	Template node.AddressMsg // Owner, Channels and Services to announce
+	Clock    dnet.Clock      // default SystemClock
+	// OnChange is called with the new AddressMsg and its signed message
+	// whenever the external address changes.
+	OnChange func(msg node.AddressMsg, raw dnet.RawMessage)
+}
+
+// Tracker collects one observation per remote host, and adopts the
+// address most peers agree on once at least MinPeers agree.
+// Only routable addresses are counted.
+// The current address is kept if agreement later drops below MinPeers.
+type Tracker struct {
+	cfg   Config
+	mu    sync.Mutex
+	votes map[netip.Addr]netip.Addr // remote host -> observed IP
+	addr  netip.Addr
+	msg   node.AddressMsg
+	raw   dnet.RawMessage
+}
+
+func New(cfg Config) *Tracker {
+	if cfg.Port == 0 {
+		cfg.Port = dnet.DogeNetDefaultPort
+	}
+	if cfg.MinPeers <= 0 {
+		cfg.MinPeers = 3
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = dnet.SystemClock{}
+	}
+	if len(cfg.Template.Owner) != 32 {
+		cfg.Template.Owner = make([]byte, 32)
+	}
+	return &Tracker{cfg: cfg, votes: make(map[netip.Addr]netip.Addr)}
+}
+
+// Observe records that the peer at remote sees us at observed.
+// A later observation from the same host replaces the earlier one.
+// Observations of unroutable addresses are ignored.
+func (t *Tracker) Observe(remote dnet.Address, observed netip.Addr) {
+	observed = observed.Unmap()
+	if !remote.Host().IsValid() || !dnet.NewAddress(observed, t.cfg.Port).IsRoutable() {
+		return
+	}
+	t.mu.Lock()
+	t.votes[remote.Host()] = observed
+	changed := t.update()
+	t.mu.Unlock()
+	t.notify(changed)
+}
+
+// Forget removes the observation made by the peer at remote.
+func (t *Tracker) Forget(remote dnet.Address) {
+	if !remote.Host().IsValid() {
+		return
+	}
+	t.mu.Lock()
+	delete(t.votes, remote.Host())
+	changed := t.update()
+	t.mu.Unlock()
+	t.notify(changed)
+}
+
+// PeerConnected records the address a peer observed in its handshake.
+// Call from peer.Config.OnConnect.
+func (t *Tracker) PeerConnected(p *peer.Peer) {
+	t.Observe(p.Addr(), p.Observed().Host())
+}
+
+// PeerDisconnected forgets a peer's observation.
+// Call from peer.Config.OnDisconnect.
+func (t *Tracker) PeerDisconnected(p *peer.Peer) {
+	t.Forget(p.Addr())
+}
+
+// Address returns the current external address, if one has been found.
+func (t *Tracker) Address() (dnet.Address, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.addr.IsValid() {
+		return dnet.Address{}, false
+	}
+	return dnet.NewAddress(t.addr, t.cfg.Port), true
+}
+
+// Message returns our current signed AddressMsg, if an address has been found.
+func (t *Tracker) Message() (node.AddressMsg, dnet.RawMessage, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.msg, t.raw, t.addr.IsValid()
+}
+
+// SetTemplate changes the Owner, Channels and Services we announce,
+// re-signing the AddressMsg if we have an address. Duplicate channels
+// are announced once, and of several services with the same tag only
+// the one with the lowest port is announced.
+func (t *Tracker) SetTemplate(tmpl node.AddressMsg) {
+	if len(tmpl.Owner) != 32 {
+		tmpl.Owner = make([]byte, 32)
+	}
+	t.mu.Lock()
+	t.cfg.Template = tmpl
+	changed := t.addr.IsValid()
+	if changed {
+		t.sign()
+	}
+	t.mu.Unlock()
+	t.notify(changed)
+}
+
+// Refresh re-signs the AddressMsg with the current time, so it can be
+// gossiped again before it goes stale. Returns false if there is no address yet.
+func (t *Tracker) Refresh() (node.AddressMsg, dnet.RawMessage, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.addr.IsValid() {
+		return node.AddressMsg{}, dnet.RawMessage{}, false
+	}
+	t.sign()
+	return t.msg, t.raw, true
+}
+
+// Votes returns the number of peers reporting each observed address.
+func (t *Tracker) Votes() map[netip.Addr]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tally()
+}
+
+func (t *Tracker) tally() map[netip.Addr]int {
+	counts := make(map[netip.Addr]int)
+	for _, ip := range t.votes {
+		counts[ip]++
+	}
+	return counts
+}
+
+// update adopts a new consensus address; t.mu must be held.
+func (t *Tracker) update() bool {
+	best, bestN, tied := netip.Addr{}, 0, false
+	for ip, n := range t.tally() {
+		if n > bestN {
+			best, bestN, tied = ip, n, false
+		} else if n == bestN {
+			tied = true
+		}
+	}
+	if bestN < t.cfg.MinPeers || tied {
+		return false
+	}
+	if best == t.addr {
+		return false
+	}
+	t.addr = best
+	t.sign()
+	return true
+}
+
+// sign builds and signs our AddressMsg; t.mu must be held.
+// Channels and Services are sorted and deduplicated by tag, so the same
+// template always encodes the same way and passes Validate.
+func (t *Tracker) sign() {
+	msg := t.cfg.Template
+	msg.Time = dnet.DogeNowAt(t.cfg.Clock)
+	msg.Address = dnet.NewAddress(t.addr, t.cfg.Port)
+	msg.Channels = append([]dnet.Tag4CC(nil), msg.Channels...)
+	sort.Slice(msg.Channels, func(i, j int) bool { return msg.Channels[i] < msg.Channels[j] })
+	channels := msg.Channels[:0]
+	for _, c := range msg.Channels {
+		if len(channels) == 0 || c != channels[len(channels)-1] {
+			channels = append(channels, c)
+		}
+	}
+	msg.Channels = channels
+	msg.Services = append([]node.Service(nil), msg.Services...)
+	sort.Slice(msg.Services, func(i, j int) bool {
+		a, b := msg.Services[i], msg.Services[j]
+		if a.Tag != b.Tag {
+			return a.Tag < b.Tag
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.Data < b.Data
+	})
+	services := msg.Services[:0]
+	for _, s := range msg.Services {
+		if len(services) == 0 || s.Tag != services[len(services)-1].Tag {
+			services = append(services, s)
+		}
+	}
+	msg.Services = services
+	t.msg = msg
+	t.raw = dnet.EncodeMessageRaw(node.ChannelNode, node.TagAddress, t.cfg.Key, msg.Encode())
+}
+
+func (t *Tracker) notify(changed bool) {
+	if !changed || t.cfg.OnChange == nil {
+		return
+	}
+	msg, raw, _ := t.Message()
+	t.cfg.OnChange(msg, raw)
+}
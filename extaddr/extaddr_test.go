@@ -0,0 +1,148 @@
+package extaddr
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/internal/testutil"
+	"code.dogecoin.org/gossip/node"
+)
+
+// remote returns the address of the i'th peer.
+func remote(i int) dnet.Address {
+	return dnet.NewAddress(netip.AddrFrom4([4]byte{8, 8, 0, byte(i)}), 42069)
+}
+
+var ext = netip.MustParseAddr("1.2.3.4")
+
+func TestConsensus(t *testing.T) {
+	var changes []node.AddressMsg
+	tr := New(Config{
+		Key:      testutil.NewKey(t),
+		MinPeers: 2,
+		OnChange: func(msg node.AddressMsg, raw dnet.RawMessage) { changes = append(changes, msg) },
+	})
+	tr.Observe(remote(1), ext)
+	if _, ok := tr.Address(); ok {
+		t.Fatal("adopted an address from one peer")
+	}
+	tr.Observe(remote(1), ext) // the same host again
+	if _, ok := tr.Address(); ok {
+		t.Fatal("counted the same host twice")
+	}
+	tr.Observe(remote(2), netip.MustParseAddr("::ffff:1.2.3.4"))
+	addr, ok := tr.Address()
+	if !ok || addr.String() != "1.2.3.4:42069" {
+		t.Fatalf("address %v %v", addr, ok)
+	}
+	if len(changes) != 1 || changes[0].Address.String() != "1.2.3.4:42069" {
+		t.Fatalf("changes %v", changes)
+	}
+
+	// a tie does not replace the address; a majority does
+	other := netip.MustParseAddr("5.6.7.8")
+	tr.Observe(remote(3), other)
+	tr.Observe(remote(4), other)
+	if addr, _ := tr.Address(); addr.Host() != ext {
+		t.Fatalf("tie moved the address to %v", addr)
+	}
+	tr.Observe(remote(5), other)
+	if addr, _ := tr.Address(); addr.Host() != other || len(changes) != 2 {
+		t.Fatalf("address %v after %d changes", addr, len(changes))
+	}
+
+	// losing agreement keeps the current address
+	for i := 1; i <= 5; i++ {
+		tr.Forget(remote(i))
+	}
+	if addr, ok := tr.Address(); !ok || addr.Host() != other {
+		t.Fatalf("address %v %v after forgetting", addr, ok)
+	}
+}
+
+func TestUnroutableIgnored(t *testing.T) {
+	tr := New(Config{Key: testutil.NewKey(t), MinPeers: 1})
+	for i, ip := range []string{"0.0.0.0", "127.0.0.1", "10.1.2.3", "192.168.1.1", "100.64.0.1", "fe80::1", "::"} {
+		tr.Observe(remote(i), netip.MustParseAddr(ip))
+	}
+	tr.Observe(remote(9), netip.Addr{})
+	if len(tr.Votes()) != 0 {
+		t.Fatalf("votes %v", tr.Votes())
+	}
+	if _, ok := tr.Address(); ok {
+		t.Fatal("adopted an unroutable address")
+	}
+}
+
+func TestSignedMessage(t *testing.T) {
+	key := testutil.NewKey(t)
+	clock := testutil.NewClock()
+	b, a := dnet.NewTag("Bbbb"), dnet.NewTag("Aaaa")
+	tr := New(Config{
+		Key:      key,
+		Port:     1234,
+		MinPeers: 1,
+		Clock:    clock,
+		Template: node.AddressMsg{
+			Channels: []dnet.Tag4CC{b, a},
+			Services: []node.Service{{Tag: b, Port: 2}, {Tag: a, Port: 9}, {Tag: a, Port: 1}},
+		},
+	})
+	if _, _, ok := tr.Refresh(); ok {
+		t.Fatal("refreshed without an address")
+	}
+	tr.Observe(remote(1), ext)
+	msg, raw, ok := tr.Message()
+	if !ok {
+		t.Fatal("no message")
+	}
+	got, err := dnet.ReadMessage(bytes.NewReader(append(append([]byte(nil), raw.Header...), raw.Payload...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Chan != node.ChannelNode || got.Tag != node.TagAddress || string(got.PubKey) != string(key.Pub[:]) {
+		t.Fatalf("message %v %v", got.Chan, got.Tag)
+	}
+	decoded := node.DecodeAddrMsg(got.Payload)
+	if decoded.Address.String() != "1.2.3.4:1234" || decoded.Time != dnet.DogeNowAt(clock) {
+		t.Fatalf("decoded %+v", decoded)
+	}
+	if msg.Channels[0] != a || msg.Channels[1] != b {
+		t.Fatalf("channels not sorted: %v", msg.Channels)
+	}
+	s := msg.Services
+	if len(s) != 2 || s[0].Tag != a || s[0].Port != 1 || s[1].Tag != b {
+		t.Fatalf("services not sorted and merged: %v", s)
+	}
+
+	clock.Advance(time.Hour)
+	refreshed, _, _ := tr.Refresh()
+	if refreshed.Time != msg.Time+3600 {
+		t.Fatalf("refreshed at %v, was %v", refreshed.Time, msg.Time)
+	}
+}
+
+func TestSetTemplateDuplicates(t *testing.T) {
+	clock := testutil.NewClock()
+	tr := New(Config{Key: testutil.NewKey(t), Port: 1234, MinPeers: 1, Clock: clock})
+	tr.Observe(remote(1), ext)
+	a, b := dnet.NewTag("Aaaa"), dnet.NewTag("Bbbb")
+	tr.SetTemplate(node.AddressMsg{
+		Channels: []dnet.Tag4CC{b, a, b},
+		Services: []node.Service{{Tag: a, Port: 7}, {Tag: b, Port: 2}, {Tag: a, Port: 3, Data: "x"}, {Tag: a, Port: 3}},
+	})
+	msg, _, _ := tr.Message()
+	if reasons := msg.Validate(clock.Now(), node.DefaultAddrRules); len(reasons) != 0 {
+		t.Fatalf("signed message rejected: %v", reasons)
+	}
+	if len(msg.Channels) != 2 || msg.Channels[0] != a || msg.Channels[1] != b {
+		t.Fatalf("channels %v", msg.Channels)
+	}
+	s := msg.Services
+	if len(s) != 2 || s[0].Tag != a || s[0].Port != 3 || len(s[0].Data) != 0 || s[1].Tag != b {
+		t.Fatalf("services %v", s)
+	}
+}
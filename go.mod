@@ -2,11 +2,15 @@ module code.dogecoin.org/gossip
 
 go 1.18
 
-require github.com/dogeorg/doge v0.0.12
+require (
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.3.0
+	github.com/dogeorg/doge v0.0.12
+	golang.org/x/crypto v0.26.0
+)
 
 require (
 	github.com/btcsuite/golangcrypto v0.0.0-20150304025918-53f62d9b43e8 // indirect
 	github.com/decred/dcrd/crypto/blake256 v1.1.0 // indirect
-	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.3.0 // indirect
 	github.com/mr-tron/base58 v1.2.0 // indirect
+	golang.org/x/sys v0.23.0 // indirect
 )
@@ -0,0 +1,368 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/iden"
+	"code.dogecoin.org/gossip/node"
+)
+
+// Client connects a channel handler to a DogeNet node.
+//
+// The client sends a BindMessage for its channel (or a v2 bind when
+// Config.Channels is set), then exchanges framed messages with the
+// node. Messages sent by the handler are signed with the handler's
+// key. The connection is re-established with exponential backoff if
+// it drops.
+type Client struct {
+	cfg       Config
+	recv      chan dnet.Message
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+	wmu       sync.Mutex // serializes writes to conn
+
+	mu       sync.Mutex
+	conn     net.Conn
+	nodeKey  [32]byte
+	caps     uint32
+	handlers map[dnet.Tag4CC]func(dnet.Message)
+}
+
+type Config struct {
+	Network      string              // "unix" or "tcp"
+	Address      string              // socket path or host:port
+	Channel      dnet.Tag4CC         // channel to bind (and the default channel for Send)
+	Channels     []dnet.BindChannel  // optional; bind several channels with filters (bind v2)
+	Caps         uint32              // optional; capability flags to request (bind v2)
+	Key          dnet.KeyPair        // handler key used to sign outgoing messages
+	MinBackoff   time.Duration       // first reconnect delay (default 250ms)
+	MaxBackoff   time.Duration       // maximum reconnect delay (default 30s)
+	RecvQueue    int                 // messages buffered for Recv (default 64)
+	WriteTimeout time.Duration       // disconnect if a send takes longer (default 30s)
+	Dial         DialFunc            // optional; defaults to net.Dialer
+	OnConnect    func(node [32]byte) // optional; called after each successful bind
+}
+
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+var ErrClosed = errors.New("handler client closed")
+var ErrNotConnected = errors.New("not connected to node")
+
+// Connect starts a client that binds to cfg.Channel on the node at
+// cfg.Address. The first connection is made in the background; Send
+// returns ErrNotConnected until the bind completes.
+func Connect(cfg Config) *Client {
+	if cfg.Network == "" {
+		cfg.Network = "unix"
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = 250 * time.Millisecond
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = 30 * time.Second
+		if cfg.MaxBackoff < cfg.MinBackoff {
+			cfg.MaxBackoff = cfg.MinBackoff
+		}
+	}
+	if cfg.Channel == 0 && len(cfg.Channels) > 0 {
+		cfg.Channel = cfg.Channels[0].Chan
+	}
+	if cfg.RecvQueue <= 0 {
+		cfg.RecvQueue = 64
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = 30 * time.Second
+	}
+	if cfg.Dial == nil {
+		d := &net.Dialer{Timeout: 10 * time.Second}
+		cfg.Dial = d.DialContext
+	}
+	c := &Client{
+		cfg:      cfg,
+		recv:     make(chan dnet.Message, cfg.RecvQueue),
+		done:     make(chan struct{}),
+		handlers: make(map[dnet.Tag4CC]func(dnet.Message)),
+	}
+	c.wg.Add(1)
+	go c.run()
+	return c
+}
+
+// Recv returns the next message received on a bound channel that
+// was not consumed by a typed callback (see OnTag, OnIdentity, OnAddress.)
+func (c *Client) Recv() (dnet.Message, error) {
+	select {
+	case msg := <-c.recv:
+		return msg, nil
+	case <-c.done:
+		return dnet.Message{}, ErrClosed
+	}
+}
+
+// Send signs payload with the handler key and sends it to the node
+// on the bound channel.
+func (c *Client) Send(tag dnet.Tag4CC, payload []byte) error {
+	return c.SendOn(c.cfg.Channel, tag, payload)
+}
+
+// SendOn signs payload with the handler key and sends it to the node
+// on one of the bound channels.
+func (c *Client) SendOn(channel dnet.Tag4CC, tag dnet.Tag4CC, payload []byte) error {
+	msg := dnet.EncodeMessageRaw(channel, tag, c.cfg.Key, payload)
+	return c.SendRaw(msg)
+}
+
+// SendRaw sends a pre-signed message to the node.
+// The write is made without holding c.mu, so Close can interrupt a
+// write to a node that has stopped reading.
+func (c *Client) SendRaw(msg dnet.RawMessage) error {
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+	}
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(c.cfg.WriteTimeout))
+	err := msg.Send(conn)
+	if err != nil {
+		// the read loop will notice and reconnect
+		conn.Close()
+	}
+	return err
+}
+
+// NodeKey returns the public key the node sent when the bind was accepted.
+func (c *Client) NodeKey() (key [32]byte, connected bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nodeKey, c.conn != nil
+}
+
+// Caps returns the capability flags accepted by the node (bind v2 only.)
+func (c *Client) Caps() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.caps
+}
+
+// OnTag registers a callback for messages with the given tag.
+// Callbacks run on the client's read goroutine; messages handled by
+// a callback are not delivered to Recv.
+func (c *Client) OnTag(tag dnet.Tag4CC, fn func(msg dnet.Message)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fn == nil {
+		delete(c.handlers, tag)
+	} else {
+		c.handlers[tag] = fn
+	}
+}
+
+// OnIdentity registers a callback for identity messages.
+// Messages that fail to decode or validate are dropped.
+func (c *Client) OnIdentity(fn func(pub dnet.PubKey, msg iden.IdentityMsg)) {
+	c.OnTag(iden.TagIdentity, func(m dnet.Message) {
+		var msg iden.IdentityMsg
+		if codec.TryDecode(func() { msg = iden.DecodeIdentityMsg(m.Payload) }) && msg.IsValid() {
+			fn((*[32]byte)(m.PubKey), msg)
+		}
+	})
+}
+
+// OnAddress registers a callback for node address messages.
+// Messages that fail to decode or validate are dropped.
+func (c *Client) OnAddress(fn func(pub dnet.PubKey, msg node.AddressMsg)) {
+	c.OnTag(node.TagAddress, func(m dnet.Message) {
+		var msg node.AddressMsg
+		if codec.TryDecode(func() { msg = node.DecodeAddrMsg(m.Payload) }) && msg.IsValid() {
+			fn((*[32]byte)(m.PubKey), msg)
+		}
+	})
+}
+
+// RequestHistory asks the node for messages stored on a bound channel
+// (the node must accept dnet.BindCapHistory.) The reply arrives as a
+// node.TagHistory message; see OnHistory.
+func (c *Client) RequestHistory(req node.GetHistoryMsg) error {
+	if req.Chan == 0 {
+		req.Chan = c.cfg.Channel
+	}
+	return c.SendOn(req.Chan, node.TagGetHistory, req.Encode())
+}
+
+// OnHistory registers a callback for history replies signed by the node.
+// The frames are verified; msgs are those with valid signatures.
+func (c *Client) OnHistory(fn func(reply node.HistoryMsg, msgs []dnet.Message)) {
+	c.OnTag(node.TagHistory, func(m dnet.Message) {
+		if key, _ := c.NodeKey(); key != *(*[32]byte)(m.PubKey) {
+			return
+		}
+		var reply node.HistoryMsg
+		if codec.TryDecode(func() { reply = node.DecodeHistoryMsg(m.Payload) }) && reply.Chan == m.Chan {
+			fn(reply, reply.Messages())
+		}
+	})
+}
+
+// Close disconnects from the node and stops reconnecting.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.mu.Lock()
+		if c.conn != nil {
+			c.conn.Close()
+		}
+		c.mu.Unlock()
+	})
+	c.wg.Wait()
+	return nil
+}
+
+func (c *Client) run() {
+	defer c.wg.Done()
+	backoff := c.cfg.MinBackoff
+	for {
+		conn, err := c.connect()
+		if err == nil {
+			backoff = c.cfg.MinBackoff
+			c.readLoop(conn)
+		}
+		select {
+		case <-c.done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > c.cfg.MaxBackoff {
+			backoff = c.cfg.MaxBackoff
+		}
+	}
+}
+
+func (c *Client) connect() (net.Conn, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	conn, err := c.cfg.Dial(ctx, c.cfg.Network, c.cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	var reply dnet.BindAck
+	if len(c.cfg.Channels) > 0 {
+		reply, err = bindV2(conn, c.cfg.Channels, c.cfg.Caps, c.cfg.Key)
+	} else {
+		reply, err = bind(conn, c.cfg.Channel, c.cfg.Key)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.mu.Lock()
+	select {
+	case <-c.done:
+		c.mu.Unlock()
+		conn.Close()
+		return nil, ErrClosed
+	default:
+	}
+	c.conn = conn
+	c.nodeKey = reply.PubKey
+	c.caps = reply.Caps
+	c.mu.Unlock()
+	if c.cfg.OnConnect != nil {
+		c.cfg.OnConnect(reply.PubKey)
+	}
+	return conn, nil
+}
+
+// bind sends our BindMessage and waits for the node's reply,
+// which carries the node's public key.
+func bind(conn net.Conn, channel dnet.Tag4CC, key dnet.KeyPair) (dnet.BindAck, error) {
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+	req := dnet.BindMessage{Version: 1, Chan: channel, PubKey: *key.Pub}
+	_, err := conn.Write(req.Encode())
+	if err != nil {
+		return dnet.BindAck{}, err
+	}
+	buf := [dnet.BindMessageSize]byte{}
+	_, err = io.ReadFull(conn, buf[:])
+	if err != nil {
+		return dnet.BindAck{}, fmt.Errorf("bind: no reply from node: %v", err)
+	}
+	reply, ok := dnet.DecodeBindMessage(buf[:])
+	if !ok || reply.Chan != channel {
+		return dnet.BindAck{}, fmt.Errorf("bind: node rejected channel [%s]", channel)
+	}
+	return dnet.BindAck{PubKey: reply.PubKey, Channels: []dnet.Tag4CC{channel}}, nil
+}
+
+// bindV2 sends a v2 bind and waits for the node's BindAck.
+func bindV2(conn net.Conn, channels []dnet.BindChannel, caps uint32, key dnet.KeyPair) (dnet.BindAck, error) {
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+	req := dnet.BindMessageV2{Version: dnet.BindVersion2, Caps: caps, PubKey: *key.Pub, Channels: channels}
+	_, err := conn.Write(req.Encode())
+	if err != nil {
+		return dnet.BindAck{}, err
+	}
+	ack, err := dnet.ReadBindAck(conn)
+	if err != nil {
+		return dnet.BindAck{}, fmt.Errorf("bind: no reply from node: %v", err)
+	}
+	if ack.Status != dnet.BindStatusOK {
+		return dnet.BindAck{}, fmt.Errorf("bind: node rejected bind (status %d)", ack.Status)
+	}
+	return ack, nil
+}
+
+func (c *Client) readLoop(conn net.Conn) {
+	defer func() {
+		c.mu.Lock()
+		if c.conn == conn {
+			c.conn = nil
+		}
+		c.mu.Unlock()
+		conn.Close()
+	}()
+	for {
+		msg, err := dnet.ReadMessage(conn)
+		if err != nil {
+			return
+		}
+		c.mu.Lock()
+		fn := c.handlers[msg.Tag]
+		c.mu.Unlock()
+		if fn != nil {
+			fn(msg)
+			continue
+		}
+		select {
+		case c.recv <- msg:
+		case <-c.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/iden"
+	"code.dogecoin.org/gossip/internal/testutil"
+)
+
+var testChan = dnet.NewTag("Test")
+var testTag = dnet.NewTag("Tst1")
+
+// pipeDialer returns a DialFunc that connects to the other end of a
+// net.Pipe, handed to the test on conns.
+func pipeDialer(conns chan<- net.Conn) DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		client, node := net.Pipe()
+		conns <- node
+		return client, nil
+	}
+}
+
+// acceptV1 plays the node side of a v1 bind.
+func acceptV1(t *testing.T, conn net.Conn, nodeKey dnet.KeyPair) dnet.BindMessage {
+	t.Helper()
+	buf := make([]byte, dnet.BindMessageSize)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	req, ok := dnet.DecodeBindMessage(buf)
+	if !ok {
+		t.Fatal("bad bind message")
+	}
+	reply := dnet.BindMessage{Version: 1, Chan: req.Chan, PubKey: *nodeKey.Pub}
+	if _, err := conn.Write(reply.Encode()); err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func connectPipe(t *testing.T, cfg Config) (*Client, net.Conn, dnet.KeyPair) {
+	t.Helper()
+	conns := make(chan net.Conn, 1)
+	nodeKey := testutil.NewKey(t)
+	cfg.Channel = testChan
+	cfg.Key = testutil.NewKey(t)
+	cfg.Dial = pipeDialer(conns)
+	cfg.MinBackoff = time.Hour // one connection per test
+	connected := make(chan struct{})
+	cfg.OnConnect = func([32]byte) { close(connected) }
+	c := Connect(cfg)
+	conn := <-conns
+	req := acceptV1(t, conn, nodeKey)
+	if req.Chan != testChan || req.PubKey != *cfg.Key.Pub {
+		t.Fatalf("bind: got %v", req)
+	}
+	<-connected
+	return c, conn, nodeKey
+}
+
+func TestClientSendRecv(t *testing.T) {
+	c, conn, nodeKey := connectPipe(t, Config{})
+	defer c.Close()
+	if key, ok := c.NodeKey(); !ok || key != *nodeKey.Pub {
+		t.Fatalf("NodeKey: got %x %v", key, ok)
+	}
+
+	go c.Send(testTag, []byte("hello"))
+	msg, err := dnet.ReadMessage(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Chan != testChan || msg.Tag != testTag || string(msg.Payload) != "hello" {
+		t.Fatalf("node received %v %v %q", msg.Chan, msg.Tag, msg.Payload)
+	}
+
+	go dnet.EncodeMessageRaw(testChan, testTag, nodeKey, []byte("world")).Send(conn)
+	msg, err = c.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Payload) != "world" {
+		t.Fatalf("Recv: got %q", msg.Payload)
+	}
+}
+
+func TestClientOnIdentityDropsMalformed(t *testing.T) {
+	c, conn, nodeKey := connectPipe(t, Config{})
+	defer c.Close()
+	got := make(chan iden.IdentityMsg, 2)
+	c.OnIdentity(func(pub dnet.PubKey, msg iden.IdentityMsg) { got <- msg })
+
+	dnet.EncodeMessageRaw(testChan, iden.TagIdentity, nodeKey, []byte{1, 2}).Send(conn)
+	good := iden.IdentityMsg{Time: 5, Name: "shibe", Country: "AU"}
+	dnet.EncodeMessageRaw(testChan, iden.TagIdentity, nodeKey, good.Encode()).Send(conn)
+	select {
+	case msg := <-got:
+		if msg.Name != "shibe" {
+			t.Fatalf("got %v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no identity delivered")
+	}
+	if len(got) != 0 {
+		t.Fatal("malformed identity was delivered")
+	}
+}
+
+func TestClientCloseInterruptsStuckSend(t *testing.T) {
+	c, _, _ := connectPipe(t, Config{})
+	// the node never reads, so this write blocks on the pipe
+	sent := make(chan error, 1)
+	go func() { sent <- c.Send(testTag, make([]byte, 1024)) }()
+	time.Sleep(20 * time.Millisecond)
+
+	locked := make(chan struct{})
+	go func() {
+		c.NodeKey()
+		c.Caps()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("NodeKey blocked behind a stuck send")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked behind a stuck send")
+	}
+	if err := <-sent; err == nil {
+		t.Fatal("stuck send succeeded")
+	}
+}
+
+func TestClientWriteTimeout(t *testing.T) {
+	c, _, _ := connectPipe(t, Config{WriteTimeout: 50 * time.Millisecond})
+	defer c.Close()
+	start := time.Now()
+	if err := c.Send(testTag, []byte("x")); err == nil {
+		t.Fatal("send to a node that is not reading succeeded")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("write deadline not applied")
+	}
+}
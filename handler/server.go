@@ -0,0 +1,430 @@
+package handler
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+)
+
+// Server accepts channel handler connections on the node side.
+//
+// Each handler binds to one or more channels; inbound gossip passed to
+// Deliver is routed to every handler bound to the message channel whose
+// tag and sender filters match. Messages a handler emits are passed to
+// ServerConfig.Outbound for sending to the network, and to the other
+// handlers bound to the same channel.
+type Server struct {
+	cfg       ServerConfig
+	mu        sync.Mutex
+	bound     map[dnet.Tag4CC][]*Conn
+	listeners []net.Listener
+	pending   map[net.Conn]struct{} // accepted, not yet bound
+	nextID    uint64
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+type ServerConfig struct {
+	NodeKey      dnet.KeyPair                       // node key; the public key is sent in bind replies
+	SendQueue    int                                // messages queued per handler (default 256)
+	WriteTimeout time.Duration                      // handler must accept a write within this time (default 10s)
+	BindTimeout  time.Duration                      // handler must bind within this time (default 30s)
+	Caps         uint32                             // capability flags supported by this node (bind v2)
+	Accept       func(bind dnet.BindMessageV2) bool // optional; reject binds by returning false
+	Outbound     func(from *Conn, msg dnet.Message) // messages emitted by handlers
+	OnEvent      func(ev Event)                     // optional; handler lifecycle events (must not block)
+
+	// History answers backfill requests (node.TagGetHistory) from handlers
+	// accepted with dnet.BindCapHistory, for one of their bound channels.
+	// The reply is queued to the handler; requests are not sent to the network.
+	// It is called on the handler's read loop, so it must not block; a
+	// zero RawMessage sends no reply.
+	History func(from *Conn, req node.GetHistoryMsg) dnet.RawMessage
+}
+
+// Conn is a bound handler connection.
+type Conn struct {
+	ID     uint64
+	Bind   dnet.BindMessageV2 // v1 binds are converted to a single channel
+	Caps   uint32             // capabilities accepted for this handler
+	Remote string
+	conn   net.Conn
+	queue  chan dnet.RawMessage
+	done   chan struct{}
+	once   sync.Once
+	err    error
+}
+
+type EventKind int
+
+const (
+	EventBound    EventKind = iota // handler bound to a channel
+	EventUnbound                   // handler disconnected
+	EventSlow                      // handler disconnected for not keeping up
+	EventRejected                  // handler bind was rejected
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventBound:
+		return "bound"
+	case EventUnbound:
+		return "unbound"
+	case EventSlow:
+		return "slow"
+	case EventRejected:
+		return "rejected"
+	}
+	return "unknown"
+}
+
+type Event struct {
+	Kind    EventKind
+	Handler *Conn
+	Err     error // reason for disconnect, if any
+}
+
+var ErrSlowHandler = errors.New("handler send queue full")
+var ErrWrongChannel = errors.New("handler sent a message on a channel it is not bound to")
+
+func NewServer(cfg ServerConfig) *Server {
+	if cfg.SendQueue <= 0 {
+		cfg.SendQueue = 256
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = 10 * time.Second
+	}
+	if cfg.BindTimeout <= 0 {
+		cfg.BindTimeout = 30 * time.Second
+	}
+	return &Server{
+		cfg:     cfg,
+		bound:   make(map[dnet.Tag4CC][]*Conn),
+		pending: make(map[net.Conn]struct{}),
+	}
+}
+
+// Listen accepts handler connections on a "unix" or "tcp" address.
+func (s *Server) Listen(network, address string) error {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	s.Serve(l)
+	return nil
+}
+
+// Serve accepts handler connections from l in the background.
+func (s *Server) Serve(l net.Listener) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return
+	}
+	s.listeners = append(s.listeners, l)
+	s.wg.Add(1)
+	s.mu.Unlock()
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					continue
+				}
+				return
+			}
+			s.mu.Lock()
+			if s.closed {
+				s.mu.Unlock()
+				conn.Close()
+				return
+			}
+			s.pending[conn] = struct{}{}
+			s.wg.Add(1)
+			s.mu.Unlock()
+			go s.serveConn(conn)
+		}
+	}()
+}
+
+// Deliver routes an inbound gossip message to every handler bound
+// to its channel. Returns the number of handlers it was queued for.
+func (s *Server) Deliver(msg dnet.Message) int {
+	return s.deliver(msg.Chan, dnet.RawMessage{Header: msg.RawHdr, Payload: msg.Payload}, nil)
+}
+
+// Handlers returns the handlers currently bound to a channel.
+func (s *Server) Handlers(channel dnet.Tag4CC) []*Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Conn(nil), s.bound[channel]...)
+}
+
+// Channels returns the channels that have at least one bound handler.
+func (s *Server) Channels() []dnet.Tag4CC {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	chans := make([]dnet.Tag4CC, 0, len(s.bound))
+	for ch := range s.bound {
+		chans = append(chans, ch)
+	}
+	return chans
+}
+
+// Close stops accepting handlers, disconnects all handlers (bound or
+// still binding) and waits for their goroutines to finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.pending {
+		conn.Close()
+	}
+	var all []*Conn
+	seen := make(map[*Conn]bool)
+	for _, conns := range s.bound {
+		for _, c := range conns {
+			if !seen[c] {
+				seen[c] = true
+				all = append(all, c)
+			}
+		}
+	}
+	s.mu.Unlock()
+	for _, c := range all {
+		c.close(nil)
+	}
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) deliver(channel dnet.Tag4CC, msg dnet.RawMessage, except *Conn) int {
+	tag := dnet.Tag4CC(binary.BigEndian.Uint32(msg.Header[4:8]))
+	pub := msg.Header[12:44]
+	s.mu.Lock()
+	conns := s.bound[channel]
+	var slow []*Conn
+	n := 0
+	for _, c := range conns {
+		if c == except {
+			continue
+		}
+		if b, ok := c.Bind.Channel(channel); !ok || !b.Wants(tag, pub) {
+			continue
+		}
+		select {
+		case c.queue <- msg:
+			n++
+		default:
+			slow = append(slow, c)
+		}
+	}
+	s.mu.Unlock()
+	for _, c := range slow {
+		c.close(ErrSlowHandler)
+	}
+	return n
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer s.unpend(conn)
+	bind, ok := s.readBind(conn)
+	if !ok {
+		conn.Close()
+		return
+	}
+	c := &Conn{
+		Bind:   bind,
+		Remote: conn.RemoteAddr().String(),
+		conn:   conn,
+		queue:  make(chan dnet.RawMessage, s.cfg.SendQueue),
+		done:   make(chan struct{}),
+	}
+	if len(bind.Channels) == 0 || (s.cfg.Accept != nil && !s.cfg.Accept(bind)) {
+		if bind.Version == dnet.BindVersion2 {
+			s.reply(conn, dnet.BindAck{Status: dnet.BindStatusRejected, PubKey: *s.cfg.NodeKey.Pub}.Encode())
+		}
+		conn.Close()
+		s.event(Event{Kind: EventRejected, Handler: c})
+		return
+	}
+	var reply []byte
+	if bind.Version == dnet.BindVersion2 {
+		c.Caps = bind.Caps & s.cfg.Caps
+		reply = dnet.BindAck{
+			Status:   dnet.BindStatusOK,
+			Caps:     c.Caps,
+			PubKey:   *s.cfg.NodeKey.Pub,
+			Channels: bind.ChannelTags(),
+		}.Encode()
+	} else {
+		reply = dnet.BindMessage{Version: 1, Chan: bind.Channels[0].Chan, PubKey: *s.cfg.NodeKey.Pub}.Encode()
+	}
+	if s.reply(conn, reply) != nil {
+		conn.Close()
+		return
+	}
+	if !s.add(c) {
+		conn.Close()
+		return
+	}
+	s.event(Event{Kind: EventBound, Handler: c})
+	s.wg.Add(1)
+	go s.writeLoop(c)
+	s.readLoop(c)
+	s.remove(c)
+	kind := EventUnbound
+	if c.err == ErrSlowHandler {
+		kind = EventSlow
+	}
+	s.event(Event{Kind: kind, Handler: c, Err: c.err})
+}
+
+func (s *Server) readBind(conn net.Conn) (dnet.BindMessageV2, bool) {
+	conn.SetReadDeadline(time.Now().Add(s.cfg.BindTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	bind, err := dnet.ReadBind(conn)
+	return bind, err == nil
+}
+
+func (s *Server) reply(conn net.Conn, reply []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(s.cfg.WriteTimeout))
+	defer conn.SetWriteDeadline(time.Time{})
+	_, err := conn.Write(reply)
+	return err
+}
+
+func (s *Server) add(c *Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, c.conn)
+	if s.closed {
+		return false
+	}
+	s.nextID++
+	c.ID = s.nextID
+	for _, ch := range c.Bind.ChannelTags() {
+		s.bound[ch] = append(s.bound[ch], c)
+	}
+	return true
+}
+
+// unpend forgets a connection that did not bind.
+func (s *Server) unpend(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.pending, conn)
+	s.mu.Unlock()
+}
+
+func (s *Server) remove(c *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ch := range c.Bind.ChannelTags() {
+		conns := s.bound[ch]
+		for i, other := range conns {
+			if other == c {
+				conns = append(conns[:i:i], conns[i+1:]...)
+				break
+			}
+		}
+		if len(conns) == 0 {
+			delete(s.bound, ch)
+		} else {
+			s.bound[ch] = conns
+		}
+	}
+}
+
+func (s *Server) readLoop(c *Conn) {
+	for {
+		msg, err := dnet.ReadMessage(c.conn)
+		if err != nil {
+			c.close(err)
+			return
+		}
+		if _, ok := c.Bind.Channel(msg.Chan); !ok {
+			c.close(ErrWrongChannel)
+			return
+		}
+		if msg.Tag == node.TagGetHistory && c.Caps&dnet.BindCapHistory != 0 && s.cfg.History != nil {
+			s.history(c, msg)
+			continue
+		}
+		// other handlers on the same channel see it too
+		s.deliver(msg.Chan, dnet.RawMessage{Header: msg.RawHdr, Payload: msg.Payload}, c)
+		if s.cfg.Outbound != nil {
+			s.cfg.Outbound(c, msg)
+		}
+	}
+}
+
+// history queues the reply to a handler's backfill request.
+func (s *Server) history(c *Conn, msg dnet.Message) {
+	var req node.GetHistoryMsg
+	if !codec.TryDecode(func() { req = node.DecodeGetHistoryMsg(msg.Payload) }) || !req.IsValid() {
+		return
+	}
+	if req.Chan != msg.Chan {
+		return // only the channel the request was sent on
+	}
+	reply := s.cfg.History(c, req)
+	if len(reply.Header) == 0 {
+		return
+	}
+	select {
+	case c.queue <- reply:
+	default:
+		c.close(ErrSlowHandler)
+	}
+}
+
+func (s *Server) writeLoop(c *Conn) {
+	defer s.wg.Done()
+	for {
+		select {
+		case msg := <-c.queue:
+			c.conn.SetWriteDeadline(time.Now().Add(s.cfg.WriteTimeout))
+			if err := msg.Send(c.conn); err != nil {
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					err = ErrSlowHandler
+				}
+				c.close(err)
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (s *Server) event(ev Event) {
+	if s.cfg.OnEvent != nil {
+		s.cfg.OnEvent(ev)
+	}
+}
+
+// Close disconnects the handler.
+func (c *Conn) Close() {
+	c.close(nil)
+}
+
+func (c *Conn) close(err error) {
+	c.once.Do(func() {
+		c.err = err
+		close(c.done)
+		c.conn.Close()
+	})
+}
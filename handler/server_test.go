@@ -0,0 +1,266 @@
+package handler
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/internal/testutil"
+	"code.dogecoin.org/gossip/node"
+)
+
+func startServer(t *testing.T, cfg ServerConfig) (*Server, string) {
+	t.Helper()
+	if cfg.NodeKey.Pub == nil {
+		cfg.NodeKey = testutil.NewKey(t)
+	}
+	s := NewServer(cfg)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return s, l.Addr().String()
+}
+
+func bindClient(t *testing.T, addr string, cfg Config) *Client {
+	t.Helper()
+	connected := make(chan struct{}, 1)
+	cfg.Network = "tcp"
+	cfg.Address = addr
+	if cfg.Key.Pub == nil {
+		cfg.Key = testutil.NewKey(t)
+	}
+	cfg.OnConnect = func([32]byte) { connected <- struct{}{} }
+	c := Connect(cfg)
+	t.Cleanup(func() { c.Close() })
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not bind")
+	}
+	return c
+}
+
+// waitHandlers waits until n handlers are bound to a channel.
+func waitHandlers(t *testing.T, s *Server, channel dnet.Tag4CC, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.Handlers(channel)) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d handlers on %v, have %d", n, channel, len(s.Handlers(channel)))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func recvTimeout(t *testing.T, c *Client) dnet.Message {
+	t.Helper()
+	got := make(chan dnet.Message, 1)
+	go func() {
+		if msg, err := c.Recv(); err == nil {
+			got <- msg
+		}
+	}()
+	select {
+	case msg := <-got:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	return dnet.Message{}
+}
+
+func TestServerRoutesByChannel(t *testing.T) {
+	outbound := make(chan dnet.Message, 1)
+	s, addr := startServer(t, ServerConfig{
+		Outbound: func(from *Conn, msg dnet.Message) { outbound <- msg },
+	})
+	a := bindClient(t, addr, Config{Channel: testChan})
+	b := bindClient(t, addr, Config{Channel: testChan})
+	other := bindClient(t, addr, Config{Channel: dnet.NewTag("Othr")})
+	waitHandlers(t, s, testChan, 2)
+
+	// gossip from the network reaches both handlers on the channel
+	sender := testutil.NewKey(t)
+	raw := dnet.EncodeMessage(testChan, testTag, sender, []byte("net"))
+	msg, err := dnet.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Deliver(msg); n != 2 {
+		t.Fatalf("Deliver: queued for %d handlers, want 2", n)
+	}
+	for _, c := range []*Client{a, b} {
+		if got := recvTimeout(t, c); string(got.Payload) != "net" {
+			t.Fatalf("got %q", got.Payload)
+		}
+	}
+
+	// a handler's message goes to the network and the other handler
+	if err := a.Send(testTag, []byte("from a")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-outbound:
+		if string(got.Payload) != "from a" {
+			t.Fatalf("outbound: got %q", got.Payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no outbound message")
+	}
+	if got := recvTimeout(t, b); string(got.Payload) != "from a" {
+		t.Fatalf("got %q", got.Payload)
+	}
+	select {
+	case <-a.recv:
+		t.Fatal("handler received its own message")
+	case <-other.recv:
+		t.Fatal("handler on another channel received the message")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestServerRejectsBind(t *testing.T) {
+	events := make(chan Event, 4)
+	_, addr := startServer(t, ServerConfig{
+		Accept:  func(bind dnet.BindMessageV2) bool { return false },
+		OnEvent: func(ev Event) { events <- ev },
+	})
+	c := Connect(Config{Network: "tcp", Address: addr, Channel: testChan, Key: testutil.NewKey(t), MinBackoff: time.Hour})
+	defer c.Close()
+	select {
+	case ev := <-events:
+		if ev.Kind != EventRejected {
+			t.Fatalf("got event %v", ev.Kind)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no rejected event")
+	}
+}
+
+func TestServerDisconnectsWrongChannel(t *testing.T) {
+	events := make(chan Event, 4)
+	s, addr := startServer(t, ServerConfig{OnEvent: func(ev Event) { events <- ev }})
+	c := bindClient(t, addr, Config{Channel: testChan, MinBackoff: time.Hour})
+	waitHandlers(t, s, testChan, 1)
+	c.SendOn(dnet.NewTag("Othr"), testTag, []byte("x"))
+	for {
+		select {
+		case ev := <-events:
+			if ev.Kind == EventUnbound {
+				if ev.Err != ErrWrongChannel {
+					t.Fatalf("unbound with %v", ev.Err)
+				}
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("handler was not disconnected")
+		}
+	}
+}
+
+func TestServerBindV2Filters(t *testing.T) {
+	wanted := dnet.NewTag("Want")
+	s, addr := startServer(t, ServerConfig{Caps: dnet.BindCapHistory})
+	c := bindClient(t, addr, Config{
+		Channels: []dnet.BindChannel{{Chan: testChan, Tags: []dnet.Tag4CC{wanted}}},
+		Caps:     dnet.BindCapHistory | dnet.BindCapCompression,
+	})
+	waitHandlers(t, s, testChan, 1)
+	if caps := c.Caps(); caps != dnet.BindCapHistory {
+		t.Fatalf("caps: got %b, want only history", caps)
+	}
+	sender := testutil.NewKey(t)
+	for _, tag := range []dnet.Tag4CC{testTag, wanted} {
+		msg, err := dnet.ReadMessage(bytes.NewReader(dnet.EncodeMessage(testChan, tag, sender, []byte(tag.String()))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Deliver(msg)
+	}
+	if got := recvTimeout(t, c); got.Tag != wanted {
+		t.Fatalf("got tag %v, want only %v", got.Tag, wanted)
+	}
+}
+
+func TestServerHistoryReplies(t *testing.T) {
+	requests := make(chan node.GetHistoryMsg, 2)
+	nodeKey := testutil.NewKey(t)
+	s, addr := startServer(t, ServerConfig{
+		NodeKey: nodeKey,
+		Caps:    dnet.BindCapHistory,
+		History: func(from *Conn, req node.GetHistoryMsg) dnet.RawMessage {
+			requests <- req
+			if req.Cursor == 0 {
+				return dnet.RawMessage{} // nothing to send
+			}
+			page := node.HistoryMsg{Chan: req.Chan, Cursor: req.Cursor}
+			return dnet.EncodeMessageRaw(req.Chan, node.TagHistory, nodeKey, page.Encode())
+		},
+	})
+	c := bindClient(t, addr, Config{Channels: []dnet.BindChannel{{Chan: testChan}}, Caps: dnet.BindCapHistory})
+	waitHandlers(t, s, testChan, 1)
+
+	for _, cursor := range []uint64{0, 7} {
+		if err := c.RequestHistory(node.GetHistoryMsg{Chan: testChan, Since: 1, Cursor: cursor}); err != nil {
+			t.Fatal(err)
+		}
+		if req := <-requests; req.Chan != testChan || req.Cursor != cursor {
+			t.Fatalf("request %+v", req)
+		}
+	}
+	got := recvTimeout(t, c)
+	if got.Tag != node.TagHistory || node.DecodeHistoryMsg(got.Payload).Cursor != 7 {
+		t.Fatalf("got %v %+v", got.Tag, node.DecodeHistoryMsg(got.Payload))
+	}
+	if len(s.Handlers(testChan)) != 1 {
+		t.Fatal("handler disconnected")
+	}
+}
+
+func TestServerCloseDuringBind(t *testing.T) {
+	s := NewServer(ServerConfig{NodeKey: testutil.NewKey(t)})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Serve(l)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the connection never binds
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		n := len(s.pending)
+		s.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection not accepted")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		s.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waited for the bind timeout")
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("binding connection left open")
+	}
+}
@@ -0,0 +1,163 @@
+// Package history serves history backfill requests from the message
+// store, to channel handlers and peers.
+package history
+
+import (
+	"code.dogecoin.org/gossip/ban"
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/handler"
+	"code.dogecoin.org/gossip/node"
+	"code.dogecoin.org/gossip/ratelimit"
+	"code.dogecoin.org/gossip/store"
+)
+
+// Peer is a connection that can receive messages.
+type Peer interface {
+	ID() uint64
+	TrySend(msg dnet.RawMessage) bool
+}
+
+type Config struct {
+	Store       *store.Store
+	Key         dnet.KeyPair                         // node key, used to sign HistoryMsg replies
+	MaxItems    int                                  // most messages per page (default 100, at most node.MaxHistoryItems)
+	MaxBytes    int                                  // most frame bytes per page (default 1MB, at most node.MaxHistoryBytes)
+	Channels    []dnet.Tag4CC                        // channels peers may request (default: all); handlers may request their bound channels
+	PeerLimit   ratelimit.Limit                      // peer requests answered (default 1/s, burst 10)
+	Clock       dnet.Clock                           // default SystemClock
+	Misbehaving func(peer uint64, reason ban.Reason) // optional; report peer misbehavior
+}
+
+// Server answers GetHistoryMsg requests with pages of original signed
+// frames from the store.
+type Server struct {
+	cfg   Config
+	limit *ratelimit.Limiter
+}
+
+func New(cfg Config) *Server {
+	if cfg.MaxItems <= 0 || cfg.MaxItems > node.MaxHistoryItems {
+		cfg.MaxItems = 100
+	}
+	if cfg.MaxBytes <= 0 || cfg.MaxBytes > node.MaxHistoryBytes {
+		cfg.MaxBytes = 1 << 20
+	}
+	if cfg.PeerLimit == (ratelimit.Limit{}) {
+		cfg.PeerLimit = ratelimit.Limit{Rate: 1, Burst: 10}
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = dnet.SystemClock{}
+	}
+	return &Server{
+		cfg:   cfg,
+		limit: ratelimit.New(ratelimit.Config{Default: ratelimit.ChannelLimits{Peer: cfg.PeerLimit}, Clock: cfg.Clock}),
+	}
+}
+
+// Request builds a signed GetHistory request to send to a peer.
+func Request(key dnet.KeyPair, req node.GetHistoryMsg) dnet.RawMessage {
+	return dnet.EncodeMessageRaw(node.ChannelNode, node.TagGetHistory, key, req.Encode())
+}
+
+// Page returns the next page of stored messages for a request.
+func (s *Server) Page(req node.GetHistoryMsg) node.HistoryMsg {
+	res := node.HistoryMsg{Chan: req.Chan, Cursor: req.Cursor}
+	limit := s.cfg.MaxItems
+	if req.Limit > 0 && int(req.Limit) < limit {
+		limit = int(req.Limit)
+	}
+	// one more than fits, to know there are more
+	q := store.Query{Chan: req.Chan, Since: req.Since, After: store.Pos(req.Cursor), Limit: limit + 1}
+	if len(req.Tags) == 1 {
+		q.Tag = req.Tags[0]
+	}
+	bytes, seen := 0, 0
+	s.cfg.Store.Iterate(q, func(e store.Entry, raw dnet.RawMessage) bool {
+		seen++
+		if !req.WantsTag(e.Tag) {
+			res.Cursor = uint64(e.Pos)
+			return true
+		}
+		if len(res.Frames) >= limit {
+			res.More = true
+			return false
+		}
+		if e.Size > s.cfg.MaxBytes {
+			res.Cursor = uint64(e.Pos) // too big to ever send; skip it
+			return true
+		}
+		if bytes+e.Size > s.cfg.MaxBytes {
+			res.More = true
+			return false
+		}
+		bytes += e.Size
+		res.Frames = append(res.Frames, raw)
+		res.Cursor = uint64(e.Pos)
+		return true
+	})
+	if seen == q.Limit {
+		// stopped by the query limit; the cursor is after the last one seen
+		res.More = true
+	}
+	return res
+}
+
+// Reply returns the signed HistoryMsg reply to a request.
+func (s *Server) Reply(channel dnet.Tag4CC, req node.GetHistoryMsg) dnet.RawMessage {
+	return dnet.EncodeMessageRaw(channel, node.TagHistory, s.cfg.Key, s.Page(req).Encode())
+}
+
+// HandleMessage answers a GetHistory request from a peer.
+// Returns false if msg is not a GetHistory request.
+func (s *Server) HandleMessage(from Peer, msg dnet.Message) bool {
+	if msg.Chan != node.ChannelNode || msg.Tag != node.TagGetHistory {
+		return false
+	}
+	var req node.GetHistoryMsg
+	if !codec.TryDecode(func() { req = node.DecodeGetHistoryMsg(msg.Payload) }) {
+		s.misbehaving(from.ID(), ban.ReasonDecode)
+		return true
+	}
+	if !s.limit.AllowPeer(from.ID(), msg) {
+		s.misbehaving(from.ID(), ban.ReasonSpam)
+		return true
+	}
+	if !s.allowed(req.Chan) {
+		// an empty page, so the peer does not wait
+		empty := node.HistoryMsg{Chan: req.Chan, Cursor: req.Cursor}
+		from.TrySend(dnet.EncodeMessageRaw(node.ChannelNode, node.TagHistory, s.cfg.Key, empty.Encode()))
+		return true
+	}
+	from.TrySend(s.Reply(node.ChannelNode, req))
+	return true
+}
+
+// HandlerHistory answers requests from channel handlers that were
+// accepted with dnet.BindCapHistory; use it as handler.ServerConfig.History.
+func (s *Server) HandlerHistory(c *handler.Conn, req node.GetHistoryMsg) dnet.RawMessage {
+	return s.Reply(req.Chan, req)
+}
+
+// RemovePeer forgets a disconnected peer's rate limit.
+func (s *Server) RemovePeer(peer uint64) {
+	s.limit.RemovePeer(peer)
+}
+
+func (s *Server) allowed(ch dnet.Tag4CC) bool {
+	if s.cfg.Channels == nil {
+		return true
+	}
+	for _, c := range s.cfg.Channels {
+		if c == ch {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) misbehaving(peer uint64, reason ban.Reason) {
+	if s.cfg.Misbehaving != nil {
+		s.cfg.Misbehaving(peer, reason)
+	}
+}
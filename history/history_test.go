@@ -0,0 +1,170 @@
+package history
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"code.dogecoin.org/gossip/ban"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/internal/testutil"
+	"code.dogecoin.org/gossip/node"
+	"code.dogecoin.org/gossip/store"
+)
+
+var testChan = dnet.NewTag("Test")
+var testTag = dnet.NewTag("Tst1")
+var otherTag = dnet.NewTag("Tst2")
+
+type testPeer struct {
+	id   uint64
+	mu   sync.Mutex
+	sent []dnet.RawMessage
+}
+
+func (p *testPeer) ID() uint64 { return p.id }
+
+func (p *testPeer) TrySend(msg dnet.RawMessage) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sent = append(p.sent, msg)
+	return true
+}
+
+func newMessage(t *testing.T, key dnet.KeyPair, channel, tag dnet.Tag4CC, payload []byte) dnet.Message {
+	t.Helper()
+	msg, err := dnet.ReadMessage(bytes.NewReader(dnet.EncodeMessage(channel, tag, key, payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// newStore returns a store with the messages tagged as tags, in order.
+func newStore(t *testing.T, tags ...dnet.Tag4CC) *store.Store {
+	t.Helper()
+	st, err := store.Open(store.Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { st.Close() })
+	key := testutil.NewKey(t)
+	for i, tag := range tags {
+		if _, err := st.PutRaw(dnet.EncodeMessageRaw(testChan, tag, key, []byte{byte(i)})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return st
+}
+
+// pages requests pages until there are no more, returning the
+// payloads of the frames and the number of pages.
+func pages(t *testing.T, s *Server, req node.GetHistoryMsg) ([]byte, int) {
+	t.Helper()
+	var got []byte
+	for n := 1; ; n++ {
+		page := s.Page(req)
+		for _, f := range page.Frames {
+			got = append(got, f.Payload...)
+		}
+		if !page.More {
+			return got, n
+		}
+		if page.Cursor == req.Cursor {
+			t.Fatal("cursor did not advance")
+		}
+		req.Cursor = page.Cursor
+		if n > 100 {
+			t.Fatal("too many pages")
+		}
+	}
+}
+
+func TestPages(t *testing.T) {
+	st := newStore(t, testTag, testTag, testTag, testTag, testTag)
+	s := New(Config{Store: st, Key: testutil.NewKey(t), MaxItems: 2})
+	got, n := pages(t, s, node.GetHistoryMsg{Chan: testChan})
+	if !bytes.Equal(got, []byte{0, 1, 2, 3, 4}) || n != 3 {
+		t.Fatalf("got %v in %d pages", got, n)
+	}
+	// the request's limit is used when smaller
+	got, n = pages(t, s, node.GetHistoryMsg{Chan: testChan, Limit: 1})
+	if len(got) != 5 || n != 5 {
+		t.Fatalf("got %v in %d pages", got, n)
+	}
+	// an exact page has no more
+	s = New(Config{Store: st, Key: testutil.NewKey(t)})
+	if page := s.Page(node.GetHistoryMsg{Chan: testChan, Limit: 5}); len(page.Frames) != 5 || page.More {
+		t.Fatalf("%d frames, more %v", len(page.Frames), page.More)
+	}
+}
+
+func TestPagesFilterTags(t *testing.T) {
+	third := dnet.NewTag("Tst3")
+	st := newStore(t, otherTag, otherTag, otherTag, testTag, third, otherTag, testTag, otherTag, otherTag)
+	s := New(Config{Store: st, Key: testutil.NewKey(t), MaxItems: 2})
+
+	// skipped messages still move the cursor, so a page can be empty
+	page := s.Page(node.GetHistoryMsg{Chan: testChan, Tags: []dnet.Tag4CC{testTag, third}})
+	if len(page.Frames) != 0 || !page.More || page.Cursor == 0 {
+		t.Fatalf("%d frames, more %v, cursor %x", len(page.Frames), page.More, page.Cursor)
+	}
+	got, _ := pages(t, s, node.GetHistoryMsg{Chan: testChan, Tags: []dnet.Tag4CC{testTag, third}})
+	if !bytes.Equal(got, []byte{3, 4, 6}) {
+		t.Fatalf("got %v", got)
+	}
+	got, _ = pages(t, s, node.GetHistoryMsg{Chan: testChan, Tags: []dnet.Tag4CC{testTag}})
+	if !bytes.Equal(got, []byte{3, 6}) {
+		t.Fatalf("got %v", got)
+	}
+}
+
+func TestPageBytes(t *testing.T) {
+	st := newStore(t, testTag, testTag, testTag)
+	s := New(Config{Store: st, Key: testutil.NewKey(t), MaxBytes: 2*dnet.HeaderSize + 2})
+	page := s.Page(node.GetHistoryMsg{Chan: testChan})
+	if len(page.Frames) != 2 || !page.More {
+		t.Fatalf("%d frames, more %v", len(page.Frames), page.More)
+	}
+}
+
+func TestHandleMessage(t *testing.T) {
+	st := newStore(t, testTag, testTag)
+	var reported []ban.Reason
+	key := testutil.NewKey(t)
+	s := New(Config{
+		Store:       st,
+		Key:         key,
+		Channels:    []dnet.Tag4CC{testChan},
+		Misbehaving: func(peer uint64, r ban.Reason) { reported = append(reported, r) },
+	})
+	p := &testPeer{id: 1}
+	peerKey := testutil.NewKey(t)
+	if s.HandleMessage(p, newMessage(t, peerKey, testChan, node.TagGetHistory, nil)) {
+		t.Fatal("handled a request on another channel")
+	}
+
+	req := node.GetHistoryMsg{Chan: testChan}
+	if !s.HandleMessage(p, newMessage(t, peerKey, node.ChannelNode, node.TagGetHistory, req.Encode())) {
+		t.Fatal("request not handled")
+	}
+	req.Chan = dnet.NewTag("Nope")
+	s.HandleMessage(p, newMessage(t, peerKey, node.ChannelNode, node.TagGetHistory, req.Encode()))
+	if len(p.sent) != 2 {
+		t.Fatalf("sent %d replies", len(p.sent))
+	}
+	for i, want := range []int{2, 0} {
+		msg, err := dnet.ReadMessage(bytes.NewReader(append(append([]byte(nil), p.sent[i].Header...), p.sent[i].Payload...)))
+		if err != nil || !bytes.Equal(msg.PubKey, key.Pub[:]) || msg.Tag != node.TagHistory {
+			t.Fatalf("reply %d: %v", i, err)
+		}
+		if reply := node.DecodeHistoryMsg(msg.Payload); len(reply.Messages()) != want {
+			t.Fatalf("reply %d: %d messages, want %d", i, len(reply.Messages()), want)
+		}
+	}
+
+	s.HandleMessage(p, newMessage(t, peerKey, node.ChannelNode, node.TagGetHistory, []byte{1}))
+	if len(reported) != 1 || reported[0] != ban.ReasonDecode {
+		t.Fatalf("reported %v", reported)
+	}
+}
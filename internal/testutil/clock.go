@@ -0,0 +1,48 @@
+// Package testutil has the fake clock and key helper shared by tests.
+package testutil
+
+import (
+	"sync"
+	"time"
+)
+
+// Start is the time NewClock starts at.
+var Start = time.Unix(1700000000, 0)
+
+// Clock is a dnet.Clock that only moves when advanced.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a Clock at Start.
+func NewClock() *Clock {
+	return NewClockAt(Start)
+}
+
+// NewClockAt returns a Clock at t.
+func NewClockAt(t time.Time) *Clock {
+	return &Clock{now: t}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set moves the clock to t (never backwards.)
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.After(c.now) {
+		c.now = t
+	}
+}
@@ -0,0 +1,17 @@
+package testutil
+
+import (
+	"testing"
+
+	"code.dogecoin.org/gossip/dnet"
+)
+
+// NewKey generates a key pair, failing the test on error.
+func NewKey(t testing.TB) dnet.KeyPair {
+	t.Helper()
+	key, err := dnet.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
@@ -0,0 +1,206 @@
+// Package lan discovers peers on the local network from UDP multicast
+// beacons carrying each node's signed AddressMsg.
+package lan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/fnv"
+	"net"
+	"sync"
+	"time"
+
+	"code.dogecoin.org/gossip/addrman"
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+	"code.dogecoin.org/gossip/ratelimit"
+)
+
+// DefaultGroup is the multicast group beacons are sent to
+// (in the IPv4 site-local administrative scope.)
+const DefaultGroup = "239.255.42.69:42069"
+
+// Beacons start with this magic, followed by a signed node.TagAddress message.
+var beaconMagic = []byte("DNLB")
+
+const maxBeacon = 0xffff // largest UDP datagram
+
+type Config struct {
+	Disabled    bool                                                            // turn LAN discovery off
+	Group       string                                                          // multicast "ip:port" (default DefaultGroup)
+	Interface   *net.Interface                                                  // interface to use (default: chosen by the system)
+	Interval    time.Duration                                                   // time between our beacons (default 30s, at least 1s)
+	Message     func() (dnet.RawMessage, bool)                                  // our signed AddressMsg to announce (optional; listen only if nil)
+	Addrs       *addrman.Manager                                                // optional; verified beacons are added here
+	OnBeacon    func(msg dnet.Message, addr node.AddressMsg, from dnet.Address) // optional
+	SourceLimit ratelimit.Limit                                                 // beacons accepted per source IP (default one per 5s, burst 3)
+	KeyLimit    ratelimit.Limit                                                 // beacons accepted per node key (default one per 5s, burst 3)
+	Clock       dnet.Clock                                                      // default SystemClock
+}
+
+var ErrDisabled = errors.New("lan discovery is disabled")
+
+// Discovery sends and receives LAN beacons.
+type Discovery struct {
+	cfg   Config
+	group *net.UDPAddr
+	limit *ratelimit.Limiter
+	mu    sync.Mutex
+	own   [32]byte // our node key, to ignore our own beacons
+}
+
+func New(cfg Config) (*Discovery, error) {
+	if cfg.Group == "" {
+		cfg.Group = DefaultGroup
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 30 * time.Second
+	}
+	if cfg.Interval < time.Second {
+		cfg.Interval = time.Second
+	}
+	if cfg.SourceLimit == (ratelimit.Limit{}) {
+		cfg.SourceLimit = ratelimit.Every(5*time.Second, 3)
+	}
+	if cfg.KeyLimit == (ratelimit.Limit{}) {
+		cfg.KeyLimit = ratelimit.Every(5*time.Second, 3)
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = dnet.SystemClock{}
+	}
+	group, err := net.ResolveUDPAddr("udp", cfg.Group)
+	if err != nil {
+		return nil, err
+	}
+	if !group.IP.IsMulticast() {
+		return nil, errors.New("lan: not a multicast group: " + cfg.Group)
+	}
+	return &Discovery{
+		cfg:   cfg,
+		group: group,
+		limit: ratelimit.New(ratelimit.Config{
+			Default: ratelimit.ChannelLimits{Peer: cfg.SourceLimit, Sender: cfg.KeyLimit},
+			Clock:   cfg.Clock,
+		}),
+	}, nil
+}
+
+// Run sends beacons every Interval and handles beacons received,
+// until the context is done.
+func (d *Discovery) Run(ctx context.Context) error {
+	if d.cfg.Disabled {
+		return ErrDisabled
+	}
+	recv, err := net.ListenMulticastUDP("udp", d.cfg.Interface, d.group)
+	if err != nil {
+		return err
+	}
+	defer recv.Close()
+	go func() {
+		<-ctx.Done()
+		recv.Close()
+	}()
+	if d.cfg.Message != nil {
+		send, err := net.DialUDP("udp", nil, d.group)
+		if err != nil {
+			return err
+		}
+		defer send.Close()
+		go d.sendLoop(ctx, send)
+	}
+	buf := make([]byte, maxBeacon)
+	for {
+		n, from, err := recv.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		d.Handle(buf[:n], from)
+	}
+}
+
+func (d *Discovery) sendLoop(ctx context.Context, conn *net.UDPConn) {
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if raw, ok := d.cfg.Message(); ok {
+			d.mu.Lock()
+			d.own = *dnet.MsgView(raw.Header).PubKey()
+			d.mu.Unlock()
+			conn.Write(Beacon(raw))
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Beacon encodes a signed AddressMsg as a beacon datagram.
+func Beacon(raw dnet.RawMessage) []byte {
+	b := make([]byte, 0, len(beaconMagic)+len(raw.Header)+len(raw.Payload))
+	b = append(b, beaconMagic...)
+	b = append(b, raw.Header...)
+	return append(b, raw.Payload...)
+}
+
+// Handle verifies a beacon datagram and adds its address.
+// Returns false if the beacon was rejected.
+//
+// The source IP is rate limited before the signature is verified, so
+// a flood of forged beacons costs one check per beacon allowed; the
+// node key is limited once the signature proves it.
+func (d *Discovery) Handle(datagram []byte, from *net.UDPAddr) bool {
+	if !bytes.HasPrefix(datagram, beaconMagic) || len(datagram) < len(beaconMagic)+dnet.HeaderSize {
+		return false
+	}
+	frame := datagram[len(beaconMagic):]
+	hdr := dnet.DecodeHeader(frame)
+	if hdr.Chan != node.ChannelNode || hdr.Tag != node.TagAddress {
+		return false
+	}
+	d.mu.Lock()
+	own := *(*[32]byte)(hdr.PubKey) == d.own
+	d.mu.Unlock()
+	if own {
+		return false // our own beacon
+	}
+	if !d.limit.AllowPeer(sourceKey(from.IP), hdr) {
+		return false
+	}
+	// ReadMessage verifies the Schnorr signature
+	msg, err := dnet.ReadMessage(bytes.NewReader(frame))
+	if err != nil || !d.limit.AllowSender(msg) {
+		return false
+	}
+	var addr node.AddressMsg
+	if !codec.TryDecode(func() { addr = node.DecodeAddrMsg(msg.Payload) }) || !addr.IsValid() {
+		return false
+	}
+	source := dnet.AddressFromIP(from.IP, uint16(from.Port))
+	if d.cfg.Addrs != nil {
+		if d.cfg.Addrs.Add(msg, source) != nil {
+			return false
+		}
+	}
+	if d.cfg.OnBeacon != nil {
+		d.cfg.OnBeacon(msg, addr, source)
+	}
+	return true
+}
+
+// sourceKey maps a source IP to a rate limit key.
+func sourceKey(ip net.IP) uint64 {
+	h := fnv.New64a()
+	h.Write(ip.To16())
+	return h.Sum64()
+}
@@ -0,0 +1,127 @@
+package lan
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/addrman"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/internal/testutil"
+	"code.dogecoin.org/gossip/node"
+)
+
+func newTestDiscovery(t *testing.T, cfg Config) (*Discovery, *testutil.Clock) {
+	t.Helper()
+	clock := testutil.NewClock()
+	cfg.Clock = clock
+	d, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, clock
+}
+
+// beacon returns a signed beacon for a LAN address.
+func beacon(t *testing.T, clock dnet.Clock, key dnet.KeyPair, ip byte) []byte {
+	t.Helper()
+	addr := node.AddressMsg{
+		Time:    dnet.DogeNowAt(clock),
+		Address: dnet.AddressFromIP(net.IPv4(192, 168, 1, ip), 42069),
+		Owner:   make([]byte, 32),
+	}
+	return Beacon(dnet.EncodeMessageRaw(node.ChannelNode, node.TagAddress, key, addr.Encode()))
+}
+
+// forged returns a copy of a beacon with a broken signature.
+func forged(b []byte) []byte {
+	f := append([]byte(nil), b...)
+	f[len(beaconMagic)+50] ^= 1
+	return f
+}
+
+func from(ip byte) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.IPv4(192, 168, 1, ip), Port: 42069}
+}
+
+func TestHandleBeacon(t *testing.T) {
+	var got []node.AddressMsg
+	d, clock := newTestDiscovery(t, Config{
+		OnBeacon: func(msg dnet.Message, addr node.AddressMsg, from dnet.Address) { got = append(got, addr) },
+	})
+	addrs := addrman.New(addrman.Config{Clock: clock})
+	d.cfg.Addrs = addrs
+	key := testutil.NewKey(t)
+	b := beacon(t, clock, key, 10)
+
+	if !d.Handle(b, from(10)) {
+		t.Fatal("beacon rejected")
+	}
+	if len(got) != 1 || got[0].Address.String() != "192.168.1.10:42069" {
+		t.Fatalf("OnBeacon %v", got)
+	}
+	if _, ok := addrs.Get(*key.Pub); !ok {
+		t.Fatal("beacon address not added")
+	}
+	for _, bad := range [][]byte{forged(b), b[:len(b)-1], []byte("DNLB"), []byte("XXXX")} {
+		if d.Handle(bad, from(11)) {
+			t.Fatalf("accepted %q", bad)
+		}
+	}
+
+	// our own beacons are ignored
+	own := testutil.NewKey(t)
+	d.own = *own.Pub
+	if d.Handle(beacon(t, clock, own, 12), from(12)) {
+		t.Fatal("accepted our own beacon")
+	}
+}
+
+func TestSourceLimitedBeforeVerify(t *testing.T) {
+	d, clock := newTestDiscovery(t, Config{})
+	b := beacon(t, clock, testutil.NewKey(t), 10)
+	// forged beacons use up the source's burst
+	for i := 0; i < 3; i++ {
+		if d.Handle(forged(b), from(10)) {
+			t.Fatal("accepted a forged beacon")
+		}
+	}
+	if d.Handle(b, from(10)) {
+		t.Fatal("source limit not applied to forged beacons")
+	}
+	if !d.Handle(b, from(11)) {
+		t.Fatal("another source was limited")
+	}
+	clock.Advance(5 * time.Second)
+	if !d.Handle(beacon(t, clock, testutil.NewKey(t), 10), from(10)) {
+		t.Fatal("source limit did not refill")
+	}
+}
+
+func TestKeyLimit(t *testing.T) {
+	d, clock := newTestDiscovery(t, Config{})
+	key := testutil.NewKey(t)
+	for i := byte(0); i < 3; i++ {
+		if !d.Handle(beacon(t, clock, key, 10), from(10+i)) {
+			t.Fatalf("beacon %d rejected", i)
+		}
+	}
+	if d.Handle(beacon(t, clock, key, 10), from(20)) {
+		t.Fatal("key limit not applied across sources")
+	}
+	// a forged beacon does not use up the key's limit
+	d2, clock2 := newTestDiscovery(t, Config{})
+	b := beacon(t, clock2, key, 10)
+	for i := byte(0); i < 3; i++ {
+		d2.Handle(forged(b), from(10+i))
+	}
+	if !d2.Handle(b, from(20)) {
+		t.Fatal("forged beacons counted against the key")
+	}
+}
+
+func TestNewRejectsUnicastGroup(t *testing.T) {
+	if _, err := New(Config{Group: "192.168.1.1:42069"}); err == nil {
+		t.Fatal("accepted a unicast group")
+	}
+}
@@ -0,0 +1,384 @@
+package linkage
+
+import (
+	"sync"
+
+	"code.dogecoin.org/gossip/addrman"
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/iden"
+	"code.dogecoin.org/gossip/node"
+	"code.dogecoin.org/gossip/store"
+)
+
+// Status of the link between a node and an identity.
+type Status uint8
+
+const (
+	None         Status = iota // neither side claims the other
+	OneSided                   // one side claims the other, which we have not heard from
+	Contradicted               // one side claims the other, which does not claim it back
+	Mutual                     // both sides claim each other
+)
+
+func (s Status) String() string {
+	switch s {
+	case None:
+		return "none"
+	case OneSided:
+		return "one-sided"
+	case Contradicted:
+		return "contradicted"
+	case Mutual:
+		return "mutual"
+	}
+	return "unknown"
+}
+
+// Link is the relationship between a node key and an identity key.
+type Link struct {
+	Node           [32]byte
+	Identity       [32]byte
+	NodeClaims     bool // the node's AddressMsg names the identity as Owner
+	IdentityClaims bool // the identity's IdentityMsg lists the node
+	Status         Status
+}
+
+type Config struct {
+	Addrs    *addrman.Manager // optional; node claims are loaded from known addresses
+	Store    *store.Store     // optional; identity claims are loaded from stored identity messages
+	OnChange func(l Link)     // optional; called when the status of a link changes
+}
+
+// Resolver cross-checks the nodes an identity claims (IdentityMsg.Nodes)
+// against the identity each node claims (AddressMsg.Owner).
+//
+// Only the newest message from each key counts. A claim is one-sided
+// until the other key's message arrives; if that message does not
+// claim the first key back, the claim is contradicted. UIs should only
+// show Mutual links as verified.
+type Resolver struct {
+	cfg     Config
+	mu      sync.Mutex
+	nodes   map[[32]byte]nodeClaim
+	idens   map[[32]byte]idenClaim
+	listing map[[32]byte]map[[32]byte]struct{} // node -> identities listing it
+	owning  map[[32]byte]map[[32]byte]struct{} // identity -> nodes naming it as Owner
+}
+
+type nodeClaim struct {
+	time  dnet.DogeTime
+	owner [32]byte // zero if the node claims no identity
+}
+
+type idenClaim struct {
+	time  dnet.DogeTime
+	nodes map[[32]byte]struct{}
+}
+
+// New creates a Resolver, loading existing claims from cfg.Addrs and cfg.Store.
+func New(cfg Config) (*Resolver, error) {
+	r := &Resolver{
+		cfg:     cfg,
+		nodes:   make(map[[32]byte]nodeClaim),
+		idens:   make(map[[32]byte]idenClaim),
+		listing: make(map[[32]byte]map[[32]byte]struct{}),
+		owning:  make(map[[32]byte]map[[32]byte]struct{}),
+	}
+	if cfg.Addrs != nil {
+		for _, e := range cfg.Addrs.All() {
+			r.setNode(e.PubKey, e.Msg.Time, e.Msg.Owner)
+		}
+	}
+	if cfg.Store != nil {
+		q := store.Query{Chan: dnet.ChannelIdentity, Tag: iden.TagIdentity}
+		err := cfg.Store.Iterate(q, func(e store.Entry, raw dnet.RawMessage) bool {
+			var msg iden.IdentityMsg
+			if codec.TryDecode(func() { msg = iden.DecodeIdentityMsg(raw.Payload) }) && msg.IsValid() {
+				r.setIdentity(e.PubKey, msg.Time, msg.Nodes)
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// HandleMessage updates claims from a verified node.TagAddress,
+// node.TagAddrV2 or iden.TagIdentity message. Returns false for other messages, or
+// messages that fail to decode.
+func (r *Resolver) HandleMessage(msg dnet.Message) bool {
+	pub := *(*[32]byte)(msg.PubKey)
+	switch {
+	case msg.Chan == node.ChannelNode && msg.Tag == node.TagAddress:
+		var addr node.AddressMsg
+		if !codec.TryDecode(func() { addr = node.DecodeAddrMsg(msg.Payload) }) || !addr.IsValid() {
+			return false
+		}
+		r.AddNode(pub, addr)
+		return true
+	case msg.Chan == node.ChannelNode && msg.Tag == node.TagAddrV2:
+		var addr node.AddressV2Msg
+		if !codec.TryDecode(func() { addr = node.DecodeAddrV2Msg(msg.Payload) }) || !addr.IsValid() {
+			return false
+		}
+		// only the time and owner are used
+		r.AddNode(pub, node.AddressMsg{Time: addr.Time, Owner: addr.Owner})
+		return true
+	case msg.Chan == dnet.ChannelIdentity && msg.Tag == iden.TagIdentity:
+		var id iden.IdentityMsg
+		if !codec.TryDecode(func() { id = iden.DecodeIdentityMsg(msg.Payload) }) || !id.IsValid() {
+			return false
+		}
+		r.AddIdentity(pub, id)
+		return true
+	}
+	return false
+}
+
+// AddNode records the identity a node claims. Older messages are ignored.
+func (r *Resolver) AddNode(pub [32]byte, msg node.AddressMsg) {
+	r.mu.Lock()
+	changed := r.setNode(pub, msg.Time, msg.Owner)
+	r.mu.Unlock()
+	r.notify(changed)
+}
+
+// AddIdentity records the nodes an identity claims. Older messages are ignored.
+func (r *Resolver) AddIdentity(pub [32]byte, msg iden.IdentityMsg) {
+	r.mu.Lock()
+	changed := r.setIdentity(pub, msg.Time, msg.Nodes)
+	r.mu.Unlock()
+	r.notify(changed)
+}
+
+// RemoveNode forgets a node's claim (e.g. when its address expires.)
+func (r *Resolver) RemoveNode(pub [32]byte) {
+	r.mu.Lock()
+	var changed []Link
+	if c, ok := r.nodes[pub]; ok {
+		pairs := r.nodePairs(pub)
+		before := r.snapshot(pairs)
+		delete(r.nodes, pub)
+		unindex(r.owning, c.owner, pub)
+		changed = r.diff(pairs, before)
+	}
+	r.mu.Unlock()
+	r.notify(changed)
+}
+
+// RemoveIdentity forgets an identity's claims.
+func (r *Resolver) RemoveIdentity(pub [32]byte) {
+	r.mu.Lock()
+	var changed []Link
+	if c, ok := r.idens[pub]; ok {
+		pairs := r.idenPairs(pub)
+		before := r.snapshot(pairs)
+		delete(r.idens, pub)
+		for n := range c.nodes {
+			unindex(r.listing, n, pub)
+		}
+		changed = r.diff(pairs, before)
+	}
+	r.mu.Unlock()
+	r.notify(changed)
+}
+
+// Link returns the link between a node and an identity.
+func (r *Resolver) Link(nodeKey, idenKey [32]byte) Link {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.link(pair{nodeKey, idenKey})
+}
+
+// Node returns the links for every identity the node claims or is claimed by.
+func (r *Resolver) Node(pub [32]byte) []Link {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.links(r.nodePairs(pub))
+}
+
+// Identity returns the links for every node the identity claims or is claimed by.
+func (r *Resolver) Identity(pub [32]byte) []Link {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.links(r.idenPairs(pub))
+}
+
+// Owner returns the identity that operates a node, if the link is mutual.
+func (r *Resolver) Owner(nodeKey [32]byte) ([32]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.nodes[nodeKey]
+	if !ok || c.owner == ([32]byte{}) || r.link(pair{nodeKey, c.owner}).Status != Mutual {
+		return [32]byte{}, false
+	}
+	return c.owner, true
+}
+
+// VerifiedNodes returns the nodes mutually linked to an identity.
+func (r *Resolver) VerifiedNodes(idenKey [32]byte) [][32]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var res [][32]byte
+	for n := range r.idens[idenKey].nodes {
+		if r.link(pair{n, idenKey}).Status == Mutual {
+			res = append(res, n)
+		}
+	}
+	return res
+}
+
+type pair struct {
+	node [32]byte
+	iden [32]byte
+}
+
+// setNode updates a node's claim, returning the links that changed; r.mu must be held.
+func (r *Resolver) setNode(pub [32]byte, ts dnet.DogeTime, owner []byte) []Link {
+	if c, ok := r.nodes[pub]; ok && ts <= c.time {
+		return nil
+	}
+	var o [32]byte
+	copy(o[:], owner)
+	pairs := r.nodePairs(pub)
+	if o != ([32]byte{}) {
+		pairs = append(pairs, pair{pub, o})
+	}
+	before := r.snapshot(pairs)
+	if c, ok := r.nodes[pub]; ok {
+		unindex(r.owning, c.owner, pub)
+	}
+	r.nodes[pub] = nodeClaim{time: ts, owner: o}
+	if o != ([32]byte{}) {
+		index(r.owning, o, pub)
+	}
+	return r.diff(pairs, before)
+}
+
+// setIdentity updates an identity's claims, returning the links that changed; r.mu must be held.
+func (r *Resolver) setIdentity(pub [32]byte, ts dnet.DogeTime, nodes [][]byte) []Link {
+	if c, ok := r.idens[pub]; ok && ts <= c.time {
+		return nil
+	}
+	claim := idenClaim{time: ts, nodes: make(map[[32]byte]struct{}, len(nodes))}
+	pairs := r.idenPairs(pub)
+	for _, n := range nodes {
+		key := *(*[32]byte)(n)
+		claim.nodes[key] = struct{}{}
+		pairs = append(pairs, pair{key, pub})
+	}
+	before := r.snapshot(pairs)
+	for n := range r.idens[pub].nodes {
+		unindex(r.listing, n, pub)
+	}
+	r.idens[pub] = claim
+	for n := range claim.nodes {
+		index(r.listing, n, pub)
+	}
+	return r.diff(pairs, before)
+}
+
+// nodePairs are the pairs involving a node; r.mu must be held.
+func (r *Resolver) nodePairs(pub [32]byte) []pair {
+	var pairs []pair
+	if c, ok := r.nodes[pub]; ok && c.owner != ([32]byte{}) {
+		pairs = append(pairs, pair{pub, c.owner})
+	}
+	for i := range r.listing[pub] {
+		pairs = append(pairs, pair{pub, i})
+	}
+	return pairs
+}
+
+// idenPairs are the pairs involving an identity; r.mu must be held.
+func (r *Resolver) idenPairs(pub [32]byte) []pair {
+	var pairs []pair
+	for n := range r.idens[pub].nodes {
+		pairs = append(pairs, pair{n, pub})
+	}
+	for n := range r.owning[pub] {
+		pairs = append(pairs, pair{n, pub})
+	}
+	return pairs
+}
+
+// link works out the status of a pair; r.mu must be held.
+func (r *Resolver) link(p pair) Link {
+	l := Link{Node: p.node, Identity: p.iden}
+	nc, nodeKnown := r.nodes[p.node]
+	ic, idenKnown := r.idens[p.iden]
+	l.NodeClaims = nodeKnown && nc.owner == p.iden
+	_, l.IdentityClaims = ic.nodes[p.node]
+	switch {
+	case l.NodeClaims && l.IdentityClaims:
+		l.Status = Mutual
+	case l.NodeClaims && idenKnown, l.IdentityClaims && nodeKnown:
+		l.Status = Contradicted
+	case l.NodeClaims, l.IdentityClaims:
+		l.Status = OneSided
+	}
+	return l
+}
+
+// links returns the links for pairs, once each; r.mu must be held.
+func (r *Resolver) links(pairs []pair) []Link {
+	seen := make(map[pair]bool, len(pairs))
+	var res []Link
+	for _, p := range pairs {
+		if !seen[p] {
+			seen[p] = true
+			res = append(res, r.link(p))
+		}
+	}
+	return res
+}
+
+// snapshot records the status of each pair; r.mu must be held.
+func (r *Resolver) snapshot(pairs []pair) map[pair]Status {
+	before := make(map[pair]Status, len(pairs))
+	for _, p := range pairs {
+		before[p] = r.link(p).Status
+	}
+	return before
+}
+
+// diff returns the links whose status changed since snapshot; r.mu must be held.
+func (r *Resolver) diff(pairs []pair, before map[pair]Status) []Link {
+	var changed []Link
+	for _, l := range r.links(pairs) {
+		if l.Status != before[pair{l.Node, l.Identity}] {
+			changed = append(changed, l)
+		}
+	}
+	return changed
+}
+
+func (r *Resolver) notify(changed []Link) {
+	if r.cfg.OnChange == nil {
+		return
+	}
+	for _, l := range changed {
+		r.cfg.OnChange(l)
+	}
+}
+
+func index(m map[[32]byte]map[[32]byte]struct{}, key, val [32]byte) {
+	set := m[key]
+	if set == nil {
+		set = make(map[[32]byte]struct{})
+		m[key] = set
+	}
+	set[val] = struct{}{}
+}
+
+func unindex(m map[[32]byte]map[[32]byte]struct{}, key, val [32]byte) {
+	if set := m[key]; set != nil {
+		delete(set, val)
+		if len(set) == 0 {
+			delete(m, key)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package linkage
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"code.dogecoin.org/gossip/addrman"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/iden"
+	"code.dogecoin.org/gossip/internal/testutil"
+	"code.dogecoin.org/gossip/node"
+	"code.dogecoin.org/gossip/store"
+)
+
+func newMessage(t *testing.T, key dnet.KeyPair, channel, tag dnet.Tag4CC, payload []byte) dnet.Message {
+	t.Helper()
+	msg, err := dnet.ReadMessage(bytes.NewReader(dnet.EncodeMessage(channel, tag, key, payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func addrMsg(ts dnet.DogeTime, owner [32]byte) node.AddressMsg {
+	return node.AddressMsg{Time: ts, Address: dnet.AddressFromIP(net.IPv4(8, 8, 8, 8), 22556), Owner: owner[:]}
+}
+
+func idenMsg(ts dnet.DogeTime, nodes ...[32]byte) iden.IdentityMsg {
+	msg := iden.IdentityMsg{Time: ts, Name: "Test", Country: "DE"}
+	for _, n := range nodes {
+		msg.Nodes = append(msg.Nodes, append([]byte(nil), n[:]...))
+	}
+	return msg
+}
+
+func key(b byte) [32]byte {
+	return [32]byte{b}
+}
+
+func TestStatus(t *testing.T) {
+	var changes []Link
+	r, err := New(Config{OnChange: func(l Link) { changes = append(changes, l) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, id := key(1), key(2)
+	if l := r.Link(n, id); l.Status != None {
+		t.Fatalf("empty: %v", l.Status)
+	}
+
+	// the node claims an identity we have not heard from
+	r.AddNode(n, addrMsg(10, id))
+	if l := r.Link(n, id); l.Status != OneSided || !l.NodeClaims || l.IdentityClaims {
+		t.Fatalf("node claim: %+v", l)
+	}
+	// the identity does not list the node
+	r.AddIdentity(id, idenMsg(10))
+	if l := r.Link(n, id); l.Status != Contradicted {
+		t.Fatalf("unlisted: %v", l.Status)
+	}
+	if _, ok := r.Owner(n); ok {
+		t.Fatal("owner of a contradicted link")
+	}
+	// now it does
+	r.AddIdentity(id, idenMsg(11, n))
+	if l := r.Link(n, id); l.Status != Mutual || !l.IdentityClaims {
+		t.Fatalf("listed: %+v", l)
+	}
+	if o, ok := r.Owner(n); !ok || o != id {
+		t.Fatal("owner of a mutual link")
+	}
+	if v := r.VerifiedNodes(id); len(v) != 1 || v[0] != n {
+		t.Fatalf("verified nodes %v", v)
+	}
+	// an older message changes nothing
+	r.AddIdentity(id, idenMsg(5))
+	if r.Link(n, id).Status != Mutual {
+		t.Fatal("older identity message applied")
+	}
+	// the node moves to another identity
+	other := key(3)
+	r.AddNode(n, addrMsg(12, other))
+	if l := r.Link(n, id); l.Status != Contradicted || l.NodeClaims {
+		t.Fatalf("after move: %+v", l)
+	}
+	if l := r.Link(n, other); l.Status != OneSided {
+		t.Fatalf("new claim: %v", l.Status)
+	}
+
+	want := []Status{OneSided, Contradicted, Mutual, Contradicted, OneSided}
+	if len(changes) != len(want) {
+		t.Fatalf("changes %+v", changes)
+	}
+	for i, l := range changes {
+		if l.Status != want[i] {
+			t.Fatalf("change %d: %v, want %v", i, l.Status, want[i])
+		}
+	}
+}
+
+func TestIdentityClaimFirst(t *testing.T) {
+	r, _ := New(Config{})
+	n1, n2, id := key(1), key(2), key(9)
+	r.AddIdentity(id, idenMsg(10, n1, n2))
+	if l := r.Link(n1, id); l.Status != OneSided || !l.IdentityClaims {
+		t.Fatalf("identity claim: %+v", l)
+	}
+	r.AddNode(n1, addrMsg(10, id))
+	r.AddNode(n2, addrMsg(10, [32]byte{}))
+	if r.Link(n1, id).Status != Mutual || r.Link(n2, id).Status != Contradicted {
+		t.Fatal("statuses after node claims")
+	}
+	if ls := r.Identity(id); len(ls) != 2 {
+		t.Fatalf("identity links %+v", ls)
+	}
+	if ls := r.Node(n1); len(ls) != 1 || ls[0].Status != Mutual {
+		t.Fatalf("node links %+v", ls)
+	}
+
+	r.RemoveNode(n2)
+	if r.Link(n2, id).Status != OneSided {
+		t.Fatal("removed node")
+	}
+	r.RemoveIdentity(id)
+	if r.Link(n1, id).Status != OneSided || r.Link(n2, id).Status != None {
+		t.Fatal("removed identity")
+	}
+	if len(r.Identity(id)) != 1 || len(r.Node(n2)) != 0 {
+		t.Fatal("links kept after removal")
+	}
+}
+
+func TestHandleMessage(t *testing.T) {
+	r, _ := New(Config{})
+	nodeKey, idKey := testutil.NewKey(t), testutil.NewKey(t)
+	n, id := *nodeKey.Pub, *idKey.Pub
+	now := dnet.DogeNow()
+
+	v2 := node.AddressV2Msg{
+		Time:    now,
+		Address: node.NetAddr{Network: node.NetTorV3, Addr: bytes.Repeat([]byte{7}, 32)},
+		Port:    42069,
+		Owner:   id[:],
+	}
+	if !r.HandleMessage(newMessage(t, nodeKey, node.ChannelNode, node.TagAddrV2, v2.Encode())) {
+		t.Fatal("AddrV2 not handled")
+	}
+	im := idenMsg(now, n)
+	if !r.HandleMessage(newMessage(t, idKey, dnet.ChannelIdentity, iden.TagIdentity, im.Encode())) {
+		t.Fatal("identity not handled")
+	}
+	if r.Link(n, id).Status != Mutual {
+		t.Fatalf("status %v", r.Link(n, id).Status)
+	}
+	if r.HandleMessage(newMessage(t, nodeKey, node.ChannelNode, node.TagAddress, []byte{1})) ||
+		r.HandleMessage(newMessage(t, nodeKey, node.ChannelNode, node.TagPing, nil)) {
+		t.Fatal("handled a bad message")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	st, err := store.Open(store.Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	addrs := addrman.New(addrman.Config{})
+	nodeKey, idKey := testutil.NewKey(t), testutil.NewKey(t)
+	n, id := *nodeKey.Pub, *idKey.Pub
+	now := dnet.DogeNow()
+
+	a := addrMsg(now, id)
+	if err := addrs.Add(newMessage(t, nodeKey, node.ChannelNode, node.TagAddress, a.Encode()), dnet.Address{}); err != nil {
+		t.Fatal(err)
+	}
+	im := idenMsg(now, n)
+	if _, err := st.Put(newMessage(t, idKey, dnet.ChannelIdentity, iden.TagIdentity, im.Encode())); err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(Config{Addrs: addrs, Store: st})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Link(n, id).Status != Mutual {
+		t.Fatalf("loaded status %v", r.Link(n, id).Status)
+	}
+	if None.String() != "none" || Mutual.String() != "mutual" || Status(9).String() != "unknown" {
+		t.Fatal("status names")
+	}
+}
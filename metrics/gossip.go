@@ -0,0 +1,190 @@
+package metrics
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/handler"
+	"code.dogecoin.org/gossip/iden"
+	"code.dogecoin.org/gossip/node"
+	"code.dogecoin.org/gossip/peer"
+	"code.dogecoin.org/gossip/ratelimit"
+)
+
+// OtherLabel is the label value for channels and tags that are not known.
+const OtherLabel = "other"
+
+// DefaultTags are the node.ChannelNode tags labelled by name.
+var DefaultTags = []dnet.Tag4CC{
+	node.TagAddress, node.TagAddrV2, node.TagVersion, node.TagVerAck,
+	node.TagPing, node.TagPong, node.TagGetAddr, node.TagInv,
+	node.TagGetMsgs, node.TagGetHistory, node.TagHistory,
+}
+
+// DefaultChannels are the well-known channels labelled by name, with
+// the tags labelled by name on each.
+var DefaultChannels = map[dnet.Tag4CC][]dnet.Tag4CC{
+	node.ChannelNode:      DefaultTags,
+	dnet.ChannelIdentity:  {iden.TagIdentity},
+	dnet.ChannelChat:      nil,
+	dnet.ChannelShibeShop: nil,
+	dnet.ChannelB0rk:      nil,
+}
+
+// Gossip holds the traffic metrics for a node.
+//
+// Wire it to the peer manager (OnMessage, OnSend, OnReadError) and the
+// relay engine (OnDuplicate, OnDecodeError), and use the Watch methods
+// for values that are read when metrics are scraped.
+//
+// Channel and tag labels come from the network, so only known channels
+// and tags are labelled by name (DefaultChannels, and those added with
+// Known); the rest are counted as OtherLabel.
+type Gossip struct {
+	reg               *Registry
+	mu                sync.Mutex
+	channels          map[dnet.Tag4CC]map[dnet.Tag4CC]bool
+	MessagesIn        Counter // channel, tag
+	MessagesOut       Counter // channel, tag
+	BytesIn           Counter // channel, tag
+	BytesOut          Counter // channel, tag
+	SignatureFailures Counter
+	OversizedFrames   Counter
+	DecodeFailures    Counter // channel, tag
+	Duplicates        Counter // channel, tag
+}
+
+func NewGossip(r *Registry) *Gossip {
+	g := &Gossip{
+		reg:               r,
+		channels:          make(map[dnet.Tag4CC]map[dnet.Tag4CC]bool),
+		MessagesIn:        r.Counter("dogenet_messages_received_total", "Messages received from peers.", "channel", "tag"),
+		MessagesOut:       r.Counter("dogenet_messages_sent_total", "Messages sent to peers.", "channel", "tag"),
+		BytesIn:           r.Counter("dogenet_received_bytes_total", "Bytes received from peers, including headers.", "channel", "tag"),
+		BytesOut:          r.Counter("dogenet_sent_bytes_total", "Bytes sent to peers, including headers.", "channel", "tag"),
+		SignatureFailures: r.Counter("dogenet_signature_failures_total", "Messages with a signature that did not verify."),
+		OversizedFrames:   r.Counter("dogenet_oversized_frames_total", "Frames larger than the maximum message size."),
+		DecodeFailures:    r.Counter("dogenet_decode_failures_total", "Payloads that failed to decode.", "channel", "tag"),
+		Duplicates:        r.Counter("dogenet_duplicates_total", "Messages received that were already seen.", "channel", "tag"),
+	}
+	for channel, tags := range DefaultChannels {
+		g.Known(channel, tags...)
+	}
+	return g
+}
+
+// Known adds a channel and its tags to those labelled by name.
+func (g *Gossip) Known(channel dnet.Tag4CC, tags ...dnet.Tag4CC) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	known := g.channels[channel]
+	if known == nil {
+		known = make(map[dnet.Tag4CC]bool)
+		g.channels[channel] = known
+	}
+	for _, t := range tags {
+		known[t] = true
+	}
+}
+
+// labels returns the label values for a channel and tag.
+func (g *Gossip) labels(channel, tag dnet.Tag4CC) (string, string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ch, t := OtherLabel, OtherLabel
+	if tags, ok := g.channels[channel]; ok && channel != 0 {
+		ch = channel.String()
+		if tag != 0 && tags[tag] {
+			t = tag.String()
+		}
+	}
+	return ch, t
+}
+
+// MessageIn counts a verified message received from a peer.
+func (g *Gossip) MessageIn(msg dnet.Message) {
+	ch, tag := g.labels(msg.Chan, msg.Tag)
+	g.MessagesIn.Inc(ch, tag)
+	g.BytesIn.Add(float64(dnet.HeaderSize+len(msg.Payload)), ch, tag)
+}
+
+// MessageOut counts a message written to a peer.
+func (g *Gossip) MessageOut(raw dnet.RawMessage) {
+	ch, tag := g.labels(dnet.MsgView(raw.Header).ChanTag())
+	g.MessagesOut.Inc(ch, tag)
+	g.BytesOut.Add(float64(len(raw.Header)+len(raw.Payload)), ch, tag)
+}
+
+// ReadError counts signature failures and oversized frames.
+func (g *Gossip) ReadError(err error) {
+	switch {
+	case errors.Is(err, dnet.ErrBadSignature):
+		g.SignatureFailures.Inc()
+	case errors.Is(err, dnet.ErrMessageTooLarge):
+		g.OversizedFrames.Inc()
+	}
+}
+
+// DecodeFailure counts a payload that failed to decode.
+// Use as relay.Config.OnDecodeError.
+func (g *Gossip) DecodeFailure(from uint64, msg dnet.Message) {
+	g.DecodeFailures.Inc(g.labels(msg.Chan, msg.Tag))
+}
+
+// Duplicate counts a dedup hit.
+// Use as relay.Config.OnDuplicate.
+func (g *Gossip) Duplicate(from uint64, msg dnet.Message) {
+	g.Duplicates.Inc(g.labels(msg.Chan, msg.Tag))
+}
+
+// WatchPeers reports peer counts and send queue depths.
+func (g *Gossip) WatchPeers(m *peer.Manager) {
+	g.reg.Collect("dogenet_peers", "Connected peers.", TypeGauge, []string{"direction"},
+		func(emit func(float64, ...string)) {
+			in, out := m.Counts()
+			emit(float64(in), "inbound")
+			emit(float64(out), "outbound")
+		})
+	g.reg.Collect("dogenet_peer_queue_messages", "Messages waiting in each peer's send queue.", TypeGauge, []string{"peer"},
+		func(emit func(float64, ...string)) {
+			for _, p := range m.Peers() {
+				emit(float64(p.QueueLen()), strconv.FormatUint(p.ID(), 10))
+			}
+		})
+}
+
+// WatchHandlers reports the number of handlers bound to each channel.
+// Channels that handlers bind become known channels.
+func (g *Gossip) WatchHandlers(s *handler.Server) {
+	g.reg.Collect("dogenet_handlers", "Channel handlers bound to each channel.", TypeGauge, []string{"channel"},
+		func(emit func(float64, ...string)) {
+			for _, ch := range s.Channels() {
+				g.Known(ch)
+				emit(float64(len(s.Handlers(ch))), ch.String())
+			}
+		})
+}
+
+// WatchLimiter reports messages dropped by rate limits.
+// The limiter folds unknown channels and tags into zero tags, which
+// are reported as OtherLabel.
+func (g *Gossip) WatchLimiter(l *ratelimit.Limiter) {
+	g.reg.Collect("dogenet_rate_limited_total", "Messages dropped by rate limits.", TypeCounter, []string{"channel", "tag", "limit"},
+		func(emit func(float64, ...string)) {
+			// counters can share labels, so sum them first
+			sums := make(map[[2]string][2]uint64)
+			for _, c := range l.Counters() {
+				ch, tag := g.labels(c.Chan, c.Tag)
+				s := sums[[2]string{ch, tag}]
+				s[0] += c.PeerLimited
+				s[1] += c.SenderLimited
+				sums[[2]string{ch, tag}] = s
+			}
+			for key, s := range sums {
+				emit(float64(s[0]), key[0], key[1], "peer")
+				emit(float64(s[1]), key[0], key[1], "sender")
+			}
+		})
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/iden"
+	"code.dogecoin.org/gossip/internal/testutil"
+	"code.dogecoin.org/gossip/node"
+	"code.dogecoin.org/gossip/ratelimit"
+	"code.dogecoin.org/gossip/relay"
+)
+
+var testChan = dnet.NewTag("Test")
+var testTag = dnet.NewTag("Tst1")
+
+// has fails unless the output contains each line.
+func has(t *testing.T, out string, lines ...string) {
+	t.Helper()
+	for _, l := range lines {
+		if !strings.Contains(out, "\n"+l+"\n") {
+			t.Errorf("missing %q in\n%s", l, out)
+		}
+	}
+}
+
+func TestMessageLabels(t *testing.T) {
+	r := NewRegistry()
+	g := NewGossip(r)
+	g.MessageIn(dnet.Message{Chan: node.ChannelNode, Tag: node.TagPing, Payload: make([]byte, 8)})
+	g.MessageIn(dnet.Message{Chan: node.ChannelNode, Tag: dnet.NewTag("Junk")})
+	g.MessageIn(dnet.Message{Chan: dnet.NewTag("Jnk1")})
+	g.MessageIn(dnet.Message{Chan: dnet.NewTag("Jnk2"), Tag: node.TagPing})
+	g.MessageOut(dnet.EncodeMessageRaw(testChan, testTag, testutil.NewKey(t), []byte("hi")))
+	has(t, output(t, r),
+		`dogenet_messages_received_total{channel="Node",tag="Ping"} 1`,
+		`dogenet_received_bytes_total{channel="Node",tag="Ping"} 116`,
+		`dogenet_messages_received_total{channel="Node",tag="other"} 1`,
+		`dogenet_messages_received_total{channel="other",tag="other"} 2`,
+		`dogenet_messages_sent_total{channel="other",tag="other"} 1`,
+		`dogenet_sent_bytes_total{channel="other",tag="other"} 110`,
+	)
+
+	g.Known(testChan, testTag)
+	g.MessageOut(dnet.EncodeMessageRaw(testChan, testTag, testutil.NewKey(t), nil))
+	has(t, output(t, r), `dogenet_messages_sent_total{channel="Test",tag="Tst1"} 1`)
+}
+
+func TestBuiltinChannelLabels(t *testing.T) {
+	r := NewRegistry()
+	g := NewGossip(r)
+	g.MessageIn(dnet.Message{Chan: dnet.ChannelIdentity, Tag: iden.TagIdentity})
+	g.MessageIn(dnet.Message{Chan: dnet.ChannelIdentity, Tag: dnet.NewTag("Junk")})
+	g.MessageIn(dnet.Message{Chan: dnet.ChannelChat, Tag: dnet.NewTag("Msg1")})
+	g.MessageIn(dnet.Message{Chan: dnet.ChannelShibeShop, Tag: node.TagPing})
+	g.MessageIn(dnet.Message{Chan: dnet.ChannelB0rk, Tag: iden.TagIdentity})
+	has(t, output(t, r),
+		`dogenet_messages_received_total{channel="Iden",tag="Iden"} 1`,
+		`dogenet_messages_received_total{channel="Iden",tag="other"} 1`,
+		`dogenet_messages_received_total{channel="Chat",tag="other"} 1`,
+		`dogenet_messages_received_total{channel="Shib",tag="other"} 1`,
+		`dogenet_messages_received_total{channel="B0rk",tag="other"} 1`,
+	)
+}
+
+func TestDecodeFailures(t *testing.T) {
+	r := NewRegistry()
+	g := NewGossip(r)
+	e := relay.New(relay.Config{Seed: 1, OnDecodeError: g.DecodeFailure, OnDuplicate: g.Duplicate})
+	key := testutil.NewKey(t)
+	raw := dnet.EncodeMessage(node.ChannelNode, node.TagAddress, key, []byte{1, 2, 3})
+	msg, err := dnet.ReadMessage(strings.NewReader(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.HandleMessage(1, msg)
+	has(t, output(t, r),
+		`dogenet_decode_failures_total{channel="Node",tag="Addr"} 1`,
+	)
+}
+
+func TestReadErrors(t *testing.T) {
+	r := NewRegistry()
+	g := NewGossip(r)
+	g.ReadError(dnet.ErrBadSignature)
+	g.ReadError(dnet.ErrMessageTooLarge)
+	g.ReadError(dnet.ErrMessageTooLarge)
+	has(t, output(t, r),
+		`dogenet_signature_failures_total 1`,
+		`dogenet_oversized_frames_total 2`,
+	)
+}
+
+func TestWatchLimiter(t *testing.T) {
+	r := NewRegistry()
+	g := NewGossip(r)
+	l := ratelimit.New(ratelimit.Config{
+		Channels: map[dnet.Tag4CC]ratelimit.ChannelLimits{
+			node.ChannelNode: {Peer: ratelimit.Every(time.Hour, 1)},
+		},
+		Default: ratelimit.ChannelLimits{Peer: ratelimit.Every(time.Hour, 1)},
+	})
+	g.WatchLimiter(l)
+	for _, m := range []dnet.Message{
+		{Chan: node.ChannelNode, Tag: node.TagPing},
+		{Chan: node.ChannelNode, Tag: node.TagPong},
+		{Chan: dnet.NewTag("Jnk1"), Tag: testTag},
+		{Chan: dnet.NewTag("Jnk1"), Tag: testTag},
+	} {
+		l.AllowPeer(1, m)
+	}
+	has(t, output(t, r),
+		`dogenet_rate_limited_total{channel="Node",tag="other",limit="peer"} 1`,
+		`dogenet_rate_limited_total{channel="other",tag="other",limit="peer"} 1`,
+	)
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultAddr is the default metrics endpoint (local only.)
+const DefaultAddr = "127.0.0.1:9169"
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler serves the registry in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		r.Write(w)
+	})
+}
+
+type ServerConfig struct {
+	Addr string // listen address (default DefaultAddr)
+	Path string // URL path (default "/metrics")
+}
+
+// Serve serves the registry over HTTP until the context is done.
+func Serve(ctx context.Context, cfg ServerConfig, r *Registry) error {
+	if cfg.Addr == "" {
+		cfg.Addr = DefaultAddr
+	}
+	l, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+	return ServeListener(ctx, l, cfg.Path, r)
+}
+
+// ServeListener serves the registry on l until the context is done.
+func ServeListener(ctx context.Context, l net.Listener, path string, r *Registry) error {
+	if path == "" {
+		path = "/metrics"
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, r.Handler())
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	err := srv.Serve(l)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
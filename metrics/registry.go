@@ -0,0 +1,210 @@
+// Package metrics collects counters and gauges and serves them in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+type Type string
+
+const (
+	TypeCounter Type = "counter"
+	TypeGauge   Type = "gauge"
+)
+
+// Registry holds metric families in registration order.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+	names    map[string]bool
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     Type
+	labels  []string
+	mu      sync.Mutex
+	series  map[string]*series // by joined label values
+	collect func(emit func(value float64, labelValues ...string))
+}
+
+type series struct {
+	values []string
+	value  float64
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(f *family) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[f.name] {
+		panic("metrics: duplicate metric " + f.name)
+	}
+	r.names[f.name] = true
+	f.series = make(map[string]*series)
+	r.families = append(r.families, f)
+	return f
+}
+
+// Counter is a monotonically increasing value, with labels.
+type Counter struct{ f *family }
+
+// Gauge is a value that can go up and down, with labels.
+type Gauge struct{ f *family }
+
+// Counter registers a counter with the given label names.
+func (r *Registry) Counter(name, help string, labels ...string) Counter {
+	return Counter{r.register(&family{name: name, help: help, typ: TypeCounter, labels: labels})}
+}
+
+// Gauge registers a gauge with the given label names.
+func (r *Registry) Gauge(name, help string, labels ...string) Gauge {
+	return Gauge{r.register(&family{name: name, help: help, typ: TypeGauge, labels: labels})}
+}
+
+// Collect registers a metric whose series are produced by fn each time
+// the registry is written, for values read from elsewhere (queue
+// depths, peer counts.) fn calls emit once per series.
+func (r *Registry) Collect(name, help string, typ Type, labels []string, fn func(emit func(value float64, labelValues ...string))) {
+	r.register(&family{name: name, help: help, typ: typ, labels: labels, collect: fn})
+}
+
+// Add increases the counter for the label values by n (n must not be negative.)
+func (c Counter) Add(n float64, labelValues ...string) {
+	if n < 0 {
+		panic("metrics: counter decreased: " + c.f.name)
+	}
+	c.f.get(labelValues).value += n
+	c.f.mu.Unlock()
+}
+
+// Inc increases the counter for the label values by one.
+func (c Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Set sets the gauge for the label values.
+func (g Gauge) Set(v float64, labelValues ...string) {
+	g.f.get(labelValues).value = v
+	g.f.mu.Unlock()
+}
+
+// Add adds to the gauge for the label values (n can be negative.)
+func (g Gauge) Add(n float64, labelValues ...string) {
+	g.f.get(labelValues).value += n
+	g.f.mu.Unlock()
+}
+
+// get returns the series for the label values, with f.mu held.
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %v has %d labels, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	s := f.series[key]
+	if s == nil {
+		s = &series{values: append([]string(nil), values...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+// snapshot returns the family's series sorted by label values.
+func (f *family) snapshot() []series {
+	var res []series
+	if f.collect != nil {
+		f.collect(func(value float64, labelValues ...string) {
+			if len(labelValues) != len(f.labels) {
+				panic(fmt.Sprintf("metrics: %v has %d labels, got %d", f.name, len(f.labels), len(labelValues)))
+			}
+			res = append(res, series{values: append([]string(nil), labelValues...), value: value})
+		})
+	} else {
+		f.mu.Lock()
+		res = make([]series, 0, len(f.series))
+		for _, s := range f.series {
+			res = append(res, *s)
+		}
+		f.mu.Unlock()
+		if len(f.labels) == 0 && len(res) == 0 {
+			res = append(res, series{}) // unlabelled metrics start at zero
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return strings.Join(res[i].values, "\xff") < strings.Join(res[j].values, "\xff")
+	})
+	return res
+}
+
+// Write writes all metrics in the Prometheus text format (version 0.0.4.)
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.typ)
+		for _, s := range f.snapshot() {
+			bw.WriteString(f.name)
+			if len(f.labels) > 0 {
+				bw.WriteByte('{')
+				for i, l := range f.labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					fmt.Fprintf(bw, "%s=\"%s\"", l, escapeLabel(s.values[i]))
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(formatValue(s.value))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
+
+// escapeLabel escapes a label value, replacing invalid UTF-8 and control
+// characters other than newline with U+FFFD so every line stays parseable.
+func escapeLabel(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r != '\n' && unicode.IsControl(r) {
+			return utf8.RuneError
+		}
+		return r
+	}, strings.ToValidUTF8(s, string(utf8.RuneError)))
+	return labelEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// output writes the registry and returns the text.
+func output(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if err := r.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("test_total", "Things counted.\nTwice.", "kind")
+	g := r.Gauge("test_level", "A level.")
+	r.Collect("test_collected", "Collected values.", TypeGauge, []string{"a", "b"}, func(emit func(float64, ...string)) {
+		emit(2, "y", "z")
+		emit(1.5, "x", "z")
+	})
+	c.Inc("b")
+	c.Add(2, "a")
+	g.Set(-3)
+	want := `# HELP test_total Things counted.\nTwice.
+# TYPE test_total counter
+test_total{kind="a"} 2
+test_total{kind="b"} 1
+# HELP test_level A level.
+# TYPE test_level gauge
+test_level -3
+# HELP test_collected Collected values.
+# TYPE test_collected gauge
+test_collected{a="x",b="z"} 1.5
+test_collected{a="y",b="z"} 2
+`
+	if got := output(t, r); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUnlabelledStartsAtZero(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("test_total", "Things.")
+	if !strings.Contains(output(t, r), "\ntest_total 0\n") {
+		t.Fatal(output(t, r))
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	for in, want := range map[string]string{
+		`plain`:           `plain`,
+		`a"b`:             `a\"b`,
+		`a\b`:             `a\\b`,
+		"a\nb":            `a\nb`,
+		"a\rb\x00":        "a�b�",
+		"bad\xffutf8":     "bad�utf8",
+		"tab\there\u0085": "tab�here�",
+	} {
+		if got := escapeLabel(in); got != want {
+			t.Errorf("escapeLabel(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCounterDecreasePanics(t *testing.T) {
+	c := NewRegistry().Counter("test_total", "Things.")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("negative Add did not panic")
+		}
+	}()
+	c.Add(-1)
+}
+
+func TestDuplicateNamePanics(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("test_total", "Things.")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate name did not panic")
+		}
+	}()
+	r.Gauge("test_total", "Things.")
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("test_total", "Things.").Inc()
+	h := r.Handler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != contentType {
+		t.Fatalf("GET: %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), "test_total 1\n") {
+		t.Fatalf("GET body %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST: %d", rec.Code)
+	}
+}
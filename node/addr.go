@@ -12,8 +12,7 @@ const AddrMsgMinSize = 56
 
 type AddressMsg struct { // 56 + 4c + 6s
 	Time    dnet.DogeTime // [4] Current Doge Epoch time when this message is signed
-	Address []byte        // [16] network byte order (Big-Endian); IPv4-mapped IPv6 address
-	Port    uint16        // [2] network byte order (Big-Endian)
+	Address dnet.Address  // [16] IPv4-mapped IPv6 address [2] port (Big-Endian)
 	Owner   []byte        // [32] public key of identity claimed by this node (zeroes if not present)
 	// [1] number of channels
 	Channels []dnet.Tag4CC // [4] per service (Chan)
@@ -28,23 +27,19 @@ type Service struct {
 }
 
 func (msg AddressMsg) IsValid() bool {
-	return len(msg.Services) <= 8192 && len(msg.Address) == 16 && len(msg.Owner) == 32
+	return len(msg.Services) <= 8192 && len(msg.Owner) == 32
 }
 
 func (msg AddressMsg) Encode() []byte {
 	if len(msg.Services) > 8192 {
 		panic("Invalid AddrMsg: more than 8192 services")
 	}
-	if len(msg.Address) != 16 {
-		panic("Invalid Address: must be 16 bytes")
-	}
 	if len(msg.Owner) != 32 {
 		panic("Invalid Owner: must be 32 bytes")
 	}
 	e := codec.Encode(AddrMsgMinSize)
 	e.UInt32le(uint32(msg.Time))
-	e.Bytes(msg.Address)
-	e.UInt16be(msg.Port)
+	e.Bytes(msg.Address.ToBytes())
 	e.Bytes(msg.Owner)
 	e.VarUInt(uint64(len(msg.Channels)))
 	for n := 0; n < len(msg.Channels); n++ {
@@ -62,8 +57,7 @@ func (msg AddressMsg) Encode() []byte {
 func DecodeAddrMsg(payload []byte) (msg AddressMsg) {
 	d := codec.Decode(payload)
 	msg.Time = dnet.DogeTime(d.UInt32le())
-	msg.Address = d.Bytes(16)
-	msg.Port = d.UInt16be()
+	msg.Address, _ = dnet.AddressFromBytes(d.Bytes(18))
 	msg.Owner = d.Bytes(32)
 	// decode channels
 	nchannel := d.VarUInt()
@@ -0,0 +1,298 @@
+package node
+
+import (
+	"bytes"
+	"encoding/base32"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+)
+
+var TagAddrV2 = dnet.NewTag("AdV2")
+
+// AddrV2MsgMinSize is the size of an AddressV2Msg with a 4-byte
+// address and no channels or services.
+const AddrV2MsgMinSize = 4 + 1 + 1 + 4 + 2 + 32 + 1 + 1
+const MaxNetAddrSize = 512
+
+// NetworkID identifies the type of a NetAddr (as in BIP155.)
+type NetworkID uint8
+
+const (
+	NetIPv4  NetworkID = 1 // 4 byte IPv4 address
+	NetIPv6  NetworkID = 2 // 16 byte IPv6 address
+	NetTorV3 NetworkID = 4 // 32 byte Tor v3 ed25519 public key
+	NetI2P   NetworkID = 5 // 32 byte SHA-256 hash of the I2P destination
+)
+
+// AddrLen is the address length for a network, or 0 if the network is unknown.
+func (n NetworkID) AddrLen() int {
+	switch n {
+	case NetIPv4:
+		return 4
+	case NetIPv6:
+		return 16
+	case NetTorV3, NetI2P:
+		return 32
+	}
+	return 0
+}
+
+func (n NetworkID) String() string {
+	switch n {
+	case NetIPv4:
+		return "ipv4"
+	case NetIPv6:
+		return "ipv6"
+	case NetTorV3:
+		return "torv3"
+	case NetI2P:
+		return "i2p"
+	}
+	return "unknown"
+}
+
+// NetAddr is a network address tagged with its network type.
+type NetAddr struct {
+	Network NetworkID
+	Addr    []byte // native length for the network
+}
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+const torV3Version = 3
+const onionSuffix = ".onion"
+const i2pSuffix = ".b32.i2p"
+
+var ErrBadNetAddr = errors.New("invalid network address")
+
+// NetAddrFromIP returns the NetAddr for an IP; IPv4 and IPv4-mapped
+// IPv6 addresses become NetIPv4. Returns the zero NetAddr (not IsValid)
+// if ip is not a valid IP.
+func NetAddrFromIP(ip net.IP) NetAddr {
+	if ip4 := ip.To4(); ip4 != nil {
+		return NetAddr{Network: NetIPv4, Addr: append([]byte(nil), ip4...)}
+	}
+	if ip16 := ip.To16(); ip16 != nil {
+		return NetAddr{Network: NetIPv6, Addr: append([]byte(nil), ip16...)}
+	}
+	return NetAddr{}
+}
+
+// ParseNetAddr parses an IP address, a Tor v3 ".onion" name or an
+// I2P ".b32.i2p" name.
+func ParseNetAddr(s string) (NetAddr, error) {
+	lower := strings.ToLower(s)
+	switch {
+	case strings.HasSuffix(lower, onionSuffix):
+		raw, err := b32.DecodeString(strings.ToUpper(strings.TrimSuffix(lower, onionSuffix)))
+		if err != nil || len(raw) != 35 || raw[34] != torV3Version {
+			return NetAddr{}, ErrBadNetAddr
+		}
+		if !bytes.Equal(raw[32:34], torChecksum(raw[:32])) {
+			return NetAddr{}, ErrBadNetAddr
+		}
+		return NetAddr{Network: NetTorV3, Addr: raw[:32]}, nil
+	case strings.HasSuffix(lower, i2pSuffix):
+		raw, err := b32.DecodeString(strings.ToUpper(strings.TrimSuffix(lower, i2pSuffix)))
+		if err != nil || len(raw) != 32 {
+			return NetAddr{}, ErrBadNetAddr
+		}
+		return NetAddr{Network: NetI2P, Addr: raw}, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return NetAddr{}, ErrBadNetAddr
+	}
+	return NetAddrFromIP(ip), nil
+}
+
+// torChecksum is the 2-byte checksum in a Tor v3 onion name.
+func torChecksum(pub []byte) []byte {
+	h := sha3.New256()
+	h.Write([]byte(".onion checksum"))
+	h.Write(pub)
+	h.Write([]byte{torV3Version})
+	return h.Sum(nil)[:2]
+}
+
+// IsValid checks the address length for its network, and that IPv6
+// addresses are not IPv4-mapped (those must use NetIPv4.)
+func (a NetAddr) IsValid() bool {
+	n := a.Network.AddrLen()
+	if n == 0 || len(a.Addr) != n {
+		return false
+	}
+	if a.Network == NetIPv6 && net.IP(a.Addr).To4() != nil {
+		return false
+	}
+	return true
+}
+
+// IsIP reports whether the address is an IPv4 or IPv6 address.
+func (a NetAddr) IsIP() bool {
+	return a.Network == NetIPv4 || a.Network == NetIPv6
+}
+
+// IsOverlay reports whether the address is a Tor v3 or I2P address.
+func (a NetAddr) IsOverlay() bool {
+	return a.Network == NetTorV3 || a.Network == NetI2P
+}
+
+// IP returns the IP address, or nil for overlay networks.
+func (a NetAddr) IP() net.IP {
+	if !a.IsIP() || !a.IsValid() {
+		return nil
+	}
+	return net.IP(a.Addr)
+}
+
+func (a NetAddr) String() string {
+	if !a.IsValid() {
+		return "invalid"
+	}
+	switch a.Network {
+	case NetTorV3:
+		raw := make([]byte, 0, 35)
+		raw = append(raw, a.Addr...)
+		raw = append(raw, torChecksum(a.Addr)...)
+		raw = append(raw, torV3Version)
+		return strings.ToLower(b32.EncodeToString(raw)) + onionSuffix
+	case NetI2P:
+		return strings.ToLower(b32.EncodeToString(a.Addr)) + i2pSuffix
+	}
+	return net.IP(a.Addr).String()
+}
+
+// AddressV2Msg is AddressMsg with a network-tagged address, so nodes
+// reachable over Tor or I2P can announce themselves. It is only sent
+// to peers that negotiated FeatureAddrV2.
+type AddressV2Msg struct { // 46 + a + 4c + 6s
+	Time    dnet.DogeTime // [4] Current Doge Epoch time when this message is signed
+	Address NetAddr       // [1] network [1+] length [a] address
+	Port    uint16        // [2] network byte order (Big-Endian)
+	Owner   []byte        // [32] public key of identity claimed by this node (zeroes if not present)
+	// [1] number of channels
+	Channels []dnet.Tag4CC // [4] per channel (Chan)
+	// [1] number of services
+	Services []Service // [6+] per service (ID + Port + Data)
+}
+
+func (msg AddressV2Msg) IsValid() bool {
+	return len(msg.Services) <= 8192 && len(msg.Channels) <= 8192 && msg.Address.IsValid() && len(msg.Owner) == 32
+}
+
+// HostPort is the address to dial: an IP or overlay name, and the port.
+func (msg AddressV2Msg) HostPort() string {
+	return net.JoinHostPort(msg.Address.String(), strconv.Itoa(int(msg.Port)))
+}
+
+// Encode panics on the same limits DecodeAddrV2Msg enforces.
+// Like decoding, it accepts addresses on unknown networks.
+func (msg AddressV2Msg) Encode() []byte {
+	if len(msg.Channels) > 8192 {
+		panic("Invalid AddrV2Msg: more than 8192 channels")
+	}
+	if len(msg.Services) > 8192 {
+		panic("Invalid AddrV2Msg: more than 8192 services")
+	}
+	if len(msg.Address.Addr) > MaxNetAddrSize {
+		panic("Invalid AddrV2Msg: address longer than 512 bytes")
+	}
+	if len(msg.Owner) != 32 {
+		panic("Invalid Owner: must be 32 bytes")
+	}
+	e := codec.Encode(AddrV2MsgMinSize + len(msg.Address.Addr) + 4*len(msg.Channels) + 6*len(msg.Services))
+	e.UInt32le(uint32(msg.Time))
+	e.UInt8(uint8(msg.Address.Network))
+	e.VarUInt(uint64(len(msg.Address.Addr)))
+	e.Bytes(msg.Address.Addr)
+	e.UInt16be(msg.Port)
+	e.Bytes(msg.Owner)
+	e.VarUInt(uint64(len(msg.Channels)))
+	for n := 0; n < len(msg.Channels); n++ {
+		e.UInt32be(uint32(msg.Channels[n]))
+	}
+	e.VarUInt(uint64(len(msg.Services)))
+	for n := 0; n < len(msg.Services); n++ {
+		e.UInt32be(uint32(msg.Services[n].Tag))
+		e.UInt16be(msg.Services[n].Port)
+		e.VarString(msg.Services[n].Data)
+	}
+	return e.Result()
+}
+
+// DecodeAddrV2Msg decodes an AddressV2Msg. Addresses on unknown networks
+// decode (up to 512 bytes) but are not IsValid.
+func DecodeAddrV2Msg(payload []byte) (msg AddressV2Msg) {
+	d := codec.Decode(payload)
+	msg.Time = dnet.DogeTime(d.UInt32le())
+	msg.Address.Network = NetworkID(d.UInt8())
+	size := d.VarUInt()
+	if size > MaxNetAddrSize {
+		panic("Invalid AddrV2Msg: address longer than 512 bytes")
+	}
+	msg.Address.Addr = d.Bytes(int(size))
+	msg.Port = d.UInt16be()
+	msg.Owner = d.Bytes(32)
+	nchannel := d.VarUInt()
+	if nchannel > 8192 {
+		panic("Invalid AddrV2Msg: more than 8192 channels")
+	}
+	msg.Channels = make([]dnet.Tag4CC, nchannel)
+	for n := 0; n < int(nchannel); n++ {
+		msg.Channels[n] = dnet.Tag4CC(d.UInt32be())
+	}
+	nservice := d.VarUInt()
+	if nservice > 8192 {
+		panic("Invalid AddrV2Msg: more than 8192 services")
+	}
+	msg.Services = make([]Service, nservice)
+	for n := 0; n < int(nservice); n++ {
+		msg.Services[n].Tag = dnet.Tag4CC(d.UInt32be())
+		msg.Services[n].Port = d.UInt16be()
+		msg.Services[n].Data = d.VarString()
+	}
+	return
+}
+
+// ToV2 converts an AddressMsg to an AddressV2Msg (the result must be
+// signed again by the node.) Returns false if the AddressMsg has no
+// valid address.
+func (msg AddressMsg) ToV2() (AddressV2Msg, bool) {
+	addr := NetAddrFromIP(msg.Address.IP())
+	if !addr.IsValid() {
+		return AddressV2Msg{}, false
+	}
+	return AddressV2Msg{
+		Time:     msg.Time,
+		Address:  addr,
+		Port:     msg.Address.Port(),
+		Owner:    msg.Owner,
+		Channels: msg.Channels,
+		Services: msg.Services,
+	}, true
+}
+
+// ToV1 converts an AddressV2Msg with an IP address to an AddressMsg
+// (the result must be signed again by the node.) Returns false for
+// overlay network addresses, which v1 cannot carry.
+func (msg AddressV2Msg) ToV1() (AddressMsg, bool) {
+	ip := msg.Address.IP()
+	if ip == nil {
+		return AddressMsg{}, false
+	}
+	return AddressMsg{
+		Time:     msg.Time,
+		Address:  dnet.AddressFromIP(ip, msg.Port),
+		Owner:    msg.Owner,
+		Channels: msg.Channels,
+		Services: msg.Services,
+	}, true
+}
@@ -0,0 +1,123 @@
+package node
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+)
+
+func overlayAddr(network NetworkID, fill byte) NetAddr {
+	return NetAddr{Network: network, Addr: bytes.Repeat([]byte{fill}, 32)}
+}
+
+func TestNetAddrStrings(t *testing.T) {
+	for _, a := range []NetAddr{
+		NetAddrFromIP(net.IPv4(8, 8, 8, 8)),
+		NetAddrFromIP(net.ParseIP("2001:db8::1")),
+		overlayAddr(NetTorV3, 7),
+		overlayAddr(NetI2P, 9),
+	} {
+		parsed, err := ParseNetAddr(a.String())
+		if err != nil || parsed.Network != a.Network || !bytes.Equal(parsed.Addr, a.Addr) {
+			t.Fatalf("%v: parsed %v %v", a, parsed, err)
+		}
+	}
+	if NetAddrFromIP(net.ParseIP("::ffff:1.2.3.4")).Network != NetIPv4 {
+		t.Fatal("IPv4-mapped address is not NetIPv4")
+	}
+	if NetAddrFromIP(nil).IsValid() || NetAddrFromIP(net.IP{1, 2}).IsValid() {
+		t.Fatal("invalid IP made a valid NetAddr")
+	}
+	onion := overlayAddr(NetTorV3, 7).String()
+	bad := []byte(onion)
+	bad[0] ^= 1 // breaks the checksum
+	if _, err := ParseNetAddr(string(bad)); err != ErrBadNetAddr {
+		t.Fatalf("bad checksum: %v", err)
+	}
+	// a published onion service: its checksum is SHA3-256
+	known := "duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion"
+	if a, err := ParseNetAddr(known); err != nil || a.Network != NetTorV3 || a.String() != known {
+		t.Fatalf("%v: parsed %v %v", known, a, err)
+	}
+}
+
+func TestAddressV2RoundTrip(t *testing.T) {
+	msg := AddressV2Msg{
+		Time:     12345,
+		Address:  overlayAddr(NetTorV3, 7),
+		Port:     42069,
+		Owner:    make([]byte, 32),
+		Channels: []dnet.Tag4CC{dnet.NewTag("Test")},
+		Services: []Service{{Tag: dnet.NewTag("Core"), Port: 22556, Data: "x"}},
+	}
+	got := DecodeAddrV2Msg(msg.Encode())
+	if !got.IsValid() || got.Time != msg.Time || got.Port != msg.Port || got.HostPort() != msg.HostPort() ||
+		len(got.Channels) != 1 || got.Services[0] != msg.Services[0] {
+		t.Fatalf("decoded %+v", got)
+	}
+	if _, ok := got.ToV1(); ok {
+		t.Fatal("converted an onion address to v1")
+	}
+
+	// unknown networks decode, but are not valid
+	msg.Address = NetAddr{Network: 99, Addr: []byte{1, 2, 3}}
+	if got := DecodeAddrV2Msg(msg.Encode()); got.IsValid() || got.Address.Network != 99 {
+		t.Fatalf("unknown network: %+v", got)
+	}
+
+	// Encode refuses what decoding rejects
+	msg.Channels = make([]dnet.Tag4CC, 8193)
+	if codec.TryDecode(func() { msg.Encode() }) {
+		t.Fatal("encoded more than 8192 channels")
+	}
+	msg.Channels = nil
+	msg.Address.Addr = make([]byte, MaxNetAddrSize+1)
+	if codec.TryDecode(func() { msg.Encode() }) {
+		t.Fatal("encoded an address longer than 512 bytes")
+	}
+}
+
+func TestAddressV1V2(t *testing.T) {
+	v1 := AddressMsg{
+		Time:    12345,
+		Address: dnet.AddressFromIP(net.IPv4(8, 8, 8, 8), 42069),
+		Owner:   make([]byte, 32),
+	}
+	v2, ok := v1.ToV2()
+	if !ok || v2.Address.Network != NetIPv4 || v2.Port != 42069 || v2.HostPort() != "8.8.8.8:42069" {
+		t.Fatalf("ToV2: %+v %v", v2, ok)
+	}
+	back, ok := v2.ToV1()
+	if !ok || !back.Address.Equal(v1.Address) || back.Time != v1.Time {
+		t.Fatalf("ToV1: %+v %v", back, ok)
+	}
+	if _, ok := (AddressMsg{Owner: make([]byte, 32)}).ToV2(); ok {
+		t.Fatal("converted the zero address")
+	}
+}
+
+func TestAddressV2Validate(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	msg := AddressV2Msg{
+		Time:    dnet.UnixToDoge(now),
+		Address: overlayAddr(NetI2P, 3),
+		Port:    42069,
+		Owner:   make([]byte, 32),
+	}
+	if r := msg.Validate(now, DefaultAddrRules); len(r) != 0 {
+		t.Fatalf("overlay address rejected: %v", r)
+	}
+	msg.Address = NetAddrFromIP(net.IPv4(192, 168, 1, 1))
+	msg.Port = 0
+	r := msg.Validate(now, DefaultAddrRules)
+	if len(r) != 2 || r[0] != AddrUnroutable || r[1] != AddrNoPort {
+		t.Fatalf("reasons %v", r)
+	}
+	if r := msg.Validate(now, AddrRules{AllowLocal: true}); len(r) != 1 {
+		t.Fatalf("local address with AllowLocal: %v", r)
+	}
+}
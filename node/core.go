@@ -0,0 +1,69 @@
+package node
+
+import (
+	"code.dogecoin.org/gossip/codec"
+)
+
+// Chain identifies a Dogecoin blockchain.
+type Chain uint8
+
+const (
+	ChainMainnet Chain = 1
+	ChainTestnet Chain = 2
+	ChainRegtest Chain = 3
+)
+
+func (c Chain) String() string {
+	switch c {
+	case ChainMainnet:
+		return "mainnet"
+	case ChainTestnet:
+		return "testnet"
+	case ChainRegtest:
+		return "regtest"
+	}
+	return "unknown"
+}
+
+const CoreServiceSize = 10
+
+// Flags in CoreService.Flags.
+const (
+	CorePruned uint8 = 1 << 0 // the node has discarded old blocks
+)
+
+// CoreService is the Data of a dnet.ServiceCore service: a Dogecoin
+// Core node accepting P2P connections on the service's Port.
+type CoreService struct { // 10
+	Chain   Chain  // [1] chain the node is on
+	Version uint32 // [4] P2P protocol version (e.g. 70015)
+	Height  uint32 // [4] best block height
+	Flags   uint8  // [1] CorePruned
+}
+
+func (svc CoreService) Pruned() bool {
+	return svc.Flags&CorePruned != 0
+}
+
+func (svc CoreService) ServiceChain() Chain {
+	return svc.Chain
+}
+
+func (svc CoreService) Encode() string {
+	e := codec.Encode(CoreServiceSize)
+	e.UInt8(uint8(svc.Chain))
+	e.UInt32le(svc.Version)
+	e.UInt32le(svc.Height)
+	e.UInt8(svc.Flags)
+	return string(e.Result())
+}
+
+func DecodeCoreService(data string) (svc CoreService) {
+	d := codec.Decode([]byte(data))
+	svc.Chain = Chain(d.UInt8())
+	svc.Version = d.UInt32le()
+	svc.Height = d.UInt32le()
+	svc.Flags = d.UInt8()
+	// future versions can add fields to the end; check d.Has(n) bytes.
+	return
+}
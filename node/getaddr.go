@@ -0,0 +1,104 @@
+package node
+
+import (
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+)
+
+var TagGetAddr = dnet.NewTag("GetA")
+
+// MaxGetAddr is the most addresses a peer will send in reply to GetAddr.
+const MaxGetAddr = 1000
+const MaxGetAddrFilter = 64
+
+// GetAddrMsg asks a peer for a sample of the node addresses it knows.
+// The peer replies with up to Max original signed TagAddress messages.
+// If Channels or Services are given, only nodes offering at least one
+// of the listed channels and one of the listed services are returned.
+type GetAddrMsg struct { // 6+ + 4c + 4s
+	Max      uint16        // [2] most addresses wanted (capped at MaxGetAddr)
+	MaxAge   uint32        // [4] only addresses signed in the last MaxAge seconds (0 = any)
+	Channels []dnet.Tag4CC // [1+] count [4]xN channel filter (optional)
+	Services []dnet.Tag4CC // [1+] count [4]xN service filter (optional)
+}
+
+func (msg GetAddrMsg) IsValid() bool {
+	return len(msg.Channels) <= MaxGetAddrFilter && len(msg.Services) <= MaxGetAddrFilter
+}
+
+func (msg GetAddrMsg) Encode() []byte {
+	if !msg.IsValid() {
+		panic("Invalid GetAddrMsg: more than 64 filter tags")
+	}
+	e := codec.Encode(8 + 4*len(msg.Channels) + 4*len(msg.Services))
+	e.UInt16le(msg.Max)
+	e.UInt32le(msg.MaxAge)
+	e.VarUInt(uint64(len(msg.Channels)))
+	for _, c := range msg.Channels {
+		e.UInt32be(uint32(c))
+	}
+	e.VarUInt(uint64(len(msg.Services)))
+	for _, s := range msg.Services {
+		e.UInt32be(uint32(s))
+	}
+	return e.Result()
+}
+
+func DecodeGetAddrMsg(payload []byte) (msg GetAddrMsg) {
+	d := codec.Decode(payload)
+	msg.Max = d.UInt16le()
+	msg.MaxAge = d.UInt32le()
+	msg.Channels = decodeTags(d)
+	msg.Services = decodeTags(d)
+	return
+}
+
+func decodeTags(d *codec.Decoder) []dnet.Tag4CC {
+	num := d.VarUInt()
+	if num > MaxGetAddrFilter {
+		panic("Invalid GetAddrMsg: more than 64 filter tags")
+	}
+	tags := make([]dnet.Tag4CC, num)
+	for n := range tags {
+		tags[n] = dnet.Tag4CC(d.UInt32be())
+	}
+	return tags
+}
+
+// Offers reports whether an AddressMsg matches the GetAddr filters.
+func (msg GetAddrMsg) Offers(addr AddressMsg) bool {
+	if len(msg.Channels) > 0 && !anyTag(msg.Channels, addr.Channels) {
+		return false
+	}
+	if len(msg.Services) > 0 {
+		found := false
+		for _, s := range addr.Services {
+			if hasTag(msg.Services, s.Tag) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func anyTag(want []dnet.Tag4CC, have []dnet.Tag4CC) bool {
+	for _, t := range have {
+		if hasTag(want, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTag(tags []dnet.Tag4CC, tag dnet.Tag4CC) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
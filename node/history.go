@@ -0,0 +1,137 @@
+package node
+
+import (
+	"bytes"
+
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+)
+
+var TagGetHistory = dnet.NewTag("GetH")
+var TagHistory = dnet.NewTag("Hist")
+
+const MaxHistoryItems = 500
+const MaxHistoryBytes = 4 << 20
+const MaxHistoryTags = 64
+
+// GetHistoryMsg asks for messages on a channel stored since a time.
+// Peers send it on ChannelNode; handlers send it on the bound channel.
+// The reply is a HistoryMsg; to get the next page, send the request
+// again with Cursor set to the reply's Cursor.
+type GetHistoryMsg struct { // 19+ + 4t
+	Chan   dnet.Tag4CC   // [4] channel (Big-Endian)
+	Since  dnet.DogeTime // [4] messages stored at or after this time
+	Cursor uint64        // [8] resume after this position (0 to start)
+	Limit  uint16        // [2] most messages wanted (0 for the server's limit)
+	Tags   []dnet.Tag4CC // [1+] count [4]xN only these tags (optional)
+}
+
+// HistoryMsg is a page of stored messages, as original signed frames.
+type HistoryMsg struct { // 14+ + frames
+	Chan   dnet.Tag4CC       // [4] channel (Big-Endian)
+	Cursor uint64            // [8] position of the last message; send in the next request
+	More   bool              // [1] more messages are available now
+	Frames []dnet.RawMessage // [1+] count, then [1+] size [n] header+payload per frame
+}
+
+func (msg GetHistoryMsg) IsValid() bool {
+	return len(msg.Tags) <= MaxHistoryTags
+}
+
+func (msg GetHistoryMsg) Encode() []byte {
+	if len(msg.Tags) > MaxHistoryTags {
+		panic("Invalid GetHistoryMsg: more than 64 tags")
+	}
+	e := codec.Encode(19 + 4*len(msg.Tags))
+	e.UInt32be(uint32(msg.Chan))
+	e.UInt32le(uint32(msg.Since))
+	e.UInt64le(msg.Cursor)
+	e.UInt16le(msg.Limit)
+	e.VarUInt(uint64(len(msg.Tags)))
+	for _, t := range msg.Tags {
+		e.UInt32be(uint32(t))
+	}
+	return e.Result()
+}
+
+func DecodeGetHistoryMsg(payload []byte) (msg GetHistoryMsg) {
+	d := codec.Decode(payload)
+	msg.Chan = dnet.Tag4CC(d.UInt32be())
+	msg.Since = dnet.DogeTime(d.UInt32le())
+	msg.Cursor = d.UInt64le()
+	msg.Limit = d.UInt16le()
+	num := d.VarUInt()
+	if num > MaxHistoryTags {
+		panic("Invalid GetHistoryMsg: more than 64 tags")
+	}
+	msg.Tags = make([]dnet.Tag4CC, num)
+	for n := range msg.Tags {
+		msg.Tags[n] = dnet.Tag4CC(d.UInt32be())
+	}
+	return
+}
+
+// WantsTag reports whether the request includes a tag.
+func (msg GetHistoryMsg) WantsTag(tag dnet.Tag4CC) bool {
+	return len(msg.Tags) == 0 || hasTag(msg.Tags, tag)
+}
+
+func (msg HistoryMsg) Encode() []byte {
+	if len(msg.Frames) > MaxHistoryItems {
+		panic("Invalid HistoryMsg: more than 500 frames")
+	}
+	size := 16
+	for _, f := range msg.Frames {
+		size += 5 + len(f.Header) + len(f.Payload)
+	}
+	e := codec.Encode(size)
+	e.UInt32be(uint32(msg.Chan))
+	e.UInt64le(msg.Cursor)
+	e.Bool(msg.More)
+	e.VarUInt(uint64(len(msg.Frames)))
+	for _, f := range msg.Frames {
+		e.VarUInt(uint64(len(f.Header) + len(f.Payload)))
+		e.Bytes(f.Header)
+		e.Bytes(f.Payload)
+	}
+	return e.Result()
+}
+
+func DecodeHistoryMsg(payload []byte) (msg HistoryMsg) {
+	d := codec.Decode(payload)
+	msg.Chan = dnet.Tag4CC(d.UInt32be())
+	msg.Cursor = d.UInt64le()
+	msg.More = d.Bool()
+	num := d.VarUInt()
+	if num > MaxHistoryItems {
+		panic("Invalid HistoryMsg: more than 500 frames")
+	}
+	msg.Frames = make([]dnet.RawMessage, num)
+	for n := range msg.Frames {
+		size := d.VarUInt()
+		if size < dnet.HeaderSize || size > MaxHistoryBytes {
+			panic("Invalid HistoryMsg: bad frame size")
+		}
+		frame := d.Bytes(int(size))
+		msg.Frames[n] = dnet.RawMessage{Header: frame[:dnet.HeaderSize], Payload: frame[dnet.HeaderSize:]}
+	}
+	return
+}
+
+// Messages verifies the frames, returning those with valid signatures
+// on the requested channel.
+func (msg HistoryMsg) Messages() []dnet.Message {
+	res := make([]dnet.Message, 0, len(msg.Frames))
+	for _, f := range msg.Frames {
+		m, err := dnet.ReadMessage(frameReader(f))
+		if err == nil && m.Chan == msg.Chan {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+func frameReader(f dnet.RawMessage) *bytes.Reader {
+	b := make([]byte, 0, len(f.Header)+len(f.Payload))
+	return bytes.NewReader(append(append(b, f.Header...), f.Payload...))
+}
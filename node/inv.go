@@ -0,0 +1,43 @@
+package node
+
+import (
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+)
+
+var TagInv = dnet.NewTag("Inv ")
+var TagGetMsgs = dnet.NewTag("GetM")
+
+const MaxInvItems = 1000
+
+// InvMsg lists message IDs.
+// With TagInv it announces messages the sender has; with TagGetMsgs
+// it requests those messages, which are sent back as original frames.
+type InvMsg struct { // 1+ + 32n
+	IDs []dnet.MsgID // [1+] count [32]xN message IDs
+}
+
+func (msg InvMsg) Encode() []byte {
+	if len(msg.IDs) > MaxInvItems {
+		panic("Invalid InvMsg: more than 1000 items")
+	}
+	e := codec.Encode(3 + 32*len(msg.IDs))
+	e.VarUInt(uint64(len(msg.IDs)))
+	for _, id := range msg.IDs {
+		e.Bytes(id[:])
+	}
+	return e.Result()
+}
+
+func DecodeInvMsg(payload []byte) (msg InvMsg) {
+	d := codec.Decode(payload)
+	num := d.VarUInt()
+	if num > MaxInvItems {
+		panic("Invalid InvMsg: more than 1000 items")
+	}
+	msg.IDs = make([]dnet.MsgID, num)
+	for n := range msg.IDs {
+		copy(msg.IDs[n][:], d.Bytes(32))
+	}
+	return
+}
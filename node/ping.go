@@ -0,0 +1,27 @@
+package node
+
+import (
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+)
+
+var TagPing = dnet.NewTag("Ping")
+var TagPong = dnet.NewTag("Pong")
+
+// PingMsg is sent periodically to check a peer is alive.
+// With TagPing the peer must reply with TagPong echoing Nonce.
+type PingMsg struct { // 8
+	Nonce uint64 // [8] random; echoed in Pong
+}
+
+func (msg PingMsg) Encode() []byte {
+	e := codec.Encode(8)
+	e.UInt64le(msg.Nonce)
+	return e.Result()
+}
+
+func DecodePingMsg(payload []byte) (msg PingMsg) {
+	d := codec.Decode(payload)
+	msg.Nonce = d.UInt64le()
+	return
+}
@@ -0,0 +1,68 @@
+package node
+
+import (
+	"sync"
+
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+)
+
+// ServiceData is the decoded Data of a Service with a known schema.
+type ServiceData interface {
+	Encode() string
+}
+
+// ChainData is ServiceData for a service that serves one blockchain.
+type ChainData interface {
+	ServiceData
+	ServiceChain() Chain
+}
+
+// ServiceSchema describes the Data format of a service tag.
+type ServiceSchema struct {
+	Name   string                        // human-readable service name
+	Decode func(data string) ServiceData // decodes Data; panics if malformed
+}
+
+var schemaMu sync.RWMutex
+var schemas = map[dnet.Tag4CC]ServiceSchema{
+	dnet.ServiceCore: {Name: "Dogecoin Core", Decode: func(data string) ServiceData { return DecodeCoreService(data) }},
+}
+
+// RegisterService adds (or replaces) the schema for a service tag.
+func RegisterService(tag dnet.Tag4CC, schema ServiceSchema) {
+	schemaMu.Lock()
+	schemas[tag] = schema
+	schemaMu.Unlock()
+}
+
+// LookupService returns the schema registered for a service tag.
+func LookupService(tag dnet.Tag4CC) (ServiceSchema, bool) {
+	schemaMu.RLock()
+	defer schemaMu.RUnlock()
+	s, ok := schemas[tag]
+	return s, ok
+}
+
+// Decode decodes the service's Data using its registered schema.
+// Returns false if the tag has no schema or Data is malformed.
+func (s Service) Decode() (data ServiceData, ok bool) {
+	schema, found := LookupService(s.Tag)
+	if !found {
+		return nil, false
+	}
+	if !codec.TryDecode(func() { data = schema.Decode(s.Data) }) {
+		return nil, false
+	}
+	return data, true
+}
+
+// Service returns the service with a tag, if msg offers it.
+func (msg AddressMsg) Service(tag dnet.Tag4CC) (Service, bool) {
+	for _, s := range msg.Services {
+		if s.Tag == tag {
+			return s, true
+		}
+	}
+	return Service{}, false
+}
@@ -0,0 +1,71 @@
+package node
+
+import (
+	"testing"
+
+	"code.dogecoin.org/gossip/dnet"
+)
+
+func TestCoreService(t *testing.T) {
+	svc := CoreService{Chain: ChainTestnet, Version: 70015, Height: 123456, Flags: CorePruned}
+	data := svc.Encode()
+	if len(data) != CoreServiceSize {
+		t.Fatalf("encoded %d bytes", len(data))
+	}
+	got, ok := Service{Tag: dnet.ServiceCore, Port: 44556, Data: data}.Decode()
+	if !ok || got != svc {
+		t.Fatalf("decoded %+v %v", got, ok)
+	}
+	if cd, ok := got.(ChainData); !ok || cd.ServiceChain() != ChainTestnet || !svc.Pruned() {
+		t.Fatal("chain data")
+	}
+	// later versions can append fields
+	if got, ok := (Service{Tag: dnet.ServiceCore, Data: data + "more"}).Decode(); !ok || got != svc {
+		t.Fatalf("longer data: %+v %v", got, ok)
+	}
+	if _, ok := (Service{Tag: dnet.ServiceCore, Data: data[:5]}).Decode(); ok {
+		t.Fatal("decoded short data")
+	}
+	if ChainMainnet.String() != "mainnet" || Chain(0).String() != "unknown" {
+		t.Fatal("chain names")
+	}
+}
+
+// tagData is the ServiceData of the test schema.
+type tagData string
+
+func (d tagData) Encode() string { return string(d) }
+
+func TestRegisterService(t *testing.T) {
+	tag := dnet.NewTag("TstS")
+	if _, ok := (Service{Tag: tag, Data: "x"}).Decode(); ok {
+		t.Fatal("decoded a service without a schema")
+	}
+	t.Cleanup(func() {
+		schemaMu.Lock()
+		delete(schemas, tag)
+		schemaMu.Unlock()
+	})
+	RegisterService(tag, ServiceSchema{Name: "Test", Decode: func(data string) ServiceData {
+		if data == "" {
+			panic("empty")
+		}
+		return tagData(data)
+	}})
+	if s, ok := LookupService(tag); !ok || s.Name != "Test" {
+		t.Fatal("schema not registered")
+	}
+	if got, ok := (Service{Tag: tag, Data: "x"}).Decode(); !ok || got != tagData("x") {
+		t.Fatalf("decoded %v %v", got, ok)
+	}
+	if _, ok := (Service{Tag: tag}).Decode(); ok {
+		t.Fatal("decoded malformed data")
+	}
+	msg := AddressMsg{Services: []Service{{Tag: dnet.ServiceCore}, {Tag: tag, Port: 9}}}
+	if s, ok := msg.Service(tag); !ok || s.Port != 9 {
+		t.Fatal("Service lookup")
+	}
+	if _, ok := msg.Service(dnet.NewTag("None")); ok {
+		t.Fatal("found a service not offered")
+	}
+}
@@ -0,0 +1,128 @@
+package node
+
+import (
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+)
+
+// AddrReason is a reason Validate rejects an AddressMsg.
+type AddrReason uint8
+
+const (
+	AddrUnroutable   AddrReason = iota + 1 // address is unspecified or not publicly routable
+	AddrNoPort                             // port is zero
+	AddrChannelOrder                       // channels are duplicated or not in ascending order
+	AddrServiceOrder                       // services are duplicated or not in ascending tag order
+	AddrBadOwner                           // owner is neither zero nor a valid public key
+	AddrTimeSkew                           // time is too far in the future or the past
+	AddrServiceData                        // a service's Data is longer than MaxServiceData
+)
+
+func (r AddrReason) String() string {
+	switch r {
+	case AddrUnroutable:
+		return "unroutable"
+	case AddrNoPort:
+		return "no-port"
+	case AddrChannelOrder:
+		return "channel-order"
+	case AddrServiceOrder:
+		return "service-order"
+	case AddrBadOwner:
+		return "bad-owner"
+	case AddrTimeSkew:
+		return "time-skew"
+	case AddrServiceData:
+		return "service-data"
+	}
+	return "unknown"
+}
+
+// AddrRules are the limits Validate applies. A zero limit is not checked.
+type AddrRules struct {
+	AllowLocal     bool          // accept private and loopback addresses (LAN discovery, testnets)
+	MaxFuture      time.Duration // how far ahead of now Time may be
+	MaxAge         time.Duration // how far behind now Time may be
+	MaxServiceData int           // longest Service.Data in bytes
+}
+
+// DefaultAddrRules match the addrman horizon and allow a little clock drift.
+var DefaultAddrRules = AddrRules{
+	MaxFuture:      10 * time.Minute,
+	MaxAge:         30 * 24 * time.Hour,
+	MaxServiceData: 256,
+}
+
+// Validate checks the meaning of an AddressMsg, beyond the lengths
+// checked by IsValid, returning every reason it should be rejected
+// (none if the message is acceptable.)
+func (msg AddressMsg) Validate(now time.Time, rules AddrRules) (reasons []AddrReason) {
+	if !addrAllowed(msg.Address, rules.AllowLocal) {
+		reasons = append(reasons, AddrUnroutable)
+	}
+	return validateFields(reasons, msg.Address.Port(), msg.Time, msg.Owner, msg.Channels, msg.Services, now, rules)
+}
+
+// Validate checks an AddressV2Msg as AddressMsg.Validate does.
+// Tor v3 and I2P addresses are always routable.
+func (msg AddressV2Msg) Validate(now time.Time, rules AddrRules) (reasons []AddrReason) {
+	overlay := msg.Address.IsOverlay() && msg.Address.IsValid()
+	if !overlay && !addrAllowed(dnet.AddressFromIP(msg.Address.IP(), msg.Port), rules.AllowLocal) {
+		reasons = append(reasons, AddrUnroutable)
+	}
+	return validateFields(reasons, msg.Port, msg.Time, msg.Owner, msg.Channels, msg.Services, now, rules)
+}
+
+// validateFields checks the fields AddressMsg and AddressV2Msg share.
+func validateFields(reasons []AddrReason, port uint16, ts dnet.DogeTime, owner []byte, channels []dnet.Tag4CC, services []Service, now time.Time, rules AddrRules) []AddrReason {
+	if port == 0 {
+		reasons = append(reasons, AddrNoPort)
+	}
+	for i := 1; i < len(channels); i++ {
+		if channels[i] <= channels[i-1] {
+			reasons = append(reasons, AddrChannelOrder)
+			break
+		}
+	}
+	for i := 1; i < len(services); i++ {
+		if services[i].Tag <= services[i-1].Tag {
+			reasons = append(reasons, AddrServiceOrder)
+			break
+		}
+	}
+	if !validOwner(owner) {
+		reasons = append(reasons, AddrBadOwner)
+	}
+	t := ts.Local()
+	if (rules.MaxFuture > 0 && t.After(now.Add(rules.MaxFuture))) ||
+		(rules.MaxAge > 0 && t.Before(now.Add(-rules.MaxAge))) {
+		reasons = append(reasons, AddrTimeSkew)
+	}
+	if rules.MaxServiceData > 0 {
+		for _, s := range services {
+			if len(s.Data) > rules.MaxServiceData {
+				reasons = append(reasons, AddrServiceData)
+				break
+			}
+		}
+	}
+	return reasons
+}
+
+func addrAllowed(addr dnet.Address, local bool) bool {
+	if !local {
+		return addr.IsRoutable()
+	}
+	ip := addr.Host()
+	return ip.IsValid() && !ip.IsUnspecified() && !ip.IsMulticast()
+}
+
+// validOwner accepts no owner (all zeroes) or a valid public key.
+func validOwner(owner []byte) bool {
+	if len(owner) != 32 {
+		return false
+	}
+	var zero [32]byte
+	return string(owner) == string(zero[:]) || dnet.ValidPubKey(owner)
+}
@@ -0,0 +1,111 @@
+package node
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+)
+
+var testNow = time.Unix(1700000000, 0)
+
+func validAddr() AddressMsg {
+	return AddressMsg{
+		Time:     dnet.UnixToDoge(testNow),
+		Address:  dnet.AddressFromIP(net.IPv4(8, 8, 8, 8), 22556),
+		Owner:    make([]byte, 32),
+		Channels: []dnet.Tag4CC{dnet.NewTag("Chat"), dnet.NewTag("Iden")},
+		Services: []Service{{Tag: dnet.ServiceCore, Port: 22556}, {Tag: dnet.NewTag("Xtra"), Port: 1, Data: "ok"}},
+	}
+}
+
+func sameReasons(got []AddrReason, want ...AddrReason) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestValidate(t *testing.T) {
+	key, err := dnet.GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]struct {
+		change func(*AddressMsg)
+		want   []AddrReason
+	}{
+		"valid":         {func(m *AddressMsg) {}, nil},
+		"owner":         {func(m *AddressMsg) { m.Owner = key.Pub[:] }, nil},
+		"no services":   {func(m *AddressMsg) { m.Services = nil; m.Channels = nil }, nil},
+		"private":       {func(m *AddressMsg) { m.Address = dnet.AddressFromIP(net.IPv4(10, 0, 0, 1), 1) }, []AddrReason{AddrUnroutable}},
+		"unspecified":   {func(m *AddressMsg) { m.Address = dnet.AddressFromIP(net.IPv6unspecified, 1) }, []AddrReason{AddrUnroutable}},
+		"site-local":    {func(m *AddressMsg) { m.Address = dnet.NewAddress(netip.MustParseAddr("fec0::1"), 1) }, []AddrReason{AddrUnroutable}},
+		"no port":       {func(m *AddressMsg) { m.Address = dnet.AddressFromIP(net.IPv4(8, 8, 8, 8), 0) }, []AddrReason{AddrNoPort}},
+		"channel dup":   {func(m *AddressMsg) { m.Channels[1] = m.Channels[0] }, []AddrReason{AddrChannelOrder}},
+		"channel order": {func(m *AddressMsg) { m.Channels[0], m.Channels[1] = m.Channels[1], m.Channels[0] }, []AddrReason{AddrChannelOrder}},
+		"service dup":   {func(m *AddressMsg) { m.Services[1].Tag = m.Services[0].Tag }, []AddrReason{AddrServiceOrder}},
+		"service order": {func(m *AddressMsg) { m.Services[0], m.Services[1] = m.Services[1], m.Services[0] }, []AddrReason{AddrServiceOrder}},
+		"off curve":     {func(m *AddressMsg) { m.Owner = bytes.Repeat([]byte{0xff}, 32) }, []AddrReason{AddrBadOwner}},
+		"short owner":   {func(m *AddressMsg) { m.Owner = m.Owner[:31] }, []AddrReason{AddrBadOwner}},
+		"future":        {func(m *AddressMsg) { m.Time = dnet.UnixToDoge(testNow.Add(11 * time.Minute)) }, []AddrReason{AddrTimeSkew}},
+		"past":          {func(m *AddressMsg) { m.Time = dnet.UnixToDoge(testNow.Add(-31 * 24 * time.Hour)) }, []AddrReason{AddrTimeSkew}},
+		"service data":  {func(m *AddressMsg) { m.Services[1].Data = strings.Repeat("x", 257) }, []AddrReason{AddrServiceData}},
+		"several": {func(m *AddressMsg) {
+			m.Address = dnet.AddressFromIP(net.IPv4(127, 0, 0, 1), 0)
+			m.Channels[1] = m.Channels[0]
+		}, []AddrReason{AddrUnroutable, AddrNoPort, AddrChannelOrder}},
+	} {
+		m := validAddr()
+		c.change(&m)
+		if got := m.Validate(testNow, DefaultAddrRules); !sameReasons(got, c.want...) {
+			t.Errorf("%v: got %v, want %v", name, got, c.want)
+		}
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	m := validAddr()
+	m.Address = dnet.AddressFromIP(net.IPv4(192, 168, 1, 2), 22556)
+	m.Time = dnet.UnixToDoge(testNow.Add(-365 * 24 * time.Hour))
+	m.Services[1].Data = strings.Repeat("x", 1000)
+	if got := m.Validate(testNow, AddrRules{AllowLocal: true}); len(got) != 0 {
+		t.Fatalf("zero limits rejected %v", got)
+	}
+	m.Address = dnet.AddressFromIP(net.IPv4(224, 0, 0, 1), 22556)
+	if got := m.Validate(testNow, AddrRules{AllowLocal: true}); !sameReasons(got, AddrUnroutable) {
+		t.Fatalf("multicast: %v", got)
+	}
+	if AddrServiceData.String() != "service-data" || AddrReason(99).String() != "unknown" {
+		t.Fatal("reason names")
+	}
+}
+
+func TestValidateV2(t *testing.T) {
+	m := validAddr()
+	v2, ok := m.ToV2()
+	if !ok {
+		t.Fatal("ToV2 failed")
+	}
+	if got := v2.Validate(testNow, DefaultAddrRules); len(got) != 0 {
+		t.Fatalf("valid: %v", got)
+	}
+	v2.Address = overlayAddr(NetTorV3, 7)
+	if got := v2.Validate(testNow, DefaultAddrRules); len(got) != 0 {
+		t.Fatalf("onion: %v", got)
+	}
+	v2.Address.Addr = v2.Address.Addr[:16]
+	v2.Port = 0
+	if got := v2.Validate(testNow, DefaultAddrRules); !sameReasons(got, AddrUnroutable, AddrNoPort) {
+		t.Fatalf("short onion: %v", got)
+	}
+}
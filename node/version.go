@@ -0,0 +1,85 @@
+package node
+
+import (
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+)
+
+var TagVersion = dnet.NewTag("Vers")
+var TagVerAck = dnet.NewTag("VAck")
+
+// The protocol version implemented by this library.
+const ProtocolVersion = 1
+
+// Feature bits advertised in VersionMsg.
+const (
+	FeatureInventory uint64 = 1 << 0 // inventory relay (TagInv, TagGetMsgs)
+	FeatureAddrV2    uint64 = 1 << 1 // network-tagged addresses (TagAddrV2)
+)
+
+const VersionMsgMinSize = 4 + 8 + 4 + 16 + 2 + 32 + 8 + 1
+const MaxUserAgent = 256
+
+// VersionMsg is the first message each side sends on a new connection.
+// The other side replies with a VerAckMsg echoing Nonce, signed with its
+// own key, proving it holds the key it claims.
+type VersionMsg struct { // 75 + ua
+	Version   uint32        // [4] protocol version
+	Features  uint64        // [8] feature bits
+	StartTime dnet.DogeTime // [4] time the node started (Doge Epoch)
+	Remote    dnet.Address  // [18] the peer's address as we see it; IPv4-mapped IPv6 address, port (Big-Endian)
+	PubKey    []byte        // [32] node public key (must match the message header)
+	Nonce     uint64        // [8] random; echoed in VerAck
+	UserAgent string        // [1+] software name and version (up to 256 bytes)
+}
+
+type VerAckMsg struct { // 8
+	Nonce uint64 // [8] nonce from the VersionMsg being acknowledged
+}
+
+func (msg VersionMsg) IsValid() bool {
+	return len(msg.PubKey) == 32 && len(msg.UserAgent) <= MaxUserAgent
+}
+
+func (msg VersionMsg) Encode() []byte {
+	if len(msg.PubKey) != 32 {
+		panic("Invalid VersionMsg: PubKey must be 32 bytes")
+	}
+	if len(msg.UserAgent) > MaxUserAgent {
+		panic("Invalid VersionMsg: UserAgent longer than 256")
+	}
+	e := codec.Encode(VersionMsgMinSize + len(msg.UserAgent))
+	e.UInt32le(msg.Version)
+	e.UInt64le(msg.Features)
+	e.UInt32le(uint32(msg.StartTime))
+	e.Bytes(msg.Remote.ToBytes())
+	e.Bytes(msg.PubKey)
+	e.UInt64le(msg.Nonce)
+	e.VarString(msg.UserAgent)
+	return e.Result()
+}
+
+func DecodeVersionMsg(payload []byte) (msg VersionMsg) {
+	d := codec.Decode(payload)
+	msg.Version = d.UInt32le()
+	msg.Features = d.UInt64le()
+	msg.StartTime = dnet.DogeTime(d.UInt32le())
+	msg.Remote, _ = dnet.AddressFromBytes(d.Bytes(18))
+	msg.PubKey = d.Bytes(32)
+	msg.Nonce = d.UInt64le()
+	msg.UserAgent = d.VarString()
+	// future versions can add fields to the end; check d.Has(n) bytes.
+	return
+}
+
+func (msg VerAckMsg) Encode() []byte {
+	e := codec.Encode(8)
+	e.UInt64le(msg.Nonce)
+	return e.Result()
+}
+
+func DecodeVerAckMsg(payload []byte) (msg VerAckMsg) {
+	d := codec.Decode(payload)
+	msg.Nonce = d.UInt64le()
+	return
+}
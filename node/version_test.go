@@ -0,0 +1,38 @@
+package node
+
+import (
+	"testing"
+
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+)
+
+func TestVersionMsgRoundTrip(t *testing.T) {
+	remote, _ := dnet.ParseAddress("1.2.3.4:42069")
+	msg := VersionMsg{
+		Version:   ProtocolVersion,
+		Features:  FeatureInventory | FeatureAddrV2,
+		StartTime: 12345,
+		Remote:    remote,
+		PubKey:    make([]byte, 32),
+		Nonce:     0x0102030405060708,
+		UserAgent: "gossip/test",
+	}
+	msg.PubKey[0] = 9
+	payload := msg.Encode()
+	if len(payload) != VersionMsgMinSize+len(msg.UserAgent) {
+		t.Fatalf("encoded %d bytes", len(payload))
+	}
+	got := DecodeVersionMsg(payload)
+	if got.Version != msg.Version || got.Features != msg.Features || got.StartTime != msg.StartTime ||
+		!got.Remote.Equal(remote) || string(got.PubKey) != string(msg.PubKey) ||
+		got.Nonce != msg.Nonce || got.UserAgent != msg.UserAgent || !got.IsValid() {
+		t.Fatalf("decoded %+v", got)
+	}
+	if codec.TryDecode(func() { DecodeVersionMsg(payload[:VersionMsgMinSize-2]) }) {
+		t.Fatal("decoded a truncated VersionMsg")
+	}
+	if DecodeVerAckMsg(VerAckMsg{Nonce: 42}.Encode()).Nonce != 42 {
+		t.Fatal("VerAck nonce not round-tripped")
+	}
+}
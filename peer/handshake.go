@@ -0,0 +1,160 @@
+package peer
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+)
+
+var ErrHandshake = errors.New("peer handshake failed")
+var ErrHandshakeTimeout = errors.New("peer handshake timed out")
+var ErrSelfConnect = errors.New("connected to self")
+
+// handshake state, owned by the reader goroutine until the peer is ready.
+type handshake struct {
+	nonce      uint64 // our nonce, echoed in the peer's VerAck
+	gotVersion bool
+	gotAck     bool
+}
+
+// sendVersion queues our VersionMsg; it is the first message sent.
+func (p *Peer) sendVersion() {
+	m := p.mgr
+	ver := node.VersionMsg{
+		Version:   node.ProtocolVersion,
+		Features:  m.cfg.Features,
+		StartTime: m.startTime,
+		Remote:    p.addr,
+		PubKey:    m.cfg.Key.Pub[:],
+		Nonce:     p.hs.nonce,
+		UserAgent: m.cfg.UserAgent,
+	}
+	p.queue <- dnet.EncodeMessageRaw(node.ChannelNode, node.TagVersion, m.cfg.Key, ver.Encode())
+}
+
+// handshakeMsg processes a message received before the handshake is
+// complete. Returns true once both Version and VerAck are received.
+func (p *Peer) handshakeMsg(msg dnet.Message) (bool, error) {
+	if msg.Chan != node.ChannelNode {
+		return false, ErrHandshake
+	}
+	switch msg.Tag {
+	case node.TagVersion:
+		if p.hs.gotVersion {
+			return false, ErrHandshake
+		}
+		var ver node.VersionMsg
+		if !codec.TryDecode(func() { ver = node.DecodeVersionMsg(msg.Payload) }) || !ver.IsValid() {
+			return false, ErrHandshake
+		}
+		if string(ver.PubKey) != string(msg.PubKey) || ver.Version < 1 {
+			return false, ErrHandshake
+		}
+		if p.mgr.ownNonce(ver.Nonce) {
+			return false, ErrSelfConnect
+		}
+		p.mu.Lock()
+		p.version = ver
+		copy(p.pubKey[:], ver.PubKey)
+		p.features = ver.Features & p.mgr.cfg.Features
+		p.mu.Unlock()
+		p.hs.gotVersion = true
+		ack := node.VerAckMsg{Nonce: ver.Nonce}
+		if !p.TrySend(dnet.EncodeMessageRaw(node.ChannelNode, node.TagVerAck, p.mgr.cfg.Key, ack.Encode())) {
+			return false, ErrHandshake
+		}
+	case node.TagVerAck:
+		if !p.hs.gotVersion || p.hs.gotAck {
+			return false, ErrHandshake
+		}
+		var ack node.VerAckMsg
+		if !codec.TryDecode(func() { ack = node.DecodeVerAckMsg(msg.Payload) }) {
+			return false, ErrHandshake
+		}
+		// signed by the key in their Version, over our nonce
+		if ack.Nonce != p.hs.nonce || string(msg.PubKey) != string(p.pubKey[:]) {
+			return false, ErrHandshake
+		}
+		p.hs.gotAck = true
+	default:
+		return false, ErrHandshake
+	}
+	return p.hs.gotVersion && p.hs.gotAck, nil
+}
+
+// completeHandshake marks the peer ready once the handshake succeeds.
+func (p *Peer) completeHandshake() error {
+	pub := p.PubKey()
+	if bans := p.mgr.cfg.Bans; bans != nil && bans.IsKeyBanned(pub) {
+		return ErrBanned
+	}
+	if p.mgr.connectedToKey(pub, p) {
+		return ErrAlreadyConnected
+	}
+	if !atomic.CompareAndSwapInt32(&p.state, int32(StateHandshake), int32(StateConnected)) {
+		return ErrPeerClosed
+	}
+	p.mu.Lock()
+	p.ready = true
+	p.mu.Unlock()
+	close(p.readyCh)
+	if p.mgr.cfg.OnConnect != nil {
+		p.mgr.cfg.OnConnect(p)
+	}
+	return nil
+}
+
+// PubKey is the peer's node public key (valid once connected.)
+func (p *Peer) PubKey() [32]byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pubKey
+}
+
+// Version is the VersionMsg the peer sent (valid once connected.)
+func (p *Peer) Version() node.VersionMsg {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.version
+}
+
+// Features are the feature bits supported by both sides (valid once connected.)
+func (p *Peer) Features() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.features
+}
+
+// HasFeature reports whether both sides support a feature.
+func (p *Peer) HasFeature(f uint64) bool {
+	return p.Features()&f == f
+}
+
+// Observed is our own address as the peer sees it, from its VersionMsg.
+func (p *Peer) Observed() dnet.Address {
+	return p.Version().Remote
+}
+
+// Ready is closed when the handshake completes.
+func (p *Peer) Ready() <-chan struct{} {
+	return p.readyCh
+}
+
+func (p *Peer) isReady() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ready
+}
+
+func newNonce() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("peer: no entropy: " + err.Error())
+	}
+	return binary.LittleEndian.Uint64(b[:])
+}
@@ -0,0 +1,160 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+)
+
+// rawDial connects to addr without a Manager, as the host at from.
+func rawDial(t *testing.T, n *pipeNet, from string, addr dnet.Address) net.Conn {
+	t.Helper()
+	conn, err := n.dialer(from)(context.Background(), "tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readVersion reads and verifies the VersionMsg a manager sends first.
+func readVersion(t *testing.T, conn net.Conn) (dnet.Message, node.VersionMsg) {
+	t.Helper()
+	msg, err := dnet.ReadMessage(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Chan != node.ChannelNode || msg.Tag != node.TagVersion {
+		t.Fatalf("first message %v %v", msg.Chan, msg.Tag)
+	}
+	return msg, node.DecodeVersionMsg(msg.Payload)
+}
+
+// waitClosed waits for the other side to close conn, discarding messages.
+func waitClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, err := dnet.ReadMessage(conn)
+		if err == nil {
+			continue
+		}
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			t.Fatal("connection not closed")
+		}
+		return
+	}
+}
+
+func TestHandshakeFeatures(t *testing.T) {
+	n := newPipeNet()
+	a := newTestManager(t, n, "10.0.0.1", Config{Features: node.FeatureInventory, UserAgent: "test-a"})
+	b := newTestManager(t, n, "10.0.0.2", Config{})
+	addrA := n.listen(t, a, "10.0.0.1:42069")
+
+	p, err := b.Connect(addrA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Features() != node.FeatureInventory || !p.HasFeature(node.FeatureInventory) || p.HasFeature(node.FeatureAddrV2) {
+		t.Fatalf("negotiated features %b", p.Features())
+	}
+	ver := p.Version()
+	if ver.UserAgent != "test-a" || ver.Version != node.ProtocolVersion || ver.StartTime != a.startTime {
+		t.Fatalf("version %+v", ver)
+	}
+	if p.Observed().String() != "10.0.0.2:1" {
+		t.Fatalf("observed as %v", p.Observed())
+	}
+	select {
+	case <-p.Ready():
+	default:
+		t.Fatal("Ready not closed after Connect")
+	}
+}
+
+func TestHandshakeSelfConnect(t *testing.T) {
+	n := newPipeNet()
+	a := newTestManager(t, n, "10.0.0.1", Config{})
+	// a listener that echoes a's own Version back, as a's listener
+	// would if a dialed itself
+	l := &pipeListener{addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 42069}, conns: make(chan net.Conn), done: make(chan struct{})}
+	n.mu.Lock()
+	n.listeners["10.0.0.5:42069"] = l
+	n.mu.Unlock()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		msg, err := dnet.ReadMessage(conn)
+		if err != nil {
+			return
+		}
+		dnet.RawMessage{Header: msg.RawHdr, Payload: msg.Payload}.Send(conn)
+		dnet.ReadMessage(conn) // until closed
+	}()
+	addr, _ := dnet.ParseAddress("10.0.0.5:42069")
+	if _, err := a.Connect(addr); !errors.Is(err, ErrSelfConnect) {
+		t.Fatalf("got %v, want ErrSelfConnect", err)
+	}
+	waitFor(t, "peer removal", func() bool { return len(a.Peers()) == 0 })
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	n := newPipeNet()
+	a := newTestManager(t, n, "10.0.0.1", Config{HandshakeTimeout: 50 * time.Millisecond})
+	addrA := n.listen(t, a, "10.0.0.1:42069")
+	conn := rawDial(t, n, "10.0.0.9:1", addrA)
+
+	msg, ver := readVersion(t, conn)
+	if !ver.IsValid() || string(ver.PubKey) != string(msg.PubKey) || ver.Remote.String() != "10.0.0.9:1" {
+		t.Fatalf("version %+v", ver)
+	}
+	// never answer
+	waitClosed(t, conn)
+	waitFor(t, "peer removal", func() bool { return len(a.Peers()) == 0 })
+}
+
+func TestHandshakeRejectsOtherMessages(t *testing.T) {
+	n := newPipeNet()
+	a := newTestManager(t, n, "10.0.0.1", Config{})
+	addrA := n.listen(t, a, "10.0.0.1:42069")
+	conn := rawDial(t, n, "10.0.0.9:1", addrA)
+	key, _ := dnet.GenerateKeyPair()
+
+	readVersion(t, conn)
+	if err := dnet.EncodeMessageRaw(testChan, testTag, key, []byte("early")).Send(conn); err != nil {
+		t.Fatal(err)
+	}
+	waitClosed(t, conn)
+	waitFor(t, "peer removal", func() bool { return len(a.Peers()) == 0 })
+}
+
+func TestHandshakeWrongVerAck(t *testing.T) {
+	n := newPipeNet()
+	a := newTestManager(t, n, "10.0.0.1", Config{})
+	addrA := n.listen(t, a, "10.0.0.1:42069")
+	conn := rawDial(t, n, "10.0.0.9:1", addrA)
+	key, _ := dnet.GenerateKeyPair()
+
+	_, theirs := readVersion(t, conn)
+	ver := node.VersionMsg{Version: node.ProtocolVersion, PubKey: key.Pub[:], Nonce: 7, UserAgent: "raw"}
+	if err := dnet.EncodeMessageRaw(node.ChannelNode, node.TagVersion, key, ver.Encode()).Send(conn); err != nil {
+		t.Fatal(err)
+	}
+	// acknowledge with the wrong nonce
+	ack := node.VerAckMsg{Nonce: theirs.Nonce + 1}
+	if err := dnet.EncodeMessageRaw(node.ChannelNode, node.TagVerAck, key, ack.Encode()).Send(conn); err != nil {
+		t.Fatal(err)
+	}
+	waitClosed(t, conn)
+	waitFor(t, "peer removal", func() bool { return len(a.Peers()) == 0 })
+}
@@ -0,0 +1,450 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.dogecoin.org/gossip/ban"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+)
+
+// Manager maintains inbound and outbound peer connections.
+type Manager struct {
+	cfg       Config
+	startTime dnet.DogeTime
+	mu        sync.Mutex
+	peers     map[uint64]*Peer
+	inbound   int
+	outbound  int
+	dialing   map[string]bool
+	nextID    uint64
+	listener  net.Listener
+	closed    bool
+	wg        sync.WaitGroup
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+type Config struct {
+	ListenAddr   string        // address to listen on (default ":42069")
+	MaxInbound   int           // inbound connection slots (default 32)
+	MaxOutbound  int           // outbound connection slots (default 8)
+	SendQueue    int           // messages queued per peer (default 256)
+	DialTimeout  time.Duration // outbound connect timeout (default 10s)
+	WriteTimeout time.Duration // a peer must accept a write within this time (default 30s)
+	Dial         DialFunc      // optional; defaults to net.Dialer
+	Bans         *ban.Manager  // optional; refuse banned peers and score read errors
+
+	// Handshake
+	Key              dnet.KeyPair  // node key (default: a new ephemeral key)
+	Features         uint64        // feature bits we support (default node.FeatureInventory | node.FeatureAddrV2)
+	UserAgent        string        // sent in VersionMsg (default "gossip")
+	HandshakeTimeout time.Duration // peers must complete the handshake within this time (default 10s)
+
+	// Keepalive
+	PingInterval time.Duration // time between pings (default 1m)
+	PingTimeout  time.Duration // peers must answer a ping within this time (default 30s)
+
+	// Callbacks run on the peer's goroutines and must not block for long.
+	// OnConnect is called once the handshake completes; OnDisconnect only
+	// for peers that reached OnConnect. OnMessage runs on the reader
+	// goroutine; blocking it stops reading.
+	OnConnect    func(p *Peer)
+	OnMessage    func(p *Peer, msg dnet.Message)
+	OnDisconnect func(p *Peer, err error)
+	OnSend       func(p *Peer, msg dnet.RawMessage) // optional; after each message is written
+	OnReadError  func(p *Peer, err error)           // optional; the error that stopped reading
+}
+
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+var ErrManagerClosed = errors.New("peer manager closed")
+var ErrNoSlots = errors.New("no free connection slots")
+var ErrAlreadyConnected = errors.New("already connected to address")
+var ErrBanned = errors.New("peer is banned")
+
+func NewManager(cfg Config) *Manager {
+	if cfg.ListenAddr == "" {
+		cfg.ListenAddr = ":" + strconv.Itoa(int(dnet.DogeNetDefaultPort))
+	}
+	if cfg.MaxInbound <= 0 {
+		cfg.MaxInbound = 32
+	}
+	if cfg.MaxOutbound <= 0 {
+		cfg.MaxOutbound = 8
+	}
+	if cfg.SendQueue <= 0 {
+		cfg.SendQueue = 256
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = 10 * time.Second
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = 30 * time.Second
+	}
+	if cfg.Dial == nil {
+		d := &net.Dialer{}
+		cfg.Dial = d.DialContext
+	}
+	if cfg.Key.Priv == nil {
+		key, err := dnet.GenerateKeyPair()
+		if err != nil || key.Priv == nil {
+			panic("peer: cannot generate node key")
+		}
+		cfg.Key = key
+	}
+	if cfg.Features == 0 {
+		cfg.Features = node.FeatureInventory | node.FeatureAddrV2
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = "gossip"
+	}
+	if cfg.HandshakeTimeout <= 0 {
+		cfg.HandshakeTimeout = 10 * time.Second
+	}
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = time.Minute
+	}
+	if cfg.PingTimeout <= 0 {
+		cfg.PingTimeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		cfg:       cfg,
+		startTime: dnet.DogeNow(),
+		peers:     make(map[uint64]*Peer),
+		dialing:   make(map[string]bool),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	if cfg.Bans != nil {
+		cfg.Bans.Notify(m.onBan)
+	}
+	return m
+}
+
+// onBan disconnects peers matching a new ban.
+func (m *Manager) onBan(b ban.Ban) {
+	for _, p := range m.Peers() {
+		if b.IsKey() {
+			if p.isReady() && p.PubKey() == b.PubKey {
+				p.Disconnect(ErrBanned)
+			}
+		} else if p.addr.Host().IsValid() && p.addr.IP().String() == b.Host {
+			p.Disconnect(ErrBanned)
+		}
+	}
+}
+
+// Listen accepts inbound peers on Config.ListenAddr.
+func (m *Manager) Listen() error {
+	l, err := net.Listen("tcp", m.cfg.ListenAddr)
+	if err != nil {
+		return err
+	}
+	return m.Serve(l)
+}
+
+// Serve accepts inbound peers from l in the background.
+func (m *Manager) Serve(l net.Listener) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		l.Close()
+		return ErrManagerClosed
+	}
+	if m.listener != nil {
+		m.mu.Unlock()
+		l.Close()
+		return errors.New("peer manager is already listening")
+	}
+	m.listener = l
+	m.wg.Add(1)
+	m.mu.Unlock()
+	go m.acceptLoop(l)
+	return nil
+}
+
+// ListenAddr returns the address being listened on, once listening.
+func (m *Manager) ListenAddr() net.Addr {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.listener == nil {
+		return nil
+	}
+	return m.listener.Addr()
+}
+
+func (m *Manager) acceptLoop(l net.Listener) {
+	defer m.wg.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return
+		}
+		addr, _ := dnet.ParseAddress(conn.RemoteAddr().String())
+		if m.cfg.Bans != nil && m.cfg.Bans.IsBanned(addr.IP()) {
+			conn.Close()
+			continue
+		}
+		if _, err := m.start(conn, addr.String(), addr, true); err != nil {
+			conn.Close()
+		}
+	}
+}
+
+// Connect dials an outbound peer and waits for the handshake to complete.
+func (m *Manager) Connect(addr dnet.Address) (*Peer, error) {
+	return m.ConnectContext(m.ctx, addr)
+}
+
+// ConnectContext dials an outbound peer and waits for the handshake to complete.
+func (m *Manager) ConnectContext(ctx context.Context, addr dnet.Address) (*Peer, error) {
+	return m.connect(ctx, addr.String(), addr)
+}
+
+// ConnectHost dials an outbound peer by "host:port", where host is an IP
+// address or a name such as a Tor ".onion" or I2P ".b32.i2p" address,
+// and waits for the handshake to complete. Names are passed to
+// Config.Dial unresolved, so a proxy dialer can reach overlay networks.
+func (m *Manager) ConnectHost(ctx context.Context, hostport string) (*Peer, error) {
+	if addr, err := dnet.ParseAddress(hostport); err == nil {
+		return m.ConnectContext(ctx, addr)
+	}
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		return nil, err
+	}
+	return m.connect(ctx, hostport, dnet.Address{})
+}
+
+// connect dials key, the "host:port" of addr or of a named host
+// (with the zero addr.)
+func (m *Manager) connect(ctx context.Context, key string, addr dnet.Address) (*Peer, error) {
+	if m.cfg.Bans != nil && m.cfg.Bans.IsBanned(addr.IP()) {
+		return nil, ErrBanned
+	}
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, ErrManagerClosed
+	}
+	if m.outbound+len(m.dialing) >= m.cfg.MaxOutbound {
+		m.mu.Unlock()
+		return nil, ErrNoSlots
+	}
+	if m.dialing[key] || m.connectedTo(key) {
+		m.mu.Unlock()
+		return nil, ErrAlreadyConnected
+	}
+	m.dialing[key] = true
+	m.mu.Unlock()
+	dctx, cancel := context.WithTimeout(ctx, m.cfg.DialTimeout)
+	defer cancel()
+	go func() {
+		// abort the dial on shutdown
+		select {
+		case <-m.ctx.Done():
+			cancel()
+		case <-dctx.Done():
+		}
+	}()
+	conn, err := m.cfg.Dial(dctx, "tcp", key)
+	if err != nil {
+		m.mu.Lock()
+		delete(m.dialing, key)
+		m.mu.Unlock()
+		return nil, fmt.Errorf("connect %v: %w", key, err)
+	}
+	p, err := m.start(conn, key, addr, false)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	select {
+	case <-p.readyCh:
+		return p, nil
+	case <-p.done:
+		err := p.Err()
+		if err == nil {
+			err = ErrPeerClosed
+		}
+		return nil, fmt.Errorf("connect %v: %w", key, err)
+	case <-ctx.Done():
+		p.Disconnect(ctx.Err())
+		return nil, ctx.Err()
+	}
+}
+
+// connectedToKey reports whether another connected peer has this node key.
+func (m *Manager) connectedToKey(pub [32]byte, except *Peer) bool {
+	for _, p := range m.Peers() {
+		if p != except && p.isReady() && p.PubKey() == pub {
+			return true
+		}
+	}
+	return false
+}
+
+// ownNonce reports whether a Version nonce is one we sent (a connection to ourselves.)
+func (m *Manager) ownNonce(nonce uint64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, p := range m.peers {
+		if p.hs.nonce == nonce {
+			return true
+		}
+	}
+	return false
+}
+
+// connectedTo reports whether we have an outbound connection to host; m.mu must be held.
+func (m *Manager) connectedTo(host string) bool {
+	for _, p := range m.peers {
+		if !p.inbound && p.host == host {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) start(conn net.Conn, host string, addr dnet.Address, inbound bool) (*Peer, error) {
+	p := newPeer(m, conn, host, addr, inbound)
+	m.mu.Lock()
+	if !inbound {
+		// the dial's slot becomes the peer's (or is released)
+		delete(m.dialing, host)
+	}
+	if m.closed {
+		m.mu.Unlock()
+		return nil, ErrManagerClosed
+	}
+	if inbound {
+		if m.inbound >= m.cfg.MaxInbound {
+			m.mu.Unlock()
+			return nil, ErrNoSlots
+		}
+		m.inbound++
+	} else {
+		m.outbound++
+	}
+	m.nextID++
+	p.id = m.nextID
+	m.peers[p.id] = p
+	m.wg.Add(1)
+	m.mu.Unlock()
+	atomic.StoreInt32(&p.state, int32(StateHandshake))
+	p.sendVersion()
+	timer := time.AfterFunc(m.cfg.HandshakeTimeout, func() {
+		if !p.isReady() {
+			p.Disconnect(ErrHandshakeTimeout)
+		}
+	})
+	go p.writeLoop()
+	go p.pingLoop()
+	go func() {
+		defer m.wg.Done()
+		p.readLoop()
+		<-p.done
+		timer.Stop()
+		m.remove(p)
+		if m.cfg.OnDisconnect != nil && p.isReady() {
+			m.cfg.OnDisconnect(p, p.Err())
+		}
+	}()
+	return p, nil
+}
+
+func (m *Manager) remove(p *Peer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.peers[p.id]; !ok {
+		return
+	}
+	delete(m.peers, p.id)
+	if p.inbound {
+		m.inbound--
+	} else {
+		m.outbound--
+	}
+}
+
+// Peers returns all current peers.
+func (m *Manager) Peers() []*Peer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	peers := make([]*Peer, 0, len(m.peers))
+	for _, p := range m.peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// Peer returns the peer with the given ID, or nil.
+func (m *Manager) Peer(id uint64) *Peer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.peers[id]
+}
+
+// Counts returns the number of inbound and outbound peers.
+func (m *Manager) Counts() (inbound int, outbound int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.inbound, m.outbound
+}
+
+// FreeOutbound returns the number of unused outbound slots.
+func (m *Manager) FreeOutbound() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cfg.MaxOutbound - m.outbound - len(m.dialing)
+}
+
+// Close stops listening and gracefully disconnects all peers,
+// waiting up to the context deadline for queued messages to be sent.
+func (m *Manager) Close(ctx context.Context) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	if m.listener != nil {
+		m.listener.Close()
+	}
+	peers := make([]*Peer, 0, len(m.peers))
+	for _, p := range m.peers {
+		peers = append(peers, p)
+	}
+	m.mu.Unlock()
+	m.cancel()
+	for _, p := range peers {
+		p.Close()
+	}
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		for _, p := range peers {
+			p.Disconnect(ctx.Err())
+		}
+		<-done
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,347 @@
+package peer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+)
+
+var testChan = dnet.NewTag("Test")
+var testTag = dnet.NewTag("Tst1")
+
+// pipeNet connects managers with net.Pipe instead of TCP.
+type pipeNet struct {
+	mu        sync.Mutex
+	listeners map[string]*pipeListener
+}
+
+type pipeListener struct {
+	addr  *net.TCPAddr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// addrConn reports TCP addresses, so peers see a real remote address.
+type addrConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c addrConn) LocalAddr() net.Addr  { return c.local }
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return l.addr
+}
+
+func newPipeNet() *pipeNet {
+	return &pipeNet{listeners: make(map[string]*pipeListener)}
+}
+
+// listen starts m accepting connections to addr.
+func (n *pipeNet) listen(t *testing.T, m *Manager, addr string) dnet.Address {
+	t.Helper()
+	tcp, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.serve(t, m, addr, tcp)
+	a, _ := dnet.ParseAddress(addr)
+	return a
+}
+
+// serve starts m accepting connections dialed to name, which can be
+// any "host:port"; the listener reports the address local.
+func (n *pipeNet) serve(t *testing.T, m *Manager, name string, local *net.TCPAddr) {
+	t.Helper()
+	l := &pipeListener{addr: local, conns: make(chan net.Conn), done: make(chan struct{})}
+	n.mu.Lock()
+	n.listeners[name] = l
+	n.mu.Unlock()
+	if err := m.Serve(l); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// dialer returns a DialFunc for connections from the host at from.
+func (n *pipeNet) dialer(from string) DialFunc {
+	local, _ := net.ResolveTCPAddr("tcp", from)
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		n.mu.Lock()
+		l := n.listeners[address]
+		n.mu.Unlock()
+		if l == nil {
+			return nil, errors.New("connection refused")
+		}
+		a, b := net.Pipe()
+		select {
+		case l.conns <- addrConn{Conn: b, local: l.addr, remote: local}:
+			return addrConn{Conn: a, local: local, remote: l.addr}, nil
+		case <-l.done:
+			return nil, errors.New("connection refused")
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func newTestManager(t *testing.T, n *pipeNet, host string, cfg Config) *Manager {
+	t.Helper()
+	cfg.Dial = n.dialer(host + ":1")
+	m := NewManager(cfg)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		m.Close(ctx)
+	})
+	return m
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConnectAndExchange(t *testing.T) {
+	n := newPipeNet()
+	received := make(chan dnet.Message, 1)
+	connected := make(chan *Peer, 1)
+	a := newTestManager(t, n, "10.0.0.1", Config{
+		OnConnect: func(p *Peer) { connected <- p },
+		OnMessage: func(p *Peer, msg dnet.Message) { received <- msg },
+	})
+	b := newTestManager(t, n, "10.0.0.2", Config{})
+	addrA := n.listen(t, a, "10.0.0.1:42069")
+
+	p, err := b.Connect(addrA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.State() != StateConnected || p.Inbound() {
+		t.Fatalf("outbound peer: state %v inbound %v", p.State(), p.Inbound())
+	}
+	if p.PubKey() != *a.cfg.Key.Pub {
+		t.Fatal("outbound peer has the wrong node key")
+	}
+	in := <-connected
+	if !in.Inbound() || in.PubKey() != *b.cfg.Key.Pub || in.Addr().String() != "10.0.0.2:1" {
+		t.Fatalf("inbound peer: %v %v", in.Inbound(), in.Addr())
+	}
+
+	key, _ := dnet.GenerateKeyPair()
+	if err := p.Send(context.Background(), dnet.EncodeMessageRaw(testChan, testTag, key, []byte("hi"))); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if msg.Chan != testChan || string(msg.Payload) != "hi" {
+			t.Fatalf("got %v %q", msg.Chan, msg.Payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+	if in, out := a.Counts(); in != 1 || out != 0 {
+		t.Fatalf("a counts: %d in %d out", in, out)
+	}
+}
+
+func TestConnectSlots(t *testing.T) {
+	n := newPipeNet()
+	a := newTestManager(t, n, "10.0.0.1", Config{})
+	c := newTestManager(t, n, "10.0.0.3", Config{})
+	b := newTestManager(t, n, "10.0.0.2", Config{MaxOutbound: 1})
+	addrA := n.listen(t, a, "10.0.0.1:42069")
+	addrC := n.listen(t, c, "10.0.0.3:42069")
+
+	if _, err := b.Connect(addrA); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Connect(addrC); !errors.Is(err, ErrNoSlots) {
+		t.Fatalf("second outbound: got %v, want ErrNoSlots", err)
+	}
+	if b.FreeOutbound() != 0 {
+		t.Fatalf("FreeOutbound: %d", b.FreeOutbound())
+	}
+}
+
+func TestConnectSlotAccounting(t *testing.T) {
+	n := newPipeNet()
+	a := newTestManager(t, n, "10.0.0.1", Config{})
+	b := newTestManager(t, n, "10.0.0.2", Config{MaxOutbound: 2})
+	addrA := n.listen(t, a, "10.0.0.1:42069")
+	// a listener that accepts but never answers the handshake
+	l := &pipeListener{addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 42069}, conns: make(chan net.Conn), done: make(chan struct{})}
+	n.mu.Lock()
+	n.listeners["10.0.0.5:42069"] = l
+	n.mu.Unlock()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, err := dnet.ReadMessage(conn); err != nil {
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	failed := make(chan error, 1)
+	go func() {
+		silent, _ := dnet.ParseAddress("10.0.0.5:42069")
+		_, err := b.ConnectContext(ctx, silent)
+		failed <- err
+	}()
+	// handshaking, the peer holds one slot, not two
+	waitFor(t, "outbound peer", func() bool { _, out := b.Counts(); return out == 1 })
+	if b.FreeOutbound() != 1 {
+		t.Fatalf("FreeOutbound while handshaking: %d", b.FreeOutbound())
+	}
+	if _, err := b.Connect(addrA); err != nil {
+		t.Fatalf("second outbound: %v", err)
+	}
+	if b.FreeOutbound() != 0 {
+		t.Fatalf("FreeOutbound: %d", b.FreeOutbound())
+	}
+	cancel()
+	if err := <-failed; !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled connect: %v", err)
+	}
+	waitFor(t, "slot release", func() bool { return b.FreeOutbound() == 1 })
+}
+
+func TestConnectTwice(t *testing.T) {
+	n := newPipeNet()
+	a := newTestManager(t, n, "10.0.0.1", Config{})
+	b := newTestManager(t, n, "10.0.0.2", Config{})
+	addrA := n.listen(t, a, "10.0.0.1:42069")
+	if _, err := b.Connect(addrA); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Connect(addrA); !errors.Is(err, ErrAlreadyConnected) {
+		t.Fatalf("got %v, want ErrAlreadyConnected", err)
+	}
+}
+
+func TestCloseFlushesQueue(t *testing.T) {
+	n := newPipeNet()
+	var mu sync.Mutex
+	count := 0
+	a := newTestManager(t, n, "10.0.0.1", Config{
+		OnMessage: func(p *Peer, msg dnet.Message) {
+			mu.Lock()
+			count++
+			mu.Unlock()
+		},
+	})
+	b := newTestManager(t, n, "10.0.0.2", Config{})
+	addrA := n.listen(t, a, "10.0.0.1:42069")
+	p, err := b.Connect(addrA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := dnet.GenerateKeyPair()
+	for i := 0; i < 10; i++ {
+		if !p.TrySend(dnet.EncodeMessageRaw(testChan, testTag, key, []byte{byte(i)})) {
+			t.Fatal("queue full")
+		}
+	}
+	p.Close()
+	<-p.Done()
+	if p.Err() != nil {
+		t.Fatalf("graceful close: %v", p.Err())
+	}
+	waitFor(t, "queued messages", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return count == 10
+	})
+	if p.TrySend(dnet.EncodeMessageRaw(testChan, testTag, key, nil)) {
+		t.Fatal("TrySend succeeded after Close")
+	}
+}
+
+func TestDisconnectCallback(t *testing.T) {
+	n := newPipeNet()
+	connected := make(chan struct{}, 1)
+	gone := make(chan error, 1)
+	a := newTestManager(t, n, "10.0.0.1", Config{
+		OnConnect:    func(p *Peer) { connected <- struct{}{} },
+		OnDisconnect: func(p *Peer, err error) { gone <- err },
+	})
+	b := newTestManager(t, n, "10.0.0.2", Config{})
+	addrA := n.listen(t, a, "10.0.0.1:42069")
+	p, err := b.Connect(addrA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// OnDisconnect is only called for peers that reached OnConnect
+	<-connected
+	p.Disconnect(nil)
+	select {
+	case <-gone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnDisconnect not called")
+	}
+	waitFor(t, "peer removal", func() bool { return len(a.Peers()) == 0 })
+}
+
+func TestConnectHost(t *testing.T) {
+	n := newPipeNet()
+	a := newTestManager(t, n, "10.0.0.1", Config{})
+	b := newTestManager(t, n, "10.0.0.2", Config{})
+	onion := node.NetAddr{Network: node.NetTorV3, Addr: bytes.Repeat([]byte{7}, 32)}
+	host := net.JoinHostPort(onion.String(), "42069")
+	n.serve(t, a, host, &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 42069})
+
+	p, err := b.ConnectHost(context.Background(), host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.HostPort() != host || p.Addr().IsValid() || p.PubKey() != *a.cfg.Key.Pub {
+		t.Fatalf("peer %v %v", p.HostPort(), p.Addr())
+	}
+	if _, err := b.ConnectHost(context.Background(), host); !errors.Is(err, ErrAlreadyConnected) {
+		t.Fatalf("second connect: %v", err)
+	}
+	if _, err := b.ConnectHost(context.Background(), "no-port"); err == nil {
+		t.Fatal("connected without a port")
+	}
+
+	// IP addresses are parsed, as by Connect
+	addrA := n.listen(t, newTestManager(t, n, "10.0.0.3", Config{}), "10.0.0.3:42069")
+	p, err = b.ConnectHost(context.Background(), "10.0.0.3:42069")
+	if err != nil || !p.Addr().Equal(addrA) {
+		t.Fatalf("connect by IP: %v", err)
+	}
+}
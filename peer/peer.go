@@ -0,0 +1,308 @@
+package peer
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"code.dogecoin.org/gossip/ban"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+)
+
+type State int32
+
+const (
+	StateConnecting State = iota // dialing or accepted, not yet running
+	StateHandshake               // exchanging Version and VerAck
+	StateConnected               // handshake complete; messages are passed on
+	StateClosing                 // flushing the send queue before disconnecting
+	StateClosed                  // disconnected
+)
+
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateHandshake:
+		return "handshake"
+	case StateConnected:
+		return "connected"
+	case StateClosing:
+		return "closing"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+var ErrPeerClosed = errors.New("peer connection closed")
+
+// Peer is a connection to another DogeNet node.
+//
+// Each peer has a reader goroutine that decodes and verifies messages
+// using dnet.ReadMessage, and a writer goroutine that sends messages
+// from a bounded queue using RawMessage.Send.
+//
+// Both sides start by sending a signed node.VersionMsg and answering
+// the other's with a node.VerAckMsg; no other messages are accepted
+// until this handshake completes.
+type Peer struct {
+	id      uint64
+	addr    dnet.Address
+	host    string // "host:port" dialed, or addr for inbound peers
+	inbound bool
+	conn    net.Conn
+	mgr     *Manager
+	queue   chan dnet.RawMessage
+	state   int32
+	closing chan struct{} // closed to start a graceful disconnect
+	done    chan struct{} // closed once the connection is closed
+	once    sync.Once
+	mu      sync.Mutex
+	err     error
+	since   time.Time
+	hs      handshake
+	readyCh chan struct{} // closed when the handshake completes
+	// set by the handshake, protected by mu
+	ready    bool
+	pubKey   [32]byte
+	version  node.VersionMsg
+	features uint64
+	ping     ping
+	pong     chan struct{} // signalled when the outstanding ping is answered
+}
+
+func newPeer(m *Manager, conn net.Conn, host string, addr dnet.Address, inbound bool) *Peer {
+	return &Peer{
+		addr:    addr,
+		host:    host,
+		inbound: inbound,
+		conn:    conn,
+		mgr:     m,
+		queue:   make(chan dnet.RawMessage, m.cfg.SendQueue),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+		readyCh: make(chan struct{}),
+		pong:    make(chan struct{}, 1),
+		since:   time.Now(),
+		hs:      handshake{nonce: newNonce()},
+	}
+}
+
+func (p *Peer) ID() uint64 {
+	return p.id
+}
+
+// Addr is the remote address of the connection
+// (the zero Address for a peer dialed by name, such as an overlay address.)
+func (p *Peer) Addr() dnet.Address {
+	return p.addr
+}
+
+// HostPort is the address that was dialed ("host:port"), or the remote
+// address for inbound peers.
+func (p *Peer) HostPort() string {
+	return p.host
+}
+
+func (p *Peer) Inbound() bool {
+	return p.inbound
+}
+
+func (p *Peer) State() State {
+	return State(atomic.LoadInt32(&p.state))
+}
+
+// ConnectedSince is the time the connection was established.
+func (p *Peer) ConnectedSince() time.Time {
+	return p.since
+}
+
+// QueueLen is the number of messages waiting to be sent.
+func (p *Peer) QueueLen() int {
+	return len(p.queue)
+}
+
+// Err returns the reason the peer disconnected, if any.
+func (p *Peer) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Done is closed when the peer has disconnected.
+func (p *Peer) Done() <-chan struct{} {
+	return p.done
+}
+
+// Send queues a message, waiting for queue space if the queue is full.
+// This applies backpressure to the caller when the peer is slow.
+func (p *Peer) Send(ctx context.Context, msg dnet.RawMessage) error {
+	select {
+	case <-p.closing:
+		return ErrPeerClosed
+	default:
+	}
+	select {
+	case p.queue <- msg:
+		return nil
+	case <-p.closing:
+		return ErrPeerClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TrySend queues a message without waiting.
+// Returns false if the queue is full or the peer is closing.
+func (p *Peer) TrySend(msg dnet.RawMessage) bool {
+	select {
+	case <-p.closing:
+		return false
+	default:
+	}
+	select {
+	case p.queue <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// Misbehaving scores misbehavior by this peer (and by the signing key,
+// if pub is not nil), disconnecting the peer if it is now banned.
+// Does nothing if the manager has no ban manager.
+func (p *Peer) Misbehaving(pub *[32]byte, reason ban.Reason) {
+	bans := p.mgr.cfg.Bans
+	if bans == nil {
+		return
+	}
+	if bans.Misbehaving(p.addr.IP(), pub, reason) {
+		p.Disconnect(ErrBanned)
+	}
+}
+
+// Close disconnects gracefully, sending queued messages first.
+func (p *Peer) Close() {
+	p.shutdown(nil)
+}
+
+// Disconnect closes the connection immediately, discarding queued messages.
+func (p *Peer) Disconnect(err error) {
+	p.shutdown(err)
+	p.closeConn(err)
+}
+
+func (p *Peer) shutdown(err error) {
+	p.setErr(err)
+	if atomic.CompareAndSwapInt32(&p.state, int32(StateConnected), int32(StateClosing)) ||
+		atomic.CompareAndSwapInt32(&p.state, int32(StateHandshake), int32(StateClosing)) ||
+		atomic.CompareAndSwapInt32(&p.state, int32(StateConnecting), int32(StateClosing)) {
+		close(p.closing)
+	}
+}
+
+// setErr records the first reason for disconnecting.
+func (p *Peer) setErr(err error) {
+	p.mu.Lock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.mu.Unlock()
+}
+
+func (p *Peer) closeConn(err error) {
+	p.once.Do(func() {
+		p.shutdown(err)
+		atomic.StoreInt32(&p.state, int32(StateClosed))
+		p.conn.Close()
+		close(p.done)
+	})
+}
+
+func (p *Peer) readLoop() {
+	ready := false
+	for {
+		msg, err := dnet.ReadMessage(p.conn)
+		if err != nil {
+			if p.mgr.cfg.OnReadError != nil {
+				p.mgr.cfg.OnReadError(p, err)
+			}
+			if bans := p.mgr.cfg.Bans; bans != nil {
+				bans.MisbehavingError(p.addr.IP(), err)
+			}
+			p.closeConn(err)
+			return
+		}
+		if bans := p.mgr.cfg.Bans; bans != nil && bans.IsKeyBanned(*(*[32]byte)(msg.PubKey)) {
+			continue // drop messages signed by banned keys
+		}
+		if !ready {
+			done, err := p.handshakeMsg(msg)
+			if err == nil && done {
+				err = p.completeHandshake()
+				ready = err == nil
+			}
+			if err != nil {
+				p.Disconnect(err)
+				return
+			}
+			continue
+		}
+		if p.pingMsg(msg) {
+			continue
+		}
+		if p.mgr.cfg.OnMessage != nil {
+			// runs on the reader goroutine: a slow consumer stops
+			// us reading from the socket (TCP backpressure.)
+			p.mgr.cfg.OnMessage(p, msg)
+		}
+	}
+}
+
+func (p *Peer) writeLoop() {
+	for {
+		select {
+		case msg := <-p.queue:
+			if err := p.write(msg); err != nil {
+				p.closeConn(err)
+				return
+			}
+		case <-p.closing:
+			p.flush()
+			p.closeConn(nil)
+			return
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// flush sends any messages still queued when closing.
+func (p *Peer) flush() {
+	for {
+		select {
+		case msg := <-p.queue:
+			if p.write(msg) != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *Peer) write(msg dnet.RawMessage) error {
+	p.conn.SetWriteDeadline(time.Now().Add(p.mgr.cfg.WriteTimeout))
+	if err := msg.Send(p.conn); err != nil {
+		return err
+	}
+	if p.mgr.cfg.OnSend != nil {
+		p.mgr.cfg.OnSend(p, msg)
+	}
+	return nil
+}
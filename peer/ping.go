@@ -0,0 +1,110 @@
+package peer
+
+import (
+	"errors"
+	"time"
+
+	"code.dogecoin.org/gossip/ban"
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+)
+
+var ErrPingTimeout = errors.New("peer did not answer ping")
+
+// ping state, protected by Peer.mu
+type ping struct {
+	nonce uint64 // outstanding ping nonce (0 if none)
+	sent  time.Time
+	rtt   time.Duration // smoothed round-trip time
+	last  time.Duration // most recent sample
+}
+
+// RTT is the smoothed round-trip time measured by ping/pong,
+// or zero if no pong has been received yet.
+func (p *Peer) RTT() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ping.rtt
+}
+
+// LastRTT is the most recent round-trip time sample, or zero.
+func (p *Peer) LastRTT() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ping.last
+}
+
+// pingLoop sends a ping every PingInterval once the handshake completes,
+// disconnecting the peer if a pong does not arrive within PingTimeout.
+func (p *Peer) pingLoop() {
+	select {
+	case <-p.readyCh:
+	case <-p.closing:
+		return
+	}
+	cfg := p.mgr.cfg
+	for {
+		nonce := newNonce() | 1 // never zero
+		p.mu.Lock()
+		p.ping.nonce = nonce
+		p.ping.sent = time.Now()
+		p.mu.Unlock()
+		// a full queue delays the pong, which the timeout catches
+		p.TrySend(dnet.EncodeMessageRaw(node.ChannelNode, node.TagPing, cfg.Key, node.PingMsg{Nonce: nonce}.Encode()))
+		timeout := time.NewTimer(cfg.PingTimeout)
+		select {
+		case <-p.pong:
+			timeout.Stop()
+		case <-timeout.C:
+			p.Disconnect(ErrPingTimeout)
+			return
+		case <-p.closing:
+			timeout.Stop()
+			return
+		}
+		wait := time.NewTimer(cfg.PingInterval)
+		select {
+		case <-wait.C:
+		case <-p.closing:
+			wait.Stop()
+			return
+		}
+	}
+}
+
+// pingMsg handles Ping and Pong; returns false for other messages.
+func (p *Peer) pingMsg(msg dnet.Message) bool {
+	if msg.Chan != node.ChannelNode || (msg.Tag != node.TagPing && msg.Tag != node.TagPong) {
+		return false
+	}
+	var ping node.PingMsg
+	if !codec.TryDecode(func() { ping = node.DecodePingMsg(msg.Payload) }) {
+		p.Misbehaving(nil, ban.ReasonDecode)
+		return true
+	}
+	if msg.Tag == node.TagPing {
+		pong := node.PingMsg{Nonce: ping.Nonce}
+		p.TrySend(dnet.EncodeMessageRaw(node.ChannelNode, node.TagPong, p.mgr.cfg.Key, pong.Encode()))
+		return true
+	}
+	p.mu.Lock()
+	if ping.Nonce == 0 || ping.Nonce != p.ping.nonce {
+		p.mu.Unlock()
+		return true // unsolicited or late pong
+	}
+	sample := time.Since(p.ping.sent)
+	p.ping.nonce = 0
+	p.ping.last = sample
+	if p.ping.rtt == 0 {
+		p.ping.rtt = sample
+	} else {
+		p.ping.rtt += (sample - p.ping.rtt) / 8 // as TCP SRTT
+	}
+	p.mu.Unlock()
+	select {
+	case p.pong <- struct{}{}:
+	default:
+	}
+	return true
+}
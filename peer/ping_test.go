@@ -0,0 +1,111 @@
+package peer
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/internal/testutil"
+	"code.dogecoin.org/gossip/node"
+)
+
+// rawHandshake completes the handshake with the manager on conn,
+// as a node with key.
+func rawHandshake(t *testing.T, conn net.Conn, key dnet.KeyPair) {
+	t.Helper()
+	_, theirs := readVersion(t, conn)
+	ver := node.VersionMsg{Version: node.ProtocolVersion, PubKey: key.Pub[:], Nonce: 7, UserAgent: "raw"}
+	if err := dnet.EncodeMessageRaw(node.ChannelNode, node.TagVersion, key, ver.Encode()).Send(conn); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := dnet.ReadMessage(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Tag != node.TagVerAck || node.DecodeVerAckMsg(msg.Payload).Nonce != 7 {
+		t.Fatalf("expected VerAck, got %v", msg.Tag)
+	}
+	ack := node.VerAckMsg{Nonce: theirs.Nonce}
+	if err := dnet.EncodeMessageRaw(node.ChannelNode, node.TagVerAck, key, ack.Encode()).Send(conn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readTag reads messages until one with tag arrives.
+func readTag(t *testing.T, conn net.Conn, tag dnet.Tag4CC) dnet.Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	for {
+		msg, err := dnet.ReadMessage(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Chan == node.ChannelNode && msg.Tag == tag {
+			return msg
+		}
+	}
+}
+
+func TestPingRTT(t *testing.T) {
+	n := newPipeNet()
+	a := newTestManager(t, n, "10.0.0.1", Config{})
+	b := newTestManager(t, n, "10.0.0.2", Config{PingInterval: 10 * time.Millisecond})
+	addrA := n.listen(t, a, "10.0.0.1:42069")
+	p, err := b.Connect(addrA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "RTT samples", func() bool { return p.RTT() > 0 && p.LastRTT() > 0 })
+	if p.RTT() > 5*time.Second {
+		t.Fatalf("RTT %v", p.RTT())
+	}
+}
+
+func TestPingAnswered(t *testing.T) {
+	n := newPipeNet()
+	a := newTestManager(t, n, "10.0.0.1", Config{})
+	addrA := n.listen(t, a, "10.0.0.1:42069")
+	conn := rawDial(t, n, "10.0.0.9:1", addrA)
+	key := testutil.NewKey(t)
+	rawHandshake(t, conn, key)
+
+	ping := node.PingMsg{Nonce: 0x1234}
+	if err := dnet.EncodeMessageRaw(node.ChannelNode, node.TagPing, key, ping.Encode()).Send(conn); err != nil {
+		t.Fatal(err)
+	}
+	pong := readTag(t, conn, node.TagPong)
+	if node.DecodePingMsg(pong.Payload).Nonce != 0x1234 {
+		t.Fatal("pong does not echo the nonce")
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+	n := newPipeNet()
+	gone := make(chan error, 1)
+	a := newTestManager(t, n, "10.0.0.1", Config{
+		PingTimeout:  50 * time.Millisecond,
+		OnDisconnect: func(p *Peer, err error) { gone <- err },
+	})
+	addrA := n.listen(t, a, "10.0.0.1:42069")
+	conn := rawDial(t, n, "10.0.0.9:1", addrA)
+	key := testutil.NewKey(t)
+	rawHandshake(t, conn, key)
+
+	ping := readTag(t, conn, node.TagPing)
+	// an unsolicited pong is ignored, and does not answer the ping
+	wrong := node.PingMsg{Nonce: node.DecodePingMsg(ping.Payload).Nonce + 2}
+	if err := dnet.EncodeMessageRaw(node.ChannelNode, node.TagPong, key, wrong.Encode()).Send(conn); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-gone:
+		if !errors.Is(err, ErrPingTimeout) {
+			t.Fatalf("disconnected with %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer not disconnected")
+	}
+}
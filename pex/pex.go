@@ -0,0 +1,151 @@
+// Package pex answers GetAddr peer-exchange requests from the address
+// manager, with the original signed AddressMsgs.
+package pex
+
+import (
+	"time"
+
+	"code.dogecoin.org/gossip/addrman"
+	"code.dogecoin.org/gossip/ban"
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+	"code.dogecoin.org/gossip/ratelimit"
+)
+
+// Peer is a connection that can receive messages.
+type Peer interface {
+	ID() uint64
+	TrySend(msg dnet.RawMessage) bool
+}
+
+// FeaturePeer is a Peer with negotiated protocol features.
+// *peer.Peer implements this interface; only peers that negotiated
+// node.FeatureAddrV2 are sent node.TagAddrV2 addresses.
+type FeaturePeer interface {
+	Peer
+	HasFeature(f uint64) bool
+}
+
+type Config struct {
+	Addrs       *addrman.Manager
+	MaxResponse int                                  // most addresses per response (default 250, at most node.MaxGetAddr)
+	MaxPercent  int                                  // most addresses per response as a percentage of those known, rounded up (default 23)
+	MaxAge      time.Duration                        // only share addresses signed within this time (default 24h)
+	PeerLimit   ratelimit.Limit                      // requests answered per peer (default one per 10m, burst 2)
+	TotalLimit  ratelimit.Limit                      // requests answered across all peers (default 1/s, burst 10)
+	Clock       dnet.Clock                           // default SystemClock
+	Misbehaving func(peer uint64, reason ban.Reason) // optional; report peer misbehavior
+}
+
+// Server answers GetAddr requests.
+//
+// Responses are capped in size and as a fraction of the table, and
+// rate-limited per peer and in total, so the exchange cannot be used
+// to scrape every known address quickly.
+type Server struct {
+	cfg   Config
+	peers *ratelimit.Limiter
+	total *ratelimit.Limiter
+}
+
+func New(cfg Config) *Server {
+	if cfg.MaxResponse <= 0 || cfg.MaxResponse > node.MaxGetAddr {
+		cfg.MaxResponse = 250
+	}
+	if cfg.MaxPercent <= 0 || cfg.MaxPercent > 100 {
+		cfg.MaxPercent = 23
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = 24 * time.Hour
+	}
+	if cfg.PeerLimit == (ratelimit.Limit{}) {
+		cfg.PeerLimit = ratelimit.Every(10*time.Minute, 2)
+	}
+	if cfg.TotalLimit == (ratelimit.Limit{}) {
+		cfg.TotalLimit = ratelimit.Limit{Rate: 1, Burst: 10}
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = dnet.SystemClock{}
+	}
+	return &Server{
+		cfg:   cfg,
+		peers: ratelimit.New(ratelimit.Config{Default: ratelimit.ChannelLimits{Peer: cfg.PeerLimit}, Clock: cfg.Clock}),
+		total: ratelimit.New(ratelimit.Config{Default: ratelimit.ChannelLimits{Peer: cfg.TotalLimit}, Clock: cfg.Clock}),
+	}
+}
+
+// Request builds a signed GetAddr request.
+func Request(key dnet.KeyPair, req node.GetAddrMsg) dnet.RawMessage {
+	return dnet.EncodeMessageRaw(node.ChannelNode, node.TagGetAddr, key, req.Encode())
+}
+
+// HandleMessage answers a GetAddr request from a peer.
+// Returns false if msg is not a GetAddr request.
+func (s *Server) HandleMessage(from Peer, msg dnet.Message) bool {
+	if msg.Chan != node.ChannelNode || msg.Tag != node.TagGetAddr {
+		return false
+	}
+	var req node.GetAddrMsg
+	if !codec.TryDecode(func() { req = node.DecodeGetAddrMsg(msg.Payload) }) {
+		s.misbehaving(from.ID(), ban.ReasonDecode)
+		return true
+	}
+	if !s.peers.AllowPeer(from.ID(), msg) {
+		s.misbehaving(from.ID(), ban.ReasonSpam)
+		return true
+	}
+	if !s.total.AllowPeer(0, msg) {
+		return true // busy; not the peer's fault
+	}
+	fp, ok := from.(FeaturePeer)
+	addrV2 := ok && fp.HasFeature(node.FeatureAddrV2)
+	for _, raw := range s.Response(req, addrV2) {
+		if !from.TrySend(raw) {
+			break
+		}
+	}
+	return true
+}
+
+// Response returns the signed address messages to send for a request,
+// without applying rate limits. Without addrV2 only node.TagAddress
+// messages are included.
+func (s *Server) Response(req node.GetAddrMsg, addrV2 bool) []dnet.RawMessage {
+	if s.cfg.Addrs == nil {
+		return nil
+	}
+	max := s.cfg.MaxResponse
+	if req.Max > 0 && int(req.Max) < max {
+		max = int(req.Max)
+	}
+	newCount, triedCount := s.cfg.Addrs.Len()
+	// rounded up, so a small table still shares its addresses
+	if pct := ((newCount+triedCount)*s.cfg.MaxPercent + 99) / 100; pct < max {
+		max = pct
+	}
+	maxAge := s.cfg.MaxAge
+	if req.MaxAge > 0 && time.Duration(req.MaxAge)*time.Second < maxAge {
+		maxAge = time.Duration(req.MaxAge) * time.Second
+	}
+	since := dnet.UnixToDoge(s.cfg.Clock.Now().Add(-maxAge))
+	entries := s.cfg.Addrs.Sample(max, func(e *addrman.Entry) bool {
+		return e.Msg.Time >= since && len(e.Raw.Header) > 0 && (addrV2 || !e.IsAddrV2()) && req.Offers(e.Msg)
+	})
+	res := make([]dnet.RawMessage, len(entries))
+	for i, e := range entries {
+		res[i] = e.Raw
+	}
+	return res
+}
+
+// RemovePeer forgets a disconnected peer's rate limit.
+func (s *Server) RemovePeer(peer uint64) {
+	s.peers.RemovePeer(peer)
+}
+
+func (s *Server) misbehaving(peer uint64, reason ban.Reason) {
+	if s.cfg.Misbehaving != nil {
+		s.cfg.Misbehaving(peer, reason)
+	}
+}
@@ -0,0 +1,163 @@
+package pex
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/addrman"
+	"code.dogecoin.org/gossip/ban"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/internal/testutil"
+	"code.dogecoin.org/gossip/node"
+	"code.dogecoin.org/gossip/ratelimit"
+)
+
+// testPeer records the messages sent to it.
+type testPeer struct {
+	id   uint64
+	sent []dnet.RawMessage
+}
+
+func (p *testPeer) ID() uint64 { return p.id }
+
+func (p *testPeer) TrySend(msg dnet.RawMessage) bool {
+	p.sent = append(p.sent, msg)
+	return true
+}
+
+// verified returns msg as received: decoded and signature-checked.
+func verified(t *testing.T, raw []byte) dnet.Message {
+	t.Helper()
+	msg, err := dnet.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+var source = dnet.AddressFromIP(net.IPv4(1, 2, 3, 4), 42069)
+
+// newTable returns an address manager holding n signed addresses.
+func newTable(t *testing.T, clock dnet.Clock, n int) *addrman.Manager {
+	t.Helper()
+	addrs := addrman.New(addrman.Config{Clock: clock})
+	// until n are held: an address can land in an occupied slot
+	for i := 0; ; i++ {
+		if newCount, _ := addrs.Len(); newCount == n {
+			return addrs
+		}
+		msg := node.AddressMsg{
+			Time:     dnet.DogeNowAt(clock),
+			Address:  dnet.AddressFromIP(net.IPv4(8, byte(i), 8, 8), 42069),
+			Owner:    make([]byte, 32),
+			Channels: []dnet.Tag4CC{dnet.NewTag("Test")},
+		}
+		raw := dnet.EncodeMessage(node.ChannelNode, node.TagAddress, testutil.NewKey(t), msg.Encode())
+		if err := addrs.Add(verified(t, raw), source); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func newTestServer(t *testing.T, n int, cfg Config) (*Server, *testutil.Clock) {
+	t.Helper()
+	clock := testutil.NewClock()
+	cfg.Clock = clock
+	cfg.Addrs = newTable(t, clock, n)
+	return New(cfg), clock
+}
+
+func TestResponsePercent(t *testing.T) {
+	for _, c := range []struct{ known, want int }{{1, 1}, {4, 1}, {5, 2}, {100, 23}} {
+		s, _ := newTestServer(t, c.known, Config{})
+		if got := len(s.Response(node.GetAddrMsg{}, false)); got != c.want {
+			t.Fatalf("%d known: %d addresses, want %d", c.known, got, c.want)
+		}
+	}
+}
+
+func TestResponseLimits(t *testing.T) {
+	s, clock := newTestServer(t, 100, Config{MaxResponse: 10, MaxPercent: 100})
+	if got := len(s.Response(node.GetAddrMsg{}, false)); got != 10 {
+		t.Fatalf("%d addresses, want MaxResponse", got)
+	}
+	if got := len(s.Response(node.GetAddrMsg{Max: 3}, false)); got != 3 {
+		t.Fatalf("%d addresses, want the requested 3", got)
+	}
+	if got := len(s.Response(node.GetAddrMsg{Channels: []dnet.Tag4CC{dnet.NewTag("None")}}, false)); got != 0 {
+		t.Fatalf("%d addresses match an unknown channel", got)
+	}
+	clock.Advance(2 * time.Hour)
+	if got := len(s.Response(node.GetAddrMsg{MaxAge: 3600}, false)); got != 0 {
+		t.Fatalf("%d addresses older than MaxAge", got)
+	}
+	for _, raw := range s.Response(node.GetAddrMsg{}, false) {
+		msg := verified(t, append(append([]byte(nil), raw.Header...), raw.Payload...))
+		if msg.Tag != node.TagAddress {
+			t.Fatalf("sent %v", msg.Tag)
+		}
+	}
+}
+
+func TestHandleMessage(t *testing.T) {
+	var reasons []ban.Reason
+	s, _ := newTestServer(t, 10, Config{
+		PeerLimit:   ratelimit.Every(time.Hour, 1),
+		Misbehaving: func(peer uint64, reason ban.Reason) { reasons = append(reasons, reason) },
+	})
+	key := testutil.NewKey(t)
+	req := verified(t, dnet.EncodeMessage(node.ChannelNode, node.TagGetAddr, key, node.GetAddrMsg{}.Encode()))
+	p := &testPeer{id: 1}
+	if !s.HandleMessage(p, req) || len(p.sent) != 3 {
+		t.Fatalf("sent %d addresses", len(p.sent))
+	}
+	s.HandleMessage(p, req)
+	if len(p.sent) != 3 || len(reasons) != 1 || reasons[0] != ban.ReasonSpam {
+		t.Fatalf("second request: sent %d, reasons %v", len(p.sent), reasons)
+	}
+	bad := verified(t, dnet.EncodeMessage(node.ChannelNode, node.TagGetAddr, key, []byte{1}))
+	s.HandleMessage(&testPeer{id: 2}, bad)
+	if len(reasons) != 2 || reasons[1] != ban.ReasonDecode {
+		t.Fatalf("reasons %v", reasons)
+	}
+	other := verified(t, dnet.EncodeMessage(node.ChannelNode, node.TagAddress, key, nil))
+	if s.HandleMessage(p, other) {
+		t.Fatal("handled a message that is not GetAddr")
+	}
+}
+
+// featurePeer is a testPeer with negotiated features.
+type featurePeer struct {
+	testPeer
+	features uint64
+}
+
+func (p *featurePeer) HasFeature(f uint64) bool { return p.features&f == f }
+
+func TestAddrV2OnlyToV2Peers(t *testing.T) {
+	s, clock := newTestServer(t, 1, Config{MaxPercent: 100})
+	v2 := node.AddressV2Msg{
+		Time:    dnet.DogeNowAt(clock),
+		Address: node.NetAddr{Network: node.NetTorV3, Addr: bytes.Repeat([]byte{7}, 32)},
+		Port:    42069,
+		Owner:   make([]byte, 32),
+	}
+	raw := dnet.EncodeMessage(node.ChannelNode, node.TagAddrV2, testutil.NewKey(t), v2.Encode())
+	if err := s.cfg.Addrs.Add(verified(t, raw), source); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.Response(node.GetAddrMsg{}, false)); n != 1 {
+		t.Fatalf("%d addresses for a v1 peer", n)
+	}
+	if n := len(s.Response(node.GetAddrMsg{}, true)); n != 2 {
+		t.Fatalf("%d addresses for a v2 peer", n)
+	}
+	req := verified(t, dnet.EncodeMessage(node.ChannelNode, node.TagGetAddr, testutil.NewKey(t), node.GetAddrMsg{}.Encode()))
+	p := &featurePeer{testPeer: testPeer{id: 1}, features: node.FeatureAddrV2}
+	s.HandleMessage(p, req)
+	if len(p.sent) != 2 {
+		t.Fatalf("sent %d to a v2 peer", len(p.sent))
+	}
+}
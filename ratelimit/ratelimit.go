@@ -0,0 +1,306 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/iden"
+	"code.dogecoin.org/gossip/node"
+)
+
+// Limit is a token-bucket rate: Rate tokens per second, up to Burst.
+// The zero Limit is unlimited.
+type Limit struct {
+	Rate  float64 // tokens added per second
+	Burst int     // bucket size
+}
+
+// Every returns a Limit allowing one message per interval, with a burst.
+func Every(interval time.Duration, burst int) Limit {
+	return Limit{Rate: float64(time.Second) / float64(interval), Burst: burst}
+}
+
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+// ChannelLimits are the limits for one channel.
+type ChannelLimits struct {
+	Peer   Limit                 // per (peer connection, channel)
+	Sender Limit                 // per (signing pubkey, channel, tag)
+	Tags   map[dnet.Tag4CC]Limit // per-tag overrides for Sender
+}
+
+func (c ChannelLimits) senderLimit(tag dnet.Tag4CC) Limit {
+	if l, ok := c.Tags[tag]; ok {
+		return l
+	}
+	return c.Sender
+}
+
+type Config struct {
+	Channels    map[dnet.Tag4CC]ChannelLimits
+	Default     ChannelLimits // channels not in Channels
+	IdleTimeout time.Duration // forget buckets that are refilled or unused this long (default 10m)
+	Clock       dnet.Clock    // optional; defaults to dnet.SystemClock
+}
+
+// DefaultConfig has limits that fit the refresh cadence of node address
+// and identity messages (a few per hour per key), with headroom for
+// peers relaying many nodes' messages.
+func DefaultConfig() Config {
+	return Config{
+		Channels: map[dnet.Tag4CC]ChannelLimits{
+			dnet.ChannelNode: {
+				Peer:   Limit{Rate: 50, Burst: 500},
+				Sender: Every(time.Minute, 10),
+				Tags: map[dnet.Tag4CC]Limit{
+					node.TagAddress: Every(5*time.Minute, 6),
+					node.TagAddrV2:  Every(5*time.Minute, 6),
+				},
+			},
+			dnet.ChannelIdentity: {
+				Peer:   Limit{Rate: 20, Burst: 200},
+				Sender: Every(time.Minute, 10),
+				Tags: map[dnet.Tag4CC]Limit{
+					iden.TagIdentity: Every(10*time.Minute, 3),
+				},
+			},
+			dnet.ChannelChat: {
+				Peer:   Limit{Rate: 20, Burst: 100},
+				Sender: Every(time.Second, 10),
+			},
+			dnet.ChannelB0rk: {
+				Peer:   Limit{Rate: 20, Burst: 100},
+				Sender: Every(time.Second, 10),
+			},
+		},
+		Default: ChannelLimits{
+			Peer:   Limit{Rate: 20, Burst: 100},
+			Sender: Every(time.Second, 10),
+		},
+	}
+}
+
+// Result of checking a message against the limits.
+type Result int
+
+const (
+	Allowed       Result = iota
+	PeerLimited          // the peer connection exceeded the channel limit
+	SenderLimited        // the signing key exceeded the channel/tag limit
+)
+
+// Limiter applies token-bucket limits keyed by (peer, channel)
+// and by (pubkey, channel, tag).
+type Limiter struct {
+	cfg      Config
+	mu       sync.Mutex
+	peers    map[peerKey]*bucket
+	senders  map[senderKey]*bucket
+	counters map[counterKey]*Counter
+	lastGC   time.Time
+}
+
+type peerKey struct {
+	peer uint64
+	ch   dnet.Tag4CC
+}
+
+type senderKey struct {
+	pub [32]byte
+	ch  dnet.Tag4CC
+	tag dnet.Tag4CC
+}
+
+type counterKey struct {
+	ch  dnet.Tag4CC
+	tag dnet.Tag4CC
+}
+
+// Counter counts messages checked for a channel and tag.
+// Channels not in Config.Channels share one Counter with a zero Chan
+// and Tag; tags without a Tags override share one with a zero Tag, so
+// the number of counters is bounded by the configuration.
+type Counter struct {
+	Chan          dnet.Tag4CC
+	Tag           dnet.Tag4CC
+	Allowed       uint64
+	PeerLimited   uint64
+	SenderLimited uint64
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+func New(cfg Config) *Limiter {
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = 10 * time.Minute
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = dnet.SystemClock{}
+	}
+	return &Limiter{
+		cfg:      cfg,
+		peers:    make(map[peerKey]*bucket),
+		senders:  make(map[senderKey]*bucket),
+		counters: make(map[counterKey]*Counter),
+		lastGC:   cfg.Clock.Now(),
+	}
+}
+
+func (l *Limiter) limits(ch dnet.Tag4CC) ChannelLimits {
+	if c, ok := l.cfg.Channels[ch]; ok {
+		return c
+	}
+	return l.cfg.Default
+}
+
+// AllowPeer charges a message against the (peer, channel) limit.
+// Use this for every message received, including duplicates.
+func (l *Limiter) AllowPeer(peer uint64, msg dnet.Message) bool {
+	now := l.cfg.Clock.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.gc(now)
+	limit := l.limits(msg.Chan).Peer
+	if limit.Unlimited() {
+		return true
+	}
+	key := peerKey{peer: peer, ch: msg.Chan}
+	b := l.peers[key]
+	if b == nil {
+		b = newBucket(limit, now)
+		l.peers[key] = b
+	}
+	if !b.take(limit, now) {
+		l.counter(msg).PeerLimited++
+		return false
+	}
+	return true
+}
+
+// AllowSender charges a message against the (pubkey, channel, tag) limit.
+// Use this once per new (non-duplicate) message.
+func (l *Limiter) AllowSender(msg dnet.Message) bool {
+	now := l.cfg.Clock.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.gc(now)
+	limit := l.limits(msg.Chan).senderLimit(msg.Tag)
+	if !limit.Unlimited() {
+		key := senderKey{pub: *(*[32]byte)(msg.PubKey), ch: msg.Chan, tag: msg.Tag}
+		b := l.senders[key]
+		if b == nil {
+			b = newBucket(limit, now)
+			l.senders[key] = b
+		}
+		if !b.take(limit, now) {
+			l.counter(msg).SenderLimited++
+			return false
+		}
+	}
+	l.counter(msg).Allowed++
+	return true
+}
+
+// Allow checks both limits for a message from a peer.
+func (l *Limiter) Allow(peer uint64, msg dnet.Message) Result {
+	if !l.AllowPeer(peer, msg) {
+		return PeerLimited
+	}
+	if !l.AllowSender(msg) {
+		return SenderLimited
+	}
+	return Allowed
+}
+
+// RemovePeer forgets the buckets for a disconnected peer.
+func (l *Limiter) RemovePeer(peer uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k := range l.peers {
+		if k.peer == peer {
+			delete(l.peers, k)
+		}
+	}
+}
+
+// Counters returns message counts for each channel and tag seen
+// (see Counter for how unknown channels and tags are grouped.)
+func (l *Limiter) Counters() []Counter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	res := make([]Counter, 0, len(l.counters))
+	for _, c := range l.counters {
+		res = append(res, *c)
+	}
+	return res
+}
+
+// counter returns the counter for a message; l.mu must be held.
+func (l *Limiter) counter(msg dnet.Message) *Counter {
+	var key counterKey
+	if c, ok := l.cfg.Channels[msg.Chan]; ok {
+		key.ch = msg.Chan
+		if _, ok := c.Tags[msg.Tag]; ok {
+			key.tag = msg.Tag
+		}
+	}
+	c := l.counters[key]
+	if c == nil {
+		c = &Counter{Chan: key.ch, Tag: key.tag}
+		l.counters[key] = c
+	}
+	return c
+}
+
+// gc drops buckets that have refilled completely (a full bucket
+// behaves like a new one) or have not been used for IdleTimeout;
+// l.mu must be held.
+func (l *Limiter) gc(now time.Time) {
+	if now.Sub(l.lastGC) < l.cfg.IdleTimeout {
+		return
+	}
+	l.lastGC = now
+	idle := now.Add(-l.cfg.IdleTimeout)
+	for k, b := range l.peers {
+		if b.full(now) || b.last.Before(idle) {
+			delete(l.peers, k)
+		}
+	}
+	for k, b := range l.senders {
+		if b.full(now) || b.last.Before(idle) {
+			delete(l.senders, k)
+		}
+	}
+}
+
+func newBucket(limit Limit, now time.Time) *bucket {
+	return &bucket{tokens: float64(limit.Burst), last: now, limit: limit}
+}
+
+func (b *bucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst)
+}
+
+// take refills the bucket and removes one token if available.
+func (b *bucket) take(limit Limit, now time.Time) bool {
+	b.limit = limit
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * limit.Rate
+		if b.tokens > float64(limit.Burst) {
+			b.tokens = float64(limit.Burst)
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
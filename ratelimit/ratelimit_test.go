@@ -0,0 +1,121 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/internal/testutil"
+)
+
+var testChan = dnet.NewTag("Test")
+var testTag = dnet.NewTag("Tst1")
+
+func newTestLimiter(cfg Config) (*Limiter, *testutil.Clock) {
+	clock := testutil.NewClock()
+	cfg.Clock = clock
+	return New(cfg), clock
+}
+
+// message returns a message from a sender; only the header fields
+// the limiter reads are set.
+func message(sender byte, channel, tag dnet.Tag4CC) dnet.Message {
+	pub := make([]byte, 32)
+	pub[0] = sender
+	return dnet.Message{Chan: channel, Tag: tag, PubKey: pub}
+}
+
+func TestPeerLimit(t *testing.T) {
+	l, clock := newTestLimiter(Config{Default: ChannelLimits{Peer: Limit{Rate: 1, Burst: 2}}})
+	msg := message(1, testChan, testTag)
+	if !l.AllowPeer(1, msg) || !l.AllowPeer(1, msg) {
+		t.Fatal("burst not allowed")
+	}
+	if l.AllowPeer(1, msg) {
+		t.Fatal("over the burst was allowed")
+	}
+	if !l.AllowPeer(2, msg) {
+		t.Fatal("another peer was limited")
+	}
+	clock.Advance(time.Second)
+	if !l.AllowPeer(1, msg) {
+		t.Fatal("bucket did not refill")
+	}
+}
+
+func TestSenderTagOverride(t *testing.T) {
+	slow := dnet.NewTag("Slow")
+	l, _ := newTestLimiter(Config{Channels: map[dnet.Tag4CC]ChannelLimits{
+		testChan: {Sender: Limit{Rate: 1, Burst: 5}, Tags: map[dnet.Tag4CC]Limit{slow: {Rate: 1, Burst: 1}}},
+	}})
+	if !l.AllowSender(message(1, testChan, slow)) || l.AllowSender(message(1, testChan, slow)) {
+		t.Fatal("tag override not applied")
+	}
+	if !l.AllowSender(message(1, testChan, testTag)) || !l.AllowSender(message(2, testChan, slow)) {
+		t.Fatal("limit shared across tags or senders")
+	}
+	if r := l.Allow(1, message(1, testChan, slow)); r != SenderLimited {
+		t.Fatalf("Allow: %v", r)
+	}
+}
+
+func TestCountersBounded(t *testing.T) {
+	known := dnet.NewTag("Know")
+	l, _ := newTestLimiter(Config{Channels: map[dnet.Tag4CC]ChannelLimits{
+		testChan: {Tags: map[dnet.Tag4CC]Limit{known: {}}},
+	}})
+	for i := 0; i < 100; i++ {
+		tag := dnet.Tag4CC(i)
+		l.AllowSender(message(1, dnet.Tag4CC(1000+i), tag))
+		l.AllowSender(message(1, testChan, tag))
+	}
+	l.AllowSender(message(1, testChan, known))
+	counts := make(map[counterKey]uint64)
+	for _, c := range l.Counters() {
+		counts[counterKey{c.Chan, c.Tag}] = c.Allowed
+	}
+	want := map[counterKey]uint64{
+		{0, 0}:            100, // unknown channels
+		{testChan, 0}:     100, // unknown tags on a known channel
+		{testChan, known}: 1,
+	}
+	if len(counts) != len(want) {
+		t.Fatalf("%d counters: %v", len(counts), counts)
+	}
+	for k, n := range want {
+		if counts[k] != n {
+			t.Fatalf("counter %v: %d, want %d", k, counts[k], n)
+		}
+	}
+}
+
+func TestIdleBucketsEvicted(t *testing.T) {
+	// a slow limit: buckets take an hour to refill
+	l, clock := newTestLimiter(Config{Default: ChannelLimits{
+		Peer:   Every(time.Hour, 2),
+		Sender: Every(time.Hour, 2),
+	}})
+	for i := 0; i < 50; i++ {
+		l.Allow(uint64(i), message(byte(i), testChan, testTag))
+	}
+	clock.Advance(11 * time.Minute)
+	l.Allow(99, message(99, testChan, testTag))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.peers) != 1 || len(l.senders) != 1 {
+		t.Fatalf("kept %d peer and %d sender buckets", len(l.peers), len(l.senders))
+	}
+}
+
+func TestRemovePeer(t *testing.T) {
+	l, _ := newTestLimiter(Config{Default: ChannelLimits{Peer: Limit{Rate: 1, Burst: 1}}})
+	l.AllowPeer(1, message(1, testChan, testTag))
+	l.AllowPeer(2, message(1, testChan, testTag))
+	l.RemovePeer(1)
+	if !l.AllowPeer(1, message(1, testChan, testTag)) {
+		t.Fatal("removed peer is still limited")
+	}
+	if l.AllowPeer(2, message(1, testChan, testTag)) {
+		t.Fatal("other peer's bucket was removed")
+	}
+}
@@ -0,0 +1,503 @@
+package relay
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"code.dogecoin.org/gossip/ban"
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+	"code.dogecoin.org/gossip/ratelimit"
+)
+
+// Peer is a connection that messages can be relayed to.
+// *peer.Peer implements this interface.
+type Peer interface {
+	ID() uint64
+	TrySend(msg dnet.RawMessage) bool
+}
+
+// FeaturePeer is a Peer with negotiated protocol features.
+// *peer.Peer implements this interface; peers that do not are
+// treated as supporting no features.
+type FeaturePeer interface {
+	Peer
+	HasFeature(f uint64) bool
+}
+
+// DefaultRequires are the features a peer needs to be sent each tag.
+var DefaultRequires = map[dnet.Tag4CC]uint64{
+	node.TagAddrV2: node.FeatureAddrV2,
+}
+
+// Policy controls how messages on a channel are relayed.
+type Policy struct {
+	Relay    bool // forward messages to other peers
+	Fanout   int  // number of peers to forward each message to (0: all peers)
+	Announce bool // announce message IDs (inventory) instead of pushing payloads
+}
+
+var DefaultPolicy = Policy{Relay: true, Fanout: 8}
+
+// DefaultNodeTags are node.ChannelNode tags sent to one peer, as
+// requests or replies; they are delivered locally but never relayed.
+var DefaultNodeTags = map[dnet.Tag4CC]Policy{
+	node.TagVersion:    {},
+	node.TagVerAck:     {},
+	node.TagPing:       {},
+	node.TagPong:       {},
+	node.TagGetAddr:    {},
+	node.TagGetHistory: {},
+	node.TagHistory:    {},
+}
+
+type Config struct {
+	Policies      map[dnet.Tag4CC]Policy               // per-channel policy
+	Default       *Policy                              // policy for other channels (default: DefaultPolicy)
+	NodeTags      map[dnet.Tag4CC]Policy               // per-tag policy on node.ChannelNode (default DefaultNodeTags)
+	SeenTTL       time.Duration                        // how long to remember message IDs (default 1h)
+	Deliver       func(msg dnet.Message)               // optional; hand new messages to local handlers
+	OnDuplicate   func(from uint64, msg dnet.Message)  // optional; called for each duplicate received
+	OnDecodeError func(from uint64, msg dnet.Message)  // optional; called for each node message that failed to decode
+	Misbehaving   func(peer uint64, reason ban.Reason) // optional; report peer misbehavior
+	Limiter       *ratelimit.Limiter                   // optional; drop over-limit messages before relay
+	Clock         dnet.Clock                           // optional; defaults to dnet.SystemClock
+	Seed          int64                                // optional; seed for peer selection (default: random)
+	Requires      map[dnet.Tag4CC]uint64               // features a peer needs to be sent a tag (default DefaultRequires)
+
+	// Node address checks (node.TagAddress and node.TagAddrV2)
+	AddrRules *node.AddrRules                                                // rules for relaying addresses (default node.DefaultAddrRules)
+	OnInvalid func(from uint64, msg dnet.Message, reasons []node.AddrReason) // optional; called for each address dropped by AddrRules
+
+	// Inventory relay (channels with Policy.Announce)
+	Key              dnet.KeyPair  // node key, used to sign inventory messages
+	AnnounceInterval time.Duration // batch announcements for this long (default 100ms)
+	RequestTimeout   time.Duration // ask another peer after this long (default 5s)
+	CacheTTL         time.Duration // keep announced messages to serve requests (default 10m)
+	MaxInflight      int           // most outstanding requests in total (default 8192)
+	MaxPeerInflight  int           // most outstanding requests to one peer (default 1024)
+}
+
+// Engine is a flood-routing gossip relay.
+//
+// Each new verified message is handed to local handlers and forwarded
+// to a random subset of peers other than the sender. Message IDs are
+// kept in a time-bounded seen-set so duplicates are dropped; messages
+// are forwarded with their original signature (never re-signed.)
+//
+// Channels with Policy.Announce use inventory relay instead: message
+// IDs are announced to peers in batches (node.TagInv), peers request
+// the ones they are missing (node.TagGetMsgs) and receive the original
+// frames. Requests that are not answered in time are retried with
+// another peer that announced the same message.
+type Engine struct {
+	cfg      Config
+	mu       sync.Mutex
+	peers    map[uint64]*peerState
+	seen     map[dnet.MsgID]time.Time
+	order    []seenEntry // seen IDs in arrival order, for expiry
+	head     int         // first unexpired entry in order
+	cache    map[dnet.MsgID]dnet.RawMessage
+	cached   []seenEntry // cached IDs in arrival order, for expiry
+	inflight map[dnet.MsgID]*request
+	rand     *rand.Rand
+}
+
+type seenEntry struct {
+	id dnet.MsgID
+	at time.Time
+}
+
+type peerState struct {
+	peer       Peer
+	inv        []dnet.MsgID // pending announcements
+	inflight   int          // outstanding requests to this peer
+	received   uint64
+	duplicates uint64
+	relayed    uint64
+	dropped    uint64
+	timeouts   uint64
+}
+
+// PeerStats are relay counters for one peer.
+type PeerStats struct {
+	Peer       uint64
+	Received   uint64 // messages received from the peer
+	Duplicates uint64 // messages received that we had already seen
+	Relayed    uint64 // messages queued to the peer
+	Dropped    uint64 // messages not queued because the peer's queue was full
+	Timeouts   uint64 // requested messages the peer failed to deliver
+}
+
+func New(cfg Config) *Engine {
+	if cfg.Default == nil {
+		cfg.Default = &DefaultPolicy
+	}
+	if cfg.SeenTTL <= 0 {
+		cfg.SeenTTL = time.Hour
+	}
+	if cfg.AnnounceInterval <= 0 {
+		cfg.AnnounceInterval = 100 * time.Millisecond
+	}
+	if cfg.RequestTimeout <= 0 {
+		cfg.RequestTimeout = 5 * time.Second
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = 10 * time.Minute
+	}
+	if cfg.MaxInflight <= 0 {
+		cfg.MaxInflight = 8192
+	}
+	if cfg.MaxPeerInflight <= 0 {
+		cfg.MaxPeerInflight = 1024
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = dnet.SystemClock{}
+	}
+	if cfg.Requires == nil {
+		cfg.Requires = DefaultRequires
+	}
+	if cfg.NodeTags == nil {
+		cfg.NodeTags = DefaultNodeTags
+	}
+	if cfg.AddrRules == nil {
+		cfg.AddrRules = &node.DefaultAddrRules
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	return &Engine{
+		cfg:      cfg,
+		peers:    make(map[uint64]*peerState),
+		seen:     make(map[dnet.MsgID]time.Time),
+		cache:    make(map[dnet.MsgID]dnet.RawMessage),
+		inflight: make(map[dnet.MsgID]*request),
+		rand:     rand.New(rand.NewSource(cfg.Seed)),
+	}
+}
+
+// Policy returns the relay policy for a channel.
+func (e *Engine) Policy(channel dnet.Tag4CC) Policy {
+	if p, ok := e.cfg.Policies[channel]; ok {
+		return p
+	}
+	return *e.cfg.Default
+}
+
+// tagPolicy returns the relay policy for a tag on a channel.
+func (e *Engine) tagPolicy(channel dnet.Tag4CC, tag dnet.Tag4CC) Policy {
+	if channel == node.ChannelNode {
+		if p, ok := e.cfg.NodeTags[tag]; ok {
+			return p
+		}
+	}
+	return e.Policy(channel)
+}
+
+func (e *Engine) AddPeer(p Peer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok := e.peers[p.ID()]; !ok {
+		e.peers[p.ID()] = &peerState{peer: p}
+	}
+}
+
+func (e *Engine) RemovePeer(id uint64) {
+	e.mu.Lock()
+	delete(e.peers, id)
+	e.mu.Unlock()
+	if e.cfg.Limiter != nil {
+		e.cfg.Limiter.RemovePeer(id)
+	}
+}
+
+// HandleMessage processes a verified message received from a peer.
+// Returns false if the message was a duplicate or was dropped.
+// Inventory messages (node.TagInv, node.TagGetMsgs) are handled here
+// and not delivered or relayed. With a Limiter, messages over the
+// peer's channel limit are dropped (and reported as spam), and new
+// messages over the sender's limit are dropped without relaying.
+// Node address messages that fail AddrRules are dropped. Node
+// requests and replies (NodeTags that do not relay) are delivered but
+// not relayed, and are never treated as duplicates: a repeated request
+// needs a new reply.
+func (e *Engine) HandleMessage(from uint64, msg dnet.Message) bool {
+	if e.cfg.Limiter != nil && !e.cfg.Limiter.AllowPeer(from, msg) {
+		e.misbehaving(from, ban.ReasonSpam)
+		return false
+	}
+	if msg.Chan == node.ChannelNode {
+		switch msg.Tag {
+		case node.TagInv:
+			e.handleInv(from, msg)
+			return true
+		case node.TagGetMsgs:
+			e.handleGetMsgs(from, msg)
+			return true
+		case node.TagAddress, node.TagAddrV2:
+			if !e.validAddr(from, msg) {
+				return false
+			}
+		}
+	}
+	if e.direct(msg.Chan, msg.Tag) {
+		return e.handleDirect(from, msg)
+	}
+	id := msg.ID()
+	now := e.cfg.Clock.Now()
+	e.mu.Lock()
+	ps := e.peers[from]
+	if ps != nil {
+		ps.received++
+	}
+	e.expire(now)
+	if _, dup := e.seen[id]; dup {
+		if ps != nil {
+			ps.duplicates++
+		}
+		e.mu.Unlock()
+		if e.cfg.OnDuplicate != nil {
+			e.cfg.OnDuplicate(from, msg)
+		}
+		return false
+	}
+	// not marked seen until accepted, so a later copy can still relay
+	if e.cfg.Limiter != nil && !e.cfg.Limiter.AllowSender(msg) {
+		e.mu.Unlock()
+		return false
+	}
+	e.markSeen(id, now)
+	if req := e.inflight[id]; req != nil {
+		e.finishRequest(id, req)
+	}
+	targets := e.route(msg, id, from, now)
+	e.mu.Unlock()
+	if e.cfg.Deliver != nil {
+		e.cfg.Deliver(msg)
+	}
+	e.forward(msg, targets)
+	return true
+}
+
+// direct reports whether a tag is a node request or reply, sent to one
+// peer and never relayed.
+func (e *Engine) direct(channel dnet.Tag4CC, tag dnet.Tag4CC) bool {
+	if channel != node.ChannelNode {
+		return false
+	}
+	p, ok := e.cfg.NodeTags[tag]
+	return ok && !p.Relay
+}
+
+// handleDirect delivers a node request or reply without adding it to
+// the seen-set.
+func (e *Engine) handleDirect(from uint64, msg dnet.Message) bool {
+	if e.cfg.Limiter != nil && !e.cfg.Limiter.AllowSender(msg) {
+		return false
+	}
+	e.mu.Lock()
+	if ps := e.peers[from]; ps != nil {
+		ps.received++
+	}
+	e.mu.Unlock()
+	if e.cfg.Deliver != nil {
+		e.cfg.Deliver(msg)
+	}
+	return true
+}
+
+// Publish relays a message that originated locally (e.g. from a handler.)
+// Returns false if the message was already seen.
+func (e *Engine) Publish(msg dnet.Message) bool {
+	id := msg.ID()
+	now := e.cfg.Clock.Now()
+	e.mu.Lock()
+	if !e.markSeen(id, now) {
+		e.mu.Unlock()
+		return false
+	}
+	targets := e.route(msg, id, 0, now)
+	e.mu.Unlock()
+	e.forward(msg, targets)
+	return true
+}
+
+// Seen reports whether a message ID is in the seen-set.
+func (e *Engine) Seen(id dnet.MsgID) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, ok := e.seen[id]
+	return ok
+}
+
+// Duplicates returns the number of duplicate messages a peer has sent us.
+func (e *Engine) Duplicates(peer uint64) uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if ps := e.peers[peer]; ps != nil {
+		return ps.duplicates
+	}
+	return 0
+}
+
+// Stats returns relay counters for each peer.
+func (e *Engine) Stats() []PeerStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	stats := make([]PeerStats, 0, len(e.peers))
+	for id, ps := range e.peers {
+		stats = append(stats, PeerStats{
+			Peer:       id,
+			Received:   ps.received,
+			Duplicates: ps.duplicates,
+			Relayed:    ps.relayed,
+			Dropped:    ps.dropped,
+			Timeouts:   ps.timeouts,
+		})
+	}
+	return stats
+}
+
+// validAddr checks a node address message before it is delivered or
+// forwarded, so we never pass on addresses other nodes would reject.
+// A stale or future-dated address is reported as misbehavior.
+func (e *Engine) validAddr(from uint64, msg dnet.Message) bool {
+	var reasons []node.AddrReason
+	now := e.cfg.Clock.Now()
+	if msg.Tag == node.TagAddrV2 {
+		var addr node.AddressV2Msg
+		if !codec.TryDecode(func() { addr = node.DecodeAddrV2Msg(msg.Payload) }) || !addr.IsValid() {
+			e.decodeFailed(from, msg)
+			return false
+		}
+		reasons = addr.Validate(now, *e.cfg.AddrRules)
+	} else {
+		var addr node.AddressMsg
+		if !codec.TryDecode(func() { addr = node.DecodeAddrMsg(msg.Payload) }) || !addr.IsValid() {
+			e.decodeFailed(from, msg)
+			return false
+		}
+		reasons = addr.Validate(now, *e.cfg.AddrRules)
+	}
+	if len(reasons) == 0 {
+		return true
+	}
+	for _, r := range reasons {
+		if r == node.AddrTimeSkew {
+			e.misbehaving(from, ban.ReasonStaleTime)
+			break
+		}
+	}
+	if e.cfg.OnInvalid != nil {
+		e.cfg.OnInvalid(from, msg, reasons)
+	}
+	return false
+}
+
+// markSeen adds id to the seen-set, returning false if it was
+// already present; e.mu must be held.
+func (e *Engine) markSeen(id dnet.MsgID, now time.Time) bool {
+	e.expire(now)
+	if _, ok := e.seen[id]; ok {
+		return false
+	}
+	e.seen[id] = now
+	e.order = append(e.order, seenEntry{id: id, at: now})
+	return true
+}
+
+// expire removes seen IDs older than SeenTTL; e.mu must be held.
+func (e *Engine) expire(now time.Time) {
+	cutoff := now.Add(-e.cfg.SeenTTL)
+	for e.head < len(e.order) && e.order[e.head].at.Before(cutoff) {
+		delete(e.seen, e.order[e.head].id)
+		e.head++
+	}
+	if e.head > 1024 && e.head*2 > len(e.order) {
+		e.order = append(e.order[:0], e.order[e.head:]...)
+		e.head = 0
+	}
+}
+
+// Run calls Tick periodically until ctx is done.
+// It uses a real ticker; simulations call Tick directly.
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.cfg.AnnounceInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			e.Tick(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// route selects peers for a new message: flood channels return the
+// peers to forward to; announce channels queue the ID for the selected
+// peers instead. e.mu must be held.
+func (e *Engine) route(msg dnet.Message, id dnet.MsgID, from uint64, now time.Time) []*peerState {
+	targets := e.selectPeers(msg.Chan, msg.Tag, from)
+	if !e.tagPolicy(msg.Chan, msg.Tag).Announce {
+		return targets
+	}
+	e.addCache(id, dnet.ReEncodeMessage(msg.Chan, msg.Tag, (*[32]byte)(msg.PubKey), msg.Signature, msg.Payload), now)
+	for _, ps := range targets {
+		ps.inv = append(ps.inv, id)
+	}
+	return nil
+}
+
+// selectPeers picks a random subset of peers to forward to; e.mu must be held.
+func (e *Engine) selectPeers(channel dnet.Tag4CC, tag dnet.Tag4CC, except uint64) []*peerState {
+	policy := e.tagPolicy(channel, tag)
+	if !policy.Relay {
+		return nil
+	}
+	requires := e.cfg.Requires[tag]
+	targets := make([]*peerState, 0, len(e.peers))
+	for id, ps := range e.peers {
+		if id != except && supports(ps.peer, requires) {
+			targets = append(targets, ps)
+		}
+	}
+	if policy.Fanout > 0 && len(targets) > policy.Fanout {
+		// partial Fisher-Yates shuffle
+		for i := 0; i < policy.Fanout; i++ {
+			j := i + e.rand.Intn(len(targets)-i)
+			targets[i], targets[j] = targets[j], targets[i]
+		}
+		targets = targets[:policy.Fanout]
+	}
+	return targets
+}
+
+// supports reports whether a peer negotiated the required features.
+func supports(p Peer, features uint64) bool {
+	if features == 0 {
+		return true
+	}
+	fp, ok := p.(FeaturePeer)
+	return ok && fp.HasFeature(features)
+}
+
+func (e *Engine) forward(msg dnet.Message, targets []*peerState) {
+	if len(targets) == 0 {
+		return
+	}
+	raw := dnet.ReEncodeMessage(msg.Chan, msg.Tag, (*[32]byte)(msg.PubKey), msg.Signature, msg.Payload)
+	for _, ps := range targets {
+		ok := ps.peer.TrySend(raw)
+		e.mu.Lock()
+		if ok {
+			ps.relayed++
+		} else {
+			ps.dropped++
+		}
+		e.mu.Unlock()
+	}
+}
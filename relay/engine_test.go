@@ -0,0 +1,270 @@
+package relay
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/ban"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/internal/testutil"
+	"code.dogecoin.org/gossip/node"
+	"code.dogecoin.org/gossip/ratelimit"
+)
+
+var testChan = dnet.NewTag("Test")
+var testTag = dnet.NewTag("Tst1")
+
+// testPeer records the messages queued to it.
+type testPeer struct {
+	id   uint64
+	mu   sync.Mutex
+	sent []dnet.RawMessage
+}
+
+func (p *testPeer) ID() uint64 { return p.id }
+
+func (p *testPeer) TrySend(msg dnet.RawMessage) bool {
+	p.mu.Lock()
+	p.sent = append(p.sent, msg)
+	p.mu.Unlock()
+	return true
+}
+
+func (p *testPeer) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.sent)
+}
+
+func newMessage(t *testing.T, key dnet.KeyPair, channel, tag dnet.Tag4CC, payload []byte) dnet.Message {
+	t.Helper()
+	msg, err := dnet.ReadMessage(bytes.NewReader(dnet.EncodeMessage(channel, tag, key, payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func newEngine(cfg Config, peers ...*testPeer) *Engine {
+	cfg.Seed = 1
+	e := New(cfg)
+	for _, p := range peers {
+		e.AddPeer(p)
+	}
+	return e
+}
+
+func TestFloodAndDedup(t *testing.T) {
+	a, b, c := &testPeer{id: 1}, &testPeer{id: 2}, &testPeer{id: 3}
+	delivered := 0
+	e := newEngine(Config{Deliver: func(dnet.Message) { delivered++ }}, a, b, c)
+	msg := newMessage(t, testutil.NewKey(t), testChan, testTag, []byte("hi"))
+
+	if !e.HandleMessage(a.id, msg) {
+		t.Fatal("new message was dropped")
+	}
+	if a.count() != 0 || b.count() != 1 || c.count() != 1 {
+		t.Fatalf("forwarded: a=%d b=%d c=%d", a.count(), b.count(), c.count())
+	}
+	if e.HandleMessage(b.id, msg) {
+		t.Fatal("duplicate was accepted")
+	}
+	if delivered != 1 || e.Duplicates(b.id) != 1 || !e.Seen(msg.ID()) {
+		t.Fatalf("delivered %d, duplicates %d", delivered, e.Duplicates(b.id))
+	}
+	if b.count() != 1 || c.count() != 1 {
+		t.Fatal("duplicate was forwarded")
+	}
+}
+
+func TestFanout(t *testing.T) {
+	var peers []*testPeer
+	for i := uint64(1); i <= 10; i++ {
+		peers = append(peers, &testPeer{id: i})
+	}
+	e := newEngine(Config{Default: &Policy{Relay: true, Fanout: 3}}, peers...)
+	e.HandleMessage(1, newMessage(t, testutil.NewKey(t), testChan, testTag, nil))
+	total := 0
+	for _, p := range peers {
+		total += p.count()
+	}
+	if total != 3 {
+		t.Fatalf("forwarded to %d peers, want 3", total)
+	}
+}
+
+func TestNodeRequestsNotRelayed(t *testing.T) {
+	a, b := &testPeer{id: 1}, &testPeer{id: 2}
+	var delivered []dnet.Tag4CC
+	e := newEngine(Config{Deliver: func(msg dnet.Message) { delivered = append(delivered, msg.Tag) }}, a, b)
+	key := testutil.NewKey(t)
+	requests := []dnet.Message{
+		newMessage(t, key, node.ChannelNode, node.TagGetAddr, node.GetAddrMsg{Max: 10}.Encode()),
+		newMessage(t, key, node.ChannelNode, node.TagGetHistory, node.GetHistoryMsg{Chan: testChan}.Encode()),
+		newMessage(t, key, node.ChannelNode, node.TagPing, []byte{1, 2, 3, 4, 5, 6, 7, 8}),
+	}
+	for _, msg := range requests {
+		if !e.HandleMessage(a.id, msg) {
+			t.Fatalf("%v was dropped", msg.Tag)
+		}
+	}
+	if b.count() != 0 {
+		t.Fatalf("%d node requests were relayed", b.count())
+	}
+	if len(delivered) != len(requests) {
+		t.Fatalf("delivered %d of %d requests", len(delivered), len(requests))
+	}
+	if !e.Publish(newMessage(t, key, node.ChannelNode, node.TagGetAddr, []byte{1})) || b.count() != 0 {
+		t.Fatal("published request was relayed")
+	}
+}
+
+func TestRepeatedRequestDelivered(t *testing.T) {
+	a := &testPeer{id: 1}
+	delivered := 0
+	e := newEngine(Config{Deliver: func(dnet.Message) { delivered++ }}, a)
+	key := testutil.NewKey(t)
+	req := node.GetAddrMsg{Max: 10}.Encode()
+	first := newMessage(t, key, node.ChannelNode, node.TagGetAddr, req)
+	second := newMessage(t, key, node.ChannelNode, node.TagGetAddr, req)
+	if first.ID() != second.ID() {
+		t.Fatal("identical requests have different IDs")
+	}
+	if !e.HandleMessage(a.id, first) || !e.HandleMessage(a.id, second) {
+		t.Fatal("repeated request was dropped")
+	}
+	if delivered != 2 || e.Duplicates(a.id) != 0 || e.Seen(first.ID()) {
+		t.Fatalf("delivered %d, duplicates %d", delivered, e.Duplicates(a.id))
+	}
+}
+
+func TestSenderLimitedNotSeen(t *testing.T) {
+	clock := testutil.NewClock()
+	limiter := ratelimit.New(ratelimit.Config{
+		Default: ratelimit.ChannelLimits{Sender: ratelimit.Limit{Rate: 1, Burst: 1}},
+		Clock:   clock,
+	})
+	a, b := &testPeer{id: 1}, &testPeer{id: 2}
+	e := newEngine(Config{Limiter: limiter, Clock: clock}, a, b)
+	key := testutil.NewKey(t)
+	first := newMessage(t, key, testChan, testTag, []byte("1"))
+	second := newMessage(t, key, testChan, testTag, []byte("2"))
+
+	if !e.HandleMessage(a.id, first) {
+		t.Fatal("first message was dropped")
+	}
+	if e.HandleMessage(a.id, second) {
+		t.Fatal("message over the sender limit was accepted")
+	}
+	if e.Seen(second.ID()) {
+		t.Fatal("rejected message was marked seen")
+	}
+	clock.Advance(2 * time.Second)
+	if !e.HandleMessage(a.id, second) {
+		t.Fatal("a later copy of the rejected message was dropped")
+	}
+	if b.count() != 2 {
+		t.Fatalf("relayed %d messages, want 2", b.count())
+	}
+}
+
+func TestInvalidAddressDropped(t *testing.T) {
+	a, b := &testPeer{id: 1}, &testPeer{id: 2}
+	var reasons []node.AddrReason
+	e := newEngine(Config{OnInvalid: func(from uint64, msg dnet.Message, r []node.AddrReason) { reasons = r }}, a, b)
+	key := testutil.NewKey(t)
+	addr := node.AddressMsg{Time: dnet.DogeNow(), Address: dnet.AddressFromIP([]byte{127, 0, 0, 1}, 22556), Owner: make([]byte, 32)}
+	msg := newMessage(t, key, node.ChannelNode, node.TagAddress, addr.Encode())
+	if e.HandleMessage(a.id, msg) {
+		t.Fatal("unroutable address was accepted")
+	}
+	if len(reasons) == 0 || b.count() != 0 {
+		t.Fatalf("reasons %v, relayed %d", reasons, b.count())
+	}
+}
+
+func TestStaleAddressReported(t *testing.T) {
+	a := &testPeer{id: 1}
+	var reasons []ban.Reason
+	e := newEngine(Config{Misbehaving: func(peer uint64, r ban.Reason) { reasons = append(reasons, r) }}, a)
+	key := testutil.NewKey(t)
+	addr := node.AddressMsg{
+		Time:    dnet.DogeNow() - 60*24*60*60,
+		Address: dnet.AddressFromIP([]byte{8, 8, 8, 8}, 22556),
+		Owner:   make([]byte, 32),
+	}
+	if e.HandleMessage(a.id, newMessage(t, key, node.ChannelNode, node.TagAddress, addr.Encode())) {
+		t.Fatal("stale address was accepted")
+	}
+	if len(reasons) != 1 || reasons[0] != ban.ReasonStaleTime {
+		t.Fatalf("reported %v", reasons)
+	}
+}
+
+// featurePeer is a testPeer with negotiated features.
+type featurePeer struct {
+	testPeer
+	features uint64
+}
+
+func (p *featurePeer) HasFeature(f uint64) bool { return p.features&f == f }
+
+func TestAddrV2Validated(t *testing.T) {
+	a, v1 := &testPeer{id: 1}, &testPeer{id: 2}
+	v2 := &featurePeer{testPeer: testPeer{id: 3}, features: node.FeatureAddrV2}
+	var reported []ban.Reason
+	e := New(Config{Seed: 1, Misbehaving: func(peer uint64, r ban.Reason) { reported = append(reported, r) }})
+	e.AddPeer(a)
+	e.AddPeer(v1)
+	e.AddPeer(v2)
+	key := testutil.NewKey(t)
+	onion := node.AddressV2Msg{
+		Time:    dnet.DogeNow(),
+		Address: node.NetAddr{Network: node.NetTorV3, Addr: bytes.Repeat([]byte{7}, 32)},
+		Port:    42069,
+		Owner:   make([]byte, 32),
+	}
+	if !e.HandleMessage(a.id, newMessage(t, key, node.ChannelNode, node.TagAddrV2, onion.Encode())) {
+		t.Fatal("onion address was dropped")
+	}
+	if v1.count() != 0 || v2.count() != 1 {
+		t.Fatalf("relayed to v1 %d, v2 %d", v1.count(), v2.count())
+	}
+
+	// a Tor address of the wrong length
+	onion.Address.Addr = onion.Address.Addr[:16]
+	if e.HandleMessage(a.id, newMessage(t, key, node.ChannelNode, node.TagAddrV2, onion.Encode())) {
+		t.Fatal("invalid address was accepted")
+	}
+	// a private IP
+	onion.Address = node.NetAddrFromIP([]byte{10, 0, 0, 1})
+	if e.HandleMessage(a.id, newMessage(t, key, node.ChannelNode, node.TagAddrV2, onion.Encode())) {
+		t.Fatal("unroutable address was accepted")
+	}
+	if len(reported) != 1 || reported[0] != ban.ReasonDecode || v2.count() != 1 {
+		t.Fatalf("reported %v, relayed %d", reported, v2.count())
+	}
+}
+
+func TestDecodeErrorReported(t *testing.T) {
+	a, b := &testPeer{id: 1}, &testPeer{id: 2}
+	var failed []dnet.Tag4CC
+	var reported []ban.Reason
+	e := newEngine(Config{
+		OnDecodeError: func(from uint64, msg dnet.Message) { failed = append(failed, msg.Tag) },
+		Misbehaving:   func(peer uint64, r ban.Reason) { reported = append(reported, r) },
+	}, a, b)
+	key := testutil.NewKey(t)
+	if e.HandleMessage(a.id, newMessage(t, key, node.ChannelNode, node.TagAddress, []byte{1, 2, 3})) {
+		t.Fatal("truncated address was accepted")
+	}
+	e.HandleMessage(a.id, newMessage(t, key, node.ChannelNode, node.TagInv, []byte{0xff}))
+	if len(failed) != 2 || failed[0] != node.TagAddress || failed[1] != node.TagInv {
+		t.Fatalf("decode errors %v", failed)
+	}
+	if len(reported) != 2 || reported[0] != ban.ReasonDecode || b.count() != 0 {
+		t.Fatalf("reported %v, relayed %d", reported, b.count())
+	}
+}
@@ -0,0 +1,237 @@
+package relay
+
+import (
+	"time"
+
+	"code.dogecoin.org/gossip/ban"
+	"code.dogecoin.org/gossip/codec"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+)
+
+// maxAlternates is the most peers remembered per request to retry with.
+const maxAlternates = 8
+
+// request is a message we have asked a peer for.
+type request struct {
+	peer       uint64
+	deadline   time.Time
+	alternates []uint64 // other peers that announced the message
+}
+
+// hasPeer reports whether the request was sent to, or can be retried with, a peer.
+func (req *request) hasPeer(id uint64) bool {
+	if req.peer == id {
+		return true
+	}
+	for _, a := range req.alternates {
+		if a == id {
+			return true
+		}
+	}
+	return false
+}
+
+// outgoing is an inventory message to sign and send after e.mu is released.
+type outgoing struct {
+	ps  *peerState
+	tag dnet.Tag4CC
+	ids []dnet.MsgID
+}
+
+// Tick flushes batched announcements, retries requests that have timed
+// out with another peer, and expires cached messages.
+// Called periodically by Run; it can also be driven by a simulated clock.
+func (e *Engine) Tick(now time.Time) {
+	var out []outgoing
+	e.mu.Lock()
+	for _, ps := range e.peers {
+		for len(ps.inv) > 0 {
+			n := len(ps.inv)
+			if n > node.MaxInvItems {
+				n = node.MaxInvItems
+			}
+			out = append(out, outgoing{ps: ps, tag: node.TagInv, ids: ps.inv[:n:n]})
+			ps.inv = ps.inv[n:]
+		}
+		ps.inv = nil
+	}
+	retry := make(map[uint64][]dnet.MsgID)
+	for id, req := range e.inflight {
+		if now.Before(req.deadline) {
+			continue
+		}
+		if ps := e.peers[req.peer]; ps != nil {
+			ps.timeouts++
+		}
+		next, ok := e.nextAlternate(req)
+		if !ok {
+			e.finishRequest(id, req)
+			continue
+		}
+		if ps := e.peers[req.peer]; ps != nil {
+			ps.inflight--
+		}
+		e.peers[next].inflight++
+		req.peer = next
+		req.deadline = now.Add(e.cfg.RequestTimeout)
+		retry[next] = append(retry[next], id)
+	}
+	for pid, ids := range retry {
+		out = append(out, e.batchRequests(e.peers[pid], ids)...)
+	}
+	e.expireCache(now)
+	e.mu.Unlock()
+	e.sendInv(out)
+}
+
+// handleInv requests announced messages we have not seen.
+func (e *Engine) handleInv(from uint64, msg dnet.Message) {
+	var inv node.InvMsg
+	if !codec.TryDecode(func() { inv = node.DecodeInvMsg(msg.Payload) }) {
+		e.decodeFailed(from, msg)
+		return
+	}
+	now := e.cfg.Clock.Now()
+	e.mu.Lock()
+	ps := e.peers[from]
+	if ps == nil {
+		e.mu.Unlock()
+		return
+	}
+	var want []dnet.MsgID
+	for _, id := range inv.IDs {
+		if _, seen := e.seen[id]; seen {
+			continue
+		}
+		if req := e.inflight[id]; req != nil {
+			if len(req.alternates) < maxAlternates && !req.hasPeer(from) {
+				req.alternates = append(req.alternates, from)
+			}
+			continue
+		}
+		if len(e.inflight) >= e.cfg.MaxInflight || ps.inflight >= e.cfg.MaxPeerInflight {
+			continue // not requested; a later announcement can ask again
+		}
+		e.inflight[id] = &request{peer: from, deadline: now.Add(e.cfg.RequestTimeout)}
+		ps.inflight++
+		want = append(want, id)
+	}
+	out := e.batchRequests(ps, want)
+	e.mu.Unlock()
+	e.sendInv(out)
+}
+
+// handleGetMsgs sends the requested messages we still have cached.
+func (e *Engine) handleGetMsgs(from uint64, msg dnet.Message) {
+	var req node.InvMsg
+	if !codec.TryDecode(func() { req = node.DecodeInvMsg(msg.Payload) }) {
+		e.decodeFailed(from, msg)
+		return
+	}
+	e.mu.Lock()
+	ps := e.peers[from]
+	if ps == nil {
+		e.mu.Unlock()
+		return
+	}
+	found := make([]dnet.RawMessage, 0, len(req.IDs))
+	for _, id := range req.IDs {
+		if raw, ok := e.cache[id]; ok && supports(ps.peer, e.requires(raw)) {
+			found = append(found, raw)
+		}
+	}
+	e.mu.Unlock()
+	for _, raw := range found {
+		ok := ps.peer.TrySend(raw)
+		e.mu.Lock()
+		if ok {
+			ps.relayed++
+		} else {
+			ps.dropped++
+		}
+		e.mu.Unlock()
+	}
+}
+
+// requires returns the features a peer needs to be sent a message.
+func (e *Engine) requires(raw dnet.RawMessage) uint64 {
+	_, tag := dnet.MsgView(raw.Header).ChanTag()
+	return e.cfg.Requires[tag]
+}
+
+// nextAlternate picks the next connected peer that announced the message
+// and has room for another request; e.mu must be held.
+func (e *Engine) nextAlternate(req *request) (uint64, bool) {
+	for len(req.alternates) > 0 {
+		next := req.alternates[0]
+		req.alternates = req.alternates[1:]
+		if ps, ok := e.peers[next]; ok && ps.inflight < e.cfg.MaxPeerInflight {
+			return next, true
+		}
+	}
+	return 0, false
+}
+
+// finishRequest forgets a request that was answered or gave up; e.mu must be held.
+func (e *Engine) finishRequest(id dnet.MsgID, req *request) {
+	if ps := e.peers[req.peer]; ps != nil {
+		ps.inflight--
+	}
+	delete(e.inflight, id)
+}
+
+func (e *Engine) batchRequests(ps *peerState, ids []dnet.MsgID) (out []outgoing) {
+	if ps == nil {
+		return nil
+	}
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > node.MaxInvItems {
+			n = node.MaxInvItems
+		}
+		out = append(out, outgoing{ps: ps, tag: node.TagGetMsgs, ids: ids[:n:n]})
+		ids = ids[n:]
+	}
+	return out
+}
+
+func (e *Engine) sendInv(out []outgoing) {
+	for _, o := range out {
+		payload := node.InvMsg{IDs: o.ids}.Encode()
+		o.ps.peer.TrySend(dnet.EncodeMessageRaw(node.ChannelNode, o.tag, e.cfg.Key, payload))
+	}
+}
+
+// addCache keeps an announced message to serve requests; e.mu must be held.
+func (e *Engine) addCache(id dnet.MsgID, raw dnet.RawMessage, now time.Time) {
+	e.cache[id] = raw
+	e.cached = append(e.cached, seenEntry{id: id, at: now})
+}
+
+// expireCache drops cached messages older than CacheTTL; e.mu must be held.
+func (e *Engine) expireCache(now time.Time) {
+	cutoff := now.Add(-e.cfg.CacheTTL)
+	n := 0
+	for n < len(e.cached) && e.cached[n].at.Before(cutoff) {
+		delete(e.cache, e.cached[n].id)
+		n++
+	}
+	if n > 0 {
+		e.cached = append(e.cached[:0], e.cached[n:]...)
+	}
+}
+
+func (e *Engine) misbehaving(peer uint64, reason ban.Reason) {
+	if e.cfg.Misbehaving != nil {
+		e.cfg.Misbehaving(peer, reason)
+	}
+}
+
+// decodeFailed reports a node message whose payload did not decode.
+func (e *Engine) decodeFailed(from uint64, msg dnet.Message) {
+	if e.cfg.OnDecodeError != nil {
+		e.cfg.OnDecodeError(from, msg)
+	}
+	e.misbehaving(from, ban.ReasonDecode)
+}
@@ -0,0 +1,248 @@
+package relay
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/ban"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/internal/testutil"
+	"code.dogecoin.org/gossip/node"
+)
+
+// messages decodes the frames queued to a peer.
+func (p *testPeer) messages(t *testing.T) []dnet.Message {
+	t.Helper()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var out []dnet.Message
+	for _, raw := range p.sent {
+		var buf bytes.Buffer
+		if err := raw.Send(&buf); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := dnet.ReadMessage(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, msg)
+	}
+	return out
+}
+
+// invIDs returns the IDs in the inventory messages with a tag queued to a peer.
+func invIDs(t *testing.T, p *testPeer, tag dnet.Tag4CC) (ids []dnet.MsgID) {
+	t.Helper()
+	for _, msg := range p.messages(t) {
+		if msg.Chan == node.ChannelNode && msg.Tag == tag {
+			ids = append(ids, node.DecodeInvMsg(msg.Payload).IDs...)
+		}
+	}
+	return ids
+}
+
+func newInvEngine(t *testing.T, clock *testutil.Clock, peers ...*testPeer) *Engine {
+	return newEngine(Config{
+		Policies: map[dnet.Tag4CC]Policy{testChan: {Relay: true, Announce: true}},
+		Key:      testutil.NewKey(t),
+		Clock:    clock,
+	}, peers...)
+}
+
+func TestAnnounceAndServe(t *testing.T) {
+	clock := testutil.NewClock()
+	a, b := &testPeer{id: 1}, &testPeer{id: 2}
+	e := newInvEngine(t, clock, a, b)
+	msg := newMessage(t, testutil.NewKey(t), testChan, testTag, []byte("payload"))
+	if !e.HandleMessage(a.id, msg) {
+		t.Fatal("new message was dropped")
+	}
+	if b.count() != 0 {
+		t.Fatal("payload pushed before announcing")
+	}
+	e.Tick(clock.Now())
+	ids := invIDs(t, b, node.TagInv)
+	if len(ids) != 1 || ids[0] != msg.ID() {
+		t.Fatalf("announced %v", ids)
+	}
+	if len(invIDs(t, a, node.TagInv)) != 0 {
+		t.Fatal("announced back to the sender")
+	}
+
+	req := newMessage(t, testutil.NewKey(t), node.ChannelNode, node.TagGetMsgs, node.InvMsg{IDs: ids}.Encode())
+	e.HandleMessage(b.id, req)
+	got := b.messages(t)
+	last := got[len(got)-1]
+	if last.Chan != testChan || string(last.Payload) != "payload" || !bytes.Equal(last.PubKey, msg.PubKey) {
+		t.Fatalf("served %v %q", last.Tag, last.Payload)
+	}
+
+	clock.Advance(time.Hour)
+	e.Tick(clock.Now())
+	before := b.count()
+	e.HandleMessage(b.id, newMessage(t, testutil.NewKey(t), node.ChannelNode, node.TagGetMsgs, node.InvMsg{IDs: ids}.Encode()))
+	if b.count() != before {
+		t.Fatal("served a message after CacheTTL")
+	}
+}
+
+func TestRequestRetriesAlternate(t *testing.T) {
+	clock := testutil.NewClock()
+	a, b := &testPeer{id: 1}, &testPeer{id: 2}
+	e := newInvEngine(t, clock, a, b)
+	id := newMessage(t, testutil.NewKey(t), testChan, testTag, []byte("x")).ID()
+	inv := node.InvMsg{IDs: []dnet.MsgID{id}}.Encode()
+
+	e.HandleMessage(a.id, newMessage(t, testutil.NewKey(t), node.ChannelNode, node.TagInv, inv))
+	e.HandleMessage(b.id, newMessage(t, testutil.NewKey(t), node.ChannelNode, node.TagInv, inv))
+	if got := invIDs(t, a, node.TagGetMsgs); len(got) != 1 || got[0] != id {
+		t.Fatalf("requested from a: %v", got)
+	}
+	if len(invIDs(t, b, node.TagGetMsgs)) != 0 {
+		t.Fatal("requested twice before the timeout")
+	}
+
+	clock.Advance(10 * time.Second)
+	e.Tick(clock.Now())
+	if got := invIDs(t, b, node.TagGetMsgs); len(got) != 1 || got[0] != id {
+		t.Fatalf("retry from b: %v", got)
+	}
+	for _, s := range e.Stats() {
+		if s.Peer == a.id && s.Timeouts != 1 {
+			t.Fatalf("a timeouts: %d", s.Timeouts)
+		}
+	}
+}
+
+func TestInvSkipsSeen(t *testing.T) {
+	clock := testutil.NewClock()
+	a, b := &testPeer{id: 1}, &testPeer{id: 2}
+	e := newInvEngine(t, clock, a, b)
+	msg := newMessage(t, testutil.NewKey(t), testChan, testTag, []byte("x"))
+	e.HandleMessage(b.id, msg)
+	inv := node.InvMsg{IDs: []dnet.MsgID{msg.ID()}}.Encode()
+	e.HandleMessage(a.id, newMessage(t, testutil.NewKey(t), node.ChannelNode, node.TagInv, inv))
+	if len(invIDs(t, a, node.TagGetMsgs)) != 0 {
+		t.Fatal("requested a message already seen")
+	}
+}
+
+func TestInvBatching(t *testing.T) {
+	clock := testutil.NewClock()
+	a, b := &testPeer{id: 1}, &testPeer{id: 2}
+	e := newInvEngine(t, clock, a, b)
+	key := testutil.NewKey(t)
+	for i := 0; i < node.MaxInvItems+1; i++ {
+		e.Publish(newMessage(t, key, testChan, testTag, []byte{byte(i), byte(i >> 8)}))
+	}
+	e.Tick(clock.Now())
+	if n := len(b.messages(t)); n != 2 {
+		t.Fatalf("sent %d inventory messages, want 2", n)
+	}
+	if n := len(invIDs(t, b, node.TagInv)); n != node.MaxInvItems+1 {
+		t.Fatalf("announced %d IDs", n)
+	}
+}
+
+func TestMalformedInvReported(t *testing.T) {
+	a := &testPeer{id: 1}
+	var reported []uint64
+	e := newEngine(Config{Misbehaving: func(peer uint64, _ ban.Reason) { reported = append(reported, peer) }}, a)
+	e.HandleMessage(a.id, newMessage(t, testutil.NewKey(t), node.ChannelNode, node.TagInv, []byte{5}))
+	if len(reported) != 1 || reported[0] != a.id {
+		t.Fatalf("reported %v", reported)
+	}
+}
+
+// fakeIDs returns n distinct message IDs.
+func fakeIDs(n int, fill byte) []dnet.MsgID {
+	ids := make([]dnet.MsgID, n)
+	for i := range ids {
+		ids[i][0] = fill
+		ids[i][1] = byte(i)
+		ids[i][2] = byte(i >> 8)
+	}
+	return ids
+}
+
+func TestInflightLimits(t *testing.T) {
+	clock := testutil.NewClock()
+	a, b := &testPeer{id: 1}, &testPeer{id: 2}
+	e := newEngine(Config{
+		Policies:        map[dnet.Tag4CC]Policy{testChan: {Relay: true, Announce: true}},
+		Key:             testutil.NewKey(t),
+		Clock:           clock,
+		MaxInflight:     15,
+		MaxPeerInflight: 10,
+	}, a, b)
+	key := testutil.NewKey(t)
+	inv := func(from *testPeer, ids []dnet.MsgID) {
+		e.HandleMessage(from.id, newMessage(t, key, node.ChannelNode, node.TagInv, node.InvMsg{IDs: ids}.Encode()))
+	}
+	inv(a, fakeIDs(20, 1))
+	if n := len(invIDs(t, a, node.TagGetMsgs)); n != 10 {
+		t.Fatalf("requested %d from a, want the per-peer limit", n)
+	}
+	inv(b, fakeIDs(20, 2))
+	if n := len(invIDs(t, b, node.TagGetMsgs)); n != 5 {
+		t.Fatalf("requested %d from b, want what is left of the total", n)
+	}
+	if len(e.inflight) != 15 {
+		t.Fatalf("%d in flight", len(e.inflight))
+	}
+	// requests that time out with no alternate free their slots
+	clock.Advance(time.Minute)
+	e.Tick(clock.Now())
+	if len(e.inflight) != 0 || e.peers[a.id].inflight != 0 || e.peers[b.id].inflight != 0 {
+		t.Fatalf("after timeout: %d in flight, a %d, b %d", len(e.inflight), e.peers[a.id].inflight, e.peers[b.id].inflight)
+	}
+	inv(a, fakeIDs(20, 3))
+	if n := len(invIDs(t, a, node.TagGetMsgs)); n != 20 {
+		t.Fatalf("requested %d from a in total", n)
+	}
+}
+
+func TestInflightAnswered(t *testing.T) {
+	clock := testutil.NewClock()
+	a := &testPeer{id: 1}
+	e := newInvEngine(t, clock, a)
+	msg := newMessage(t, testutil.NewKey(t), testChan, testTag, []byte("payload"))
+	e.HandleMessage(a.id, newMessage(t, testutil.NewKey(t), node.ChannelNode, node.TagInv, node.InvMsg{IDs: []dnet.MsgID{msg.ID()}}.Encode()))
+	if e.peers[a.id].inflight != 1 {
+		t.Fatal("request not counted")
+	}
+	e.HandleMessage(a.id, msg)
+	if len(e.inflight) != 0 || e.peers[a.id].inflight != 0 {
+		t.Fatal("answered request still counted")
+	}
+}
+
+func TestAlternatesDeduplicated(t *testing.T) {
+	clock := testutil.NewClock()
+	peers := []*testPeer{{id: 1}}
+	for i := uint64(2); i <= 20; i++ {
+		peers = append(peers, &testPeer{id: i})
+	}
+	e := newInvEngine(t, clock, peers...)
+	key := testutil.NewKey(t)
+	id := fakeIDs(1, 9)
+	announce := func(from *testPeer) {
+		e.HandleMessage(from.id, newMessage(t, key, node.ChannelNode, node.TagInv, node.InvMsg{IDs: id}.Encode()))
+	}
+	announce(peers[0])
+	for i := 0; i < 5; i++ {
+		announce(peers[0])
+		announce(peers[1])
+	}
+	req := e.inflight[id[0]]
+	if len(req.alternates) != 1 || req.alternates[0] != peers[1].id {
+		t.Fatalf("alternates %v", req.alternates)
+	}
+	for _, p := range peers[2:] {
+		announce(p)
+	}
+	if len(req.alternates) != maxAlternates {
+		t.Fatalf("%d alternates", len(req.alternates))
+	}
+}
@@ -0,0 +1,24 @@
+package sim
+
+import (
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/internal/testutil"
+)
+
+// VirtualClock is a dnet.Clock that only moves when advanced.
+type VirtualClock struct {
+	testutil.Clock
+}
+
+func NewVirtualClock(start time.Time) *VirtualClock {
+	c := &VirtualClock{}
+	c.Set(start)
+	return c
+}
+
+// DogeNow returns the virtual time as DogeTime.
+func (c *VirtualClock) DogeNow() dnet.DogeTime {
+	return dnet.DogeNowAt(c)
+}
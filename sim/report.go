@@ -0,0 +1,84 @@
+package sim
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// Report summarises propagation of every injected message.
+type Report struct {
+	Nodes    int
+	Messages []MessageReport
+	Sent     uint64 // messages sent on links (including inventory)
+	Bytes    uint64 // bytes sent on links
+	Lost     uint64 // messages lost on links
+}
+
+type MessageReport struct {
+	Propagation *Propagation
+	Coverage    float64       // fraction of nodes reached
+	T50         time.Duration // time to reach 50% of nodes (-1 if never)
+	T90         time.Duration // time to reach 90% of nodes (-1 if never)
+	T100        time.Duration // time to reach all nodes (-1 if never)
+}
+
+func (n *Network) Report() Report {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	r := Report{Nodes: len(n.Nodes), Sent: n.sent, Bytes: n.bytes, Lost: n.lost}
+	for _, id := range n.order {
+		p := n.msgs[id]
+		r.Messages = append(r.Messages, MessageReport{
+			Propagation: p,
+			Coverage:    p.Coverage(len(n.Nodes)),
+			T50:         timeTo(p, 0.5, len(n.Nodes)),
+			T90:         timeTo(p, 0.9, len(n.Nodes)),
+			T100:        timeTo(p, 1.0, len(n.Nodes)),
+		})
+	}
+	return r
+}
+
+func timeTo(p *Propagation, fraction float64, nodes int) time.Duration {
+	if d, ok := p.TimeTo(fraction, nodes); ok {
+		return d
+	}
+	return -1
+}
+
+// MeanCoverage is the average fraction of nodes reached per message.
+func (r Report) MeanCoverage() float64 {
+	if len(r.Messages) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, m := range r.Messages {
+		sum += m.Coverage
+	}
+	return sum / float64(len(r.Messages))
+}
+
+// Print writes a human-readable report.
+func (r Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "nodes: %d  messages: %d  link sends: %d  bytes: %d  lost: %d\n",
+		r.Nodes, len(r.Messages), r.Sent, r.Bytes, r.Lost)
+	fmt.Fprintf(w, "%-18s %9s %10s %10s %10s\n", "message", "coverage", "t50", "t90", "t100")
+	for _, m := range r.Messages {
+		fmt.Fprintf(w, "%-18s %8.1f%% %10s %10s %10s\n", m.Propagation,
+			m.Coverage*100, fmtDuration(m.T50), fmtDuration(m.T90), fmtDuration(m.T100))
+	}
+	fmt.Fprintf(w, "mean coverage: %.1f%%\n", r.MeanCoverage()*100)
+}
+
+func fmtDuration(d time.Duration) string {
+	if d < 0 {
+		return "-"
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+func sortDurations(d []time.Duration) {
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+}
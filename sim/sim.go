@@ -0,0 +1,413 @@
+package sim
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/peer"
+	"code.dogecoin.org/gossip/ratelimit"
+	"code.dogecoin.org/gossip/relay"
+)
+
+// Config describes a simulated network.
+type Config struct {
+	Nodes        int                          // number of nodes (default 100)
+	Degree       int                          // outbound links per node (default 8)
+	Latency      time.Duration                // one-way link latency (default 50ms)
+	Jitter       time.Duration                // random extra latency per message, up to this much
+	Loss         float64                      // probability a message is lost in transit (0-1)
+	Policies     map[dnet.Tag4CC]relay.Policy // relay policy per channel
+	Default      *relay.Policy                // relay policy for other channels
+	Limits       *ratelimit.Config            // optional; rate limits on every node
+	TickInterval time.Duration                // relay Tick interval (default 100ms)
+	Seed         int64                        // random seed (default 1)
+	Start        time.Time                    // virtual start time (default now)
+}
+
+// Network is an in-process network of nodes, each a peer.Manager and a
+// relay.Engine, joined by net.Pipe connections. Messages go through the
+// real framing, handshake and signature checks (dnet.ReadMessage);
+// only the time a frame spends on the wire is simulated.
+//
+// Link latency and the relay engines run on a virtual clock. RunUntil
+// delivers each frame at its virtual arrival time, and only moves the
+// clock on once every node has finished handling the frames delivered
+// so far, so results do not depend on real time or machine load.
+// Version, VerAck, Ping and Pong frames are passed straight through.
+type Network struct {
+	cfg   Config
+	Clock *VirtualClock
+	Nodes []*Node
+	ctx   context.Context
+	stop  context.CancelFunc
+
+	mu     sync.Mutex
+	idle   *sync.Cond // signalled when busy reaches zero
+	busy   int        // frames queued or delivered but not yet handled
+	rand   *rand.Rand
+	events eventQueue
+	seq    uint64
+	msgs   map[dnet.MsgID]*Propagation
+	order  []dnet.MsgID
+	links  []*link
+	sent   uint64 // messages sent on links
+	bytes  uint64 // bytes sent on links
+	lost   uint64 // messages lost on links
+	linked sync.WaitGroup
+}
+
+// Node is a simulated node.
+type Node struct {
+	Index    int
+	Key      dnet.KeyPair
+	Addr     dnet.Address
+	Engine   *relay.Engine
+	Manager  *peer.Manager
+	net      *Network
+	listener *listener
+}
+
+// relayPeer counts messages the relay engine queues to a peer, until
+// they reach the wire.
+type relayPeer struct {
+	*peer.Peer
+	net *Network
+}
+
+// Propagation tracks how one message spread through the network.
+type Propagation struct {
+	ID      dnet.MsgID
+	Origin  int
+	Sent    time.Time
+	Reached map[int]time.Time // node index -> first delivery time
+}
+
+const sendQueue = 4096
+
+func New(cfg Config) (*Network, error) {
+	if cfg.Nodes <= 0 {
+		cfg.Nodes = 100
+	}
+	if cfg.Degree <= 0 {
+		cfg.Degree = 8
+	}
+	if cfg.Degree >= cfg.Nodes {
+		cfg.Degree = cfg.Nodes - 1
+	}
+	if cfg.Latency <= 0 {
+		cfg.Latency = 50 * time.Millisecond
+	}
+	if cfg.TickInterval <= 0 {
+		cfg.TickInterval = 100 * time.Millisecond
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = 1
+	}
+	if cfg.Start.IsZero() {
+		cfg.Start = time.Now()
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	n := &Network{
+		cfg:   cfg,
+		Clock: NewVirtualClock(cfg.Start),
+		ctx:   ctx,
+		stop:  stop,
+		rand:  rand.New(rand.NewSource(cfg.Seed)),
+		msgs:  make(map[dnet.MsgID]*Propagation),
+	}
+	n.idle = sync.NewCond(&n.mu)
+	for i := 0; i < cfg.Nodes; i++ {
+		if err := n.addNode(i); err != nil {
+			n.Close()
+			return nil, err
+		}
+	}
+	if err := n.connect(); err != nil {
+		n.Close()
+		return nil, err
+	}
+	for _, node := range n.Nodes {
+		n.scheduleTick(node, n.Clock.Now().Add(time.Duration(n.rand.Int63n(int64(cfg.TickInterval)))))
+	}
+	return n, nil
+}
+
+func (n *Network) addNode(i int) error {
+	key, err := dnet.GenerateKeyPair()
+	if err != nil {
+		return err
+	}
+	node := &Node{
+		Index: i,
+		Key:   key,
+		Addr:  dnet.AddressFromIP([]byte{10, byte(i >> 16), byte(i >> 8), byte(i)}, dnet.DogeNetDefaultPort),
+		net:   n,
+	}
+	rcfg := relay.Config{
+		Policies: n.cfg.Policies,
+		Default:  n.cfg.Default,
+		Key:      key,
+		Clock:    n.Clock,
+		Seed:     n.rand.Int63() + 1,
+		Deliver:  node.deliver,
+	}
+	if n.cfg.Limits != nil {
+		limits := *n.cfg.Limits
+		limits.Clock = n.Clock
+		rcfg.Limiter = ratelimit.New(limits)
+	}
+	node.Engine = relay.New(rcfg)
+	node.Manager = peer.NewManager(peer.Config{
+		MaxInbound:   n.cfg.Nodes,
+		MaxOutbound:  n.cfg.Nodes,
+		SendQueue:    sendQueue,
+		Dial:         n.dialer(node),
+		Key:          key,
+		PingInterval: time.Hour,
+		PingTimeout:  time.Hour,
+		OnConnect: func(p *peer.Peer) {
+			node.Engine.AddPeer(&relayPeer{Peer: p, net: n})
+			n.linked.Done()
+		},
+		OnMessage: func(p *peer.Peer, msg dnet.Message) {
+			node.Engine.HandleMessage(p.ID(), msg)
+			n.done()
+		},
+		OnDisconnect: func(p *peer.Peer, err error) {
+			node.Engine.RemovePeer(p.ID())
+		},
+	})
+	node.listener = newListener(node.Addr)
+	if err := node.Manager.Serve(node.listener); err != nil {
+		return err
+	}
+	n.Nodes = append(n.Nodes, node)
+	return nil
+}
+
+// connect links each node to Degree random other nodes.
+func (n *Network) connect() error {
+	linked := make(map[[2]int]bool)
+	for _, a := range n.Nodes {
+		links := 0
+		for added := 0; added < n.cfg.Degree; {
+			b := n.Nodes[n.rand.Intn(len(n.Nodes))]
+			key := [2]int{a.Index, b.Index}
+			if b.Index < a.Index {
+				key = [2]int{b.Index, a.Index}
+			}
+			if b == a || linked[key] {
+				if links >= len(n.Nodes)-1 {
+					break
+				}
+				continue
+			}
+			linked[key] = true
+			if err := n.Link(a, b); err != nil {
+				return err
+			}
+			added++
+			links++
+		}
+	}
+	return nil
+}
+
+// Link connects node a to node b (an outbound connection from a) and
+// waits until both relay engines have added the peer.
+func (n *Network) Link(a *Node, b *Node) error {
+	n.linked.Add(2)
+	if _, err := a.Manager.Connect(b.Addr); err != nil {
+		n.linked.Add(-2)
+		return fmt.Errorf("sim: link %d to %d: %w", a.Index, b.Index, err)
+	}
+	n.linked.Wait()
+	return nil
+}
+
+// TrySend queues a message to the peer, counting it until it reaches the wire.
+func (p *relayPeer) TrySend(msg dnet.RawMessage) bool {
+	p.net.add()
+	if !p.Peer.TrySend(msg) {
+		p.net.done()
+		return false
+	}
+	return true
+}
+
+func (node *Node) deliver(msg dnet.Message) {
+	n := node.net
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if p := n.msgs[msg.ID()]; p != nil {
+		if _, ok := p.Reached[node.Index]; !ok {
+			p.Reached[node.Index] = n.Clock.Now()
+		}
+	}
+}
+
+// Inject signs a message with a node's key and publishes it from that node.
+func (n *Network) Inject(origin int, channel dnet.Tag4CC, tag dnet.Tag4CC, payload []byte) dnet.MsgID {
+	node := n.Nodes[origin]
+	raw := dnet.EncodeMessageRaw(channel, tag, node.Key, payload)
+	msg := dnet.DecodeHeader(raw.Header)
+	msg.Payload = raw.Payload
+	id := msg.ID()
+	now := n.Clock.Now()
+	n.mu.Lock()
+	n.msgs[id] = &Propagation{ID: id, Origin: origin, Sent: now, Reached: map[int]time.Time{origin: now}}
+	n.order = append(n.order, id)
+	n.mu.Unlock()
+	node.Engine.Publish(msg)
+	return id
+}
+
+// RunFor processes events for d of virtual time.
+func (n *Network) RunFor(d time.Duration) {
+	n.RunUntil(n.Clock.Now().Add(d))
+}
+
+// RunUntil processes events up to virtual time t.
+// It must not be called concurrently.
+func (n *Network) RunUntil(t time.Time) {
+	n.mu.Lock()
+	for {
+		for n.busy > 0 {
+			n.idle.Wait()
+		}
+		if len(n.events) == 0 || n.events[0].at.After(t) {
+			break
+		}
+		ev := heap.Pop(&n.events).(*event)
+		n.mu.Unlock()
+		n.Clock.Set(ev.at)
+		ev.fn()
+		n.mu.Lock()
+	}
+	n.mu.Unlock()
+	n.Clock.Set(t)
+}
+
+// Close disconnects every node.
+func (n *Network) Close() {
+	n.stop()
+	var wg sync.WaitGroup
+	for _, node := range n.Nodes {
+		wg.Add(1)
+		go func(m *peer.Manager) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			m.Close(ctx)
+		}(node.Manager)
+	}
+	wg.Wait()
+	n.mu.Lock()
+	links := n.links
+	n.links = nil
+	n.mu.Unlock()
+	for _, l := range links {
+		l.close()
+	}
+}
+
+// Propagation returns the propagation record for a message.
+func (n *Network) Propagation(id dnet.MsgID) *Propagation {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.msgs[id]
+}
+
+func (n *Network) scheduleTick(node *Node, at time.Time) {
+	n.schedule(at, func() {
+		node.Engine.Tick(n.Clock.Now())
+		n.scheduleTick(node, at.Add(n.cfg.TickInterval))
+	})
+}
+
+func (n *Network) schedule(at time.Time, fn func()) {
+	n.mu.Lock()
+	n.push(at, fn)
+	n.mu.Unlock()
+}
+
+// push adds an event; n.mu must be held.
+func (n *Network) push(at time.Time, fn func()) {
+	n.seq++
+	heap.Push(&n.events, &event{at: at, seq: n.seq, fn: fn})
+}
+
+// add counts a frame in progress.
+func (n *Network) add() {
+	n.mu.Lock()
+	n.busy++
+	n.mu.Unlock()
+}
+
+// done counts a frame handled, waking RunUntil when none are left.
+func (n *Network) done() {
+	n.mu.Lock()
+	n.busy--
+	if n.busy == 0 {
+		n.idle.Broadcast()
+	}
+	n.mu.Unlock()
+}
+
+// Coverage is the fraction of nodes a message reached.
+func (p *Propagation) Coverage(nodes int) float64 {
+	return float64(len(p.Reached)) / float64(nodes)
+}
+
+// TimeTo returns how long the message took to reach a fraction of the
+// nodes, or false if it never did.
+func (p *Propagation) TimeTo(fraction float64, nodes int) (time.Duration, bool) {
+	need := int(fraction*float64(nodes) + 0.999999)
+	if need < 1 {
+		need = 1
+	}
+	if len(p.Reached) < need {
+		return 0, false
+	}
+	times := make([]time.Duration, 0, len(p.Reached))
+	for _, t := range p.Reached {
+		times = append(times, t.Sub(p.Sent))
+	}
+	sortDurations(times)
+	return times[need-1], true
+}
+
+func (p *Propagation) String() string {
+	return fmt.Sprintf("%x from %d", p.ID[:4], p.Origin)
+}
+
+// event queue ordered by time, then by scheduling order.
+
+type event struct {
+	at  time.Time
+	seq uint64
+	fn  func()
+}
+
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+func (q eventQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	ev := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return ev
+}
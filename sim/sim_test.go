@@ -0,0 +1,109 @@
+package sim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/relay"
+)
+
+var testChan = dnet.NewTag("Test")
+var testTag = dnet.NewTag("Tst1")
+
+func newTestNetwork(t *testing.T, cfg Config) *Network {
+	t.Helper()
+	cfg.Start = time.Unix(1700000000, 0)
+	n, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(n.Close)
+	return n
+}
+
+func TestFloodPropagation(t *testing.T) {
+	n := newTestNetwork(t, Config{Nodes: 20, Degree: 3, Latency: 50 * time.Millisecond})
+	id := n.Inject(0, testChan, testTag, []byte("hello"))
+	n.RunFor(5 * time.Second)
+
+	p := n.Propagation(id)
+	if c := p.Coverage(len(n.Nodes)); c != 1 {
+		t.Fatalf("coverage %.2f, want every node", c)
+	}
+	t100, ok := p.TimeTo(1, len(n.Nodes))
+	if !ok || t100 < 50*time.Millisecond || t100%(50*time.Millisecond) != 0 {
+		t.Fatalf("t100 %v: every hop takes the link latency", t100)
+	}
+	if !n.Clock.Now().Equal(time.Unix(1700000005, 0)) {
+		t.Fatalf("clock at %v", n.Clock.Now())
+	}
+}
+
+func TestAnnouncePropagation(t *testing.T) {
+	n := newTestNetwork(t, Config{
+		Nodes:    20,
+		Degree:   3,
+		Policies: map[dnet.Tag4CC]relay.Policy{testChan: {Relay: true, Announce: true}},
+	})
+	ids := []dnet.MsgID{
+		n.Inject(0, testChan, testTag, []byte("one")),
+		n.Inject(5, testChan, testTag, []byte("two")),
+	}
+	n.RunFor(10 * time.Second)
+
+	r := n.Report()
+	if r.MeanCoverage() != 1 {
+		t.Fatalf("mean coverage %.2f", r.MeanCoverage())
+	}
+	// announce, request and payload: at least three latencies per hop
+	for _, id := range ids {
+		if t50, _ := n.Propagation(id).TimeTo(0.5, len(n.Nodes)); t50 < 150*time.Millisecond {
+			t.Fatalf("t50 %v", t50)
+		}
+	}
+	var out bytes.Buffer
+	r.Print(&out)
+	if !strings.Contains(out.String(), "mean coverage: 100.0%") {
+		t.Fatalf("report:\n%s", out.String())
+	}
+}
+
+func TestLoss(t *testing.T) {
+	n := newTestNetwork(t, Config{Nodes: 10, Degree: 2, Loss: 1})
+	id := n.Inject(3, testChan, testTag, []byte("gone"))
+	n.RunFor(time.Second)
+	r := n.Report()
+	if n.Propagation(id).Coverage(len(n.Nodes)) != 0.1 {
+		t.Fatal("message crossed a link with total loss")
+	}
+	if r.Lost == 0 || r.Lost != r.Sent {
+		t.Fatalf("lost %d of %d", r.Lost, r.Sent)
+	}
+}
+
+func TestDisconnectInFlight(t *testing.T) {
+	n := newTestNetwork(t, Config{Nodes: 10, Degree: 2, Latency: 50 * time.Millisecond})
+	id := n.Inject(0, testChan, testTag, []byte("cut"))
+	n.RunFor(10 * time.Millisecond) // the first hop is on the wire
+	for _, nd := range n.Nodes {
+		for _, p := range nd.Manager.Peers() {
+			p.Disconnect(nil)
+		}
+	}
+	ran := make(chan struct{})
+	go func() {
+		n.RunFor(time.Second)
+		close(ran)
+	}()
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunFor waits for frames dropped by closed links")
+	}
+	if c := n.Propagation(id).Coverage(len(n.Nodes)); c != 0.1 {
+		t.Fatalf("coverage %.2f after every link closed", c)
+	}
+}
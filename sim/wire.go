@@ -0,0 +1,240 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+)
+
+// listener accepts the simulated connections dialed to a node.
+type listener struct {
+	addr  *net.TCPAddr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newListener(addr dnet.Address) *listener {
+	return &listener{
+		addr:  net.TCPAddrFromAddrPort(addr.AddrPort()),
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
+
+// conn is one end of a net.Pipe that reports TCP addresses, so peers
+// see their remote's address.
+type conn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c conn) LocalAddr() net.Addr  { return c.local }
+func (c conn) RemoteAddr() net.Addr { return c.remote }
+
+// link is one direction of a simulated connection: a pump reads frames
+// the sender writes to in, and a writer passes them on to out at their
+// simulated arrival time.
+type link struct {
+	net    *Network
+	in     net.Conn
+	out    net.Conn
+	queue  chan frame
+	closed chan struct{}
+	once   sync.Once
+
+	mu      sync.Mutex
+	stopped bool
+	counted int // counted frames in queue
+}
+
+// frame is a frame on its way to the receiver; counted frames hold a
+// busy count until the receiving node has handled them.
+type frame struct {
+	msg     dnet.RawMessage
+	counted bool
+}
+
+// dialer returns a peer.DialFunc that connects from a node to the
+// listener of the node at address.
+func (n *Network) dialer(from *Node) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		var to *Node
+		for _, nd := range n.Nodes {
+			if nd.Addr.String() == address {
+				to = nd
+				break
+			}
+		}
+		if to == nil {
+			return nil, errors.New("sim: no node at " + address)
+		}
+		remote := to.listener.addr
+		local := &net.TCPAddr{IP: from.Addr.IP(), Port: 30000 + to.Index%30000}
+		dialed, a := net.Pipe()   // dialing node <-> wire
+		accepted, b := net.Pipe() // wire <-> accepting node
+		ab := n.newLink(a, b)
+		ba := n.newLink(b, a)
+		select {
+		case to.listener.conns <- conn{Conn: accepted, local: remote, remote: local}:
+		case <-to.listener.done:
+			ab.close()
+			ba.close()
+			return nil, net.ErrClosed
+		case <-ctx.Done():
+			ab.close()
+			ba.close()
+			return nil, ctx.Err()
+		}
+		return conn{Conn: dialed, local: local, remote: remote}, nil
+	}
+}
+
+func (n *Network) newLink(in net.Conn, out net.Conn) *link {
+	l := &link{net: n, in: in, out: out, queue: make(chan frame, sendQueue), closed: make(chan struct{})}
+	n.mu.Lock()
+	n.links = append(n.links, l)
+	n.mu.Unlock()
+	go l.pump()
+	go l.write()
+	return l
+}
+
+// pump reads frames from the sender and schedules their arrival.
+func (l *link) pump() {
+	for {
+		hdr := make([]byte, dnet.HeaderSize)
+		if _, err := io.ReadFull(l.in, hdr); err != nil {
+			l.close()
+			return
+		}
+		size := dnet.MsgView(hdr).Size()
+		if size > dnet.MaxMsgSize {
+			l.close()
+			return
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(l.in, payload); err != nil {
+			l.close()
+			return
+		}
+		l.route(dnet.RawMessage{Header: hdr, Payload: payload})
+	}
+}
+
+// route passes connection frames straight through and delays the rest
+// by the link latency, or loses them.
+func (l *link) route(msg dnet.RawMessage) {
+	channel, tag := dnet.MsgView(msg.Header).ChanTag()
+	if channel == node.ChannelNode && (tag == node.TagVersion || tag == node.TagVerAck || tag == node.TagPing || tag == node.TagPong) {
+		l.send(frame{msg: msg})
+		return
+	}
+	n := l.net
+	n.mu.Lock()
+	n.sent++
+	n.bytes += uint64(len(msg.Header) + len(msg.Payload))
+	if n.cfg.Loss > 0 && n.rand.Float64() < n.cfg.Loss {
+		n.lost++
+	} else {
+		delay := n.cfg.Latency
+		if n.cfg.Jitter > 0 {
+			delay += time.Duration(n.rand.Int63n(int64(n.cfg.Jitter)))
+		}
+		n.push(n.Clock.Now().Add(delay), func() {
+			n.add() // until the receiving node has handled it
+			l.send(frame{msg: msg, counted: true})
+		})
+	}
+	n.mu.Unlock()
+	n.done() // the relay's send has reached the wire
+}
+
+// send queues a frame for the writer. A counted frame that is
+// dropped because the link has closed is counted as handled.
+func (l *link) send(f frame) {
+	if f.counted {
+		l.mu.Lock()
+		if l.stopped {
+			l.mu.Unlock()
+			l.net.done()
+			return
+		}
+		l.counted++
+		l.mu.Unlock()
+	}
+	select {
+	case l.queue <- f:
+	case <-l.closed: // close has released the count
+	}
+}
+
+// write sends frames to the receiver in arrival order.
+func (l *link) write() {
+	for {
+		select {
+		case f := <-l.queue:
+			if f.counted && !l.take() {
+				return
+			}
+			if f.msg.Send(l.out) != nil && f.counted {
+				l.net.done() // the receiver will never handle it
+			}
+		case <-l.closed:
+			return
+		}
+	}
+}
+
+// take removes a counted frame from the queue, reporting false if close
+// has already released it.
+func (l *link) take() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		return false
+	}
+	l.counted--
+	return true
+}
+
+// close disconnects the link and releases the frames still queued.
+func (l *link) close() {
+	l.once.Do(func() {
+		l.mu.Lock()
+		l.stopped = true
+		dropped := l.counted
+		l.counted = 0
+		l.mu.Unlock()
+		for i := 0; i < dropped; i++ {
+			l.net.done()
+		}
+		close(l.closed)
+		l.in.Close()
+		l.out.Close()
+	})
+}
@@ -0,0 +1,283 @@
+// Package socks dials outbound peer connections through SOCKS5 proxies
+// (RFC 1928, with RFC 1929 username/password authentication.)
+package socks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.dogecoin.org/gossip/node"
+)
+
+// DialFunc matches peer.DialFunc and net.Dialer.DialContext.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// NetworkName is the "network" of a hostname that is not an IP or overlay address.
+const NetworkName node.NetworkID = 0
+
+type Config struct {
+	Proxy    string                    // default proxy "host:port" ("" to dial directly)
+	Networks map[node.NetworkID]string // per-network proxy, overriding Proxy ("" to dial directly)
+	Username string                    // optional credentials for the proxy
+	Password string
+	Isolate  bool          // use new random credentials per connection (Tor stream isolation)
+	LocalDNS bool          // resolve hostnames locally instead of through the proxy
+	Timeout  time.Duration // proxy handshake timeout (default 30s)
+	Forward  DialFunc      // dials the proxy or direct connections (default net.Dialer)
+	Resolver *net.Resolver // used with LocalDNS (default net.DefaultResolver)
+}
+
+var ErrNoProxy = errors.New("socks: no proxy configured for overlay network")
+var ErrAuth = errors.New("socks: proxy authentication failed")
+
+// Dialer dials through the proxy selected for the target's network.
+// Overlay addresses (".onion", ".b32.i2p") always need a proxy.
+// Hostnames are sent to the proxy to resolve unless LocalDNS is set.
+//
+// To reach overlay nodes, set DialContext as peer.Config.Dial and pass
+// addrman.Entry.HostPort to peer.Manager.ConnectHost, which hands the
+// name to the Dialer unresolved.
+type Dialer struct {
+	cfg Config
+}
+
+func New(cfg Config) *Dialer {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.Forward == nil {
+		d := &net.Dialer{}
+		cfg.Forward = d.DialContext
+	}
+	if cfg.Resolver == nil {
+		cfg.Resolver = net.DefaultResolver
+	}
+	return &Dialer{cfg: cfg}
+}
+
+// Network classifies a host as IPv4, IPv6, Tor v3, I2P or NetworkName.
+func Network(host string) node.NetworkID {
+	lower := strings.ToLower(host)
+	switch {
+	case strings.HasSuffix(lower, ".onion"):
+		return node.NetTorV3
+	case strings.HasSuffix(lower, ".i2p"):
+		return node.NetI2P
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.To4() != nil {
+			return node.NetIPv4
+		}
+		return node.NetIPv6
+	}
+	return NetworkName
+}
+
+// ProxyFor returns the proxy to use for a host, or "" to dial directly.
+func (d *Dialer) ProxyFor(host string) string {
+	if p, ok := d.cfg.Networks[Network(host)]; ok {
+		return p
+	}
+	return d.cfg.Proxy
+}
+
+// DialContext connects to address, through a proxy if one is configured
+// for its network. It can be used as peer.Config.Dial.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("socks: bad port %q", portStr)
+	}
+	netID := Network(host)
+	proxy := d.ProxyFor(host)
+	if proxy == "" {
+		if netID == node.NetTorV3 || netID == node.NetI2P {
+			return nil, ErrNoProxy
+		}
+		return d.cfg.Forward(ctx, network, address)
+	}
+	if netID == NetworkName && d.cfg.LocalDNS {
+		ips, err := d.cfg.Resolver.LookupIP(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("socks: no addresses for %v", host)
+		}
+		host = ips[0].String()
+	}
+	conn, err := d.cfg.Forward(ctx, "tcp", proxy)
+	if err != nil {
+		return nil, fmt.Errorf("socks: proxy %v: %w", proxy, err)
+	}
+	user, pass := d.credentials()
+	if err := d.handshake(ctx, conn, host, uint16(port), user, pass); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// credentials returns the proxy credentials for a new connection.
+func (d *Dialer) credentials() (string, string) {
+	if !d.cfg.Isolate {
+		return d.cfg.Username, d.cfg.Password
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	user := hex.EncodeToString(b[:])
+	if d.cfg.Username != "" {
+		user = d.cfg.Username + "-" + user
+	}
+	return user, d.cfg.Password + user
+}
+
+const (
+	socksVersion   = 5
+	authNone       = 0
+	authPassword   = 2
+	authNoAccept   = 0xff
+	cmdConnect     = 1
+	atypIPv4       = 1
+	atypDomain     = 3
+	atypIPv6       = 4
+	passAuthVer    = 1
+	replySucceeded = 0
+)
+
+var replyErrors = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+func (d *Dialer) handshake(ctx context.Context, conn net.Conn, host string, port uint16, user, pass string) error {
+	deadline := time.Now().Add(d.cfg.Timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	conn.SetDeadline(deadline)
+	defer conn.SetDeadline(time.Time{})
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0)) // abort blocked reads and writes
+		case <-stop:
+		}
+	}()
+
+	// method selection
+	methods := []byte{socksVersion, 1, authNone}
+	if user != "" {
+		methods = []byte{socksVersion, 2, authNone, authPassword}
+	}
+	if _, err := conn.Write(methods); err != nil {
+		return err
+	}
+	var sel [2]byte
+	if _, err := io.ReadFull(conn, sel[:]); err != nil {
+		return err
+	}
+	if sel[0] != socksVersion {
+		return errors.New("socks: bad proxy version")
+	}
+	switch sel[1] {
+	case authNone:
+	case authPassword:
+		if user == "" {
+			return ErrAuth
+		}
+		if len(user) > 255 || len(pass) > 255 {
+			return errors.New("socks: credentials longer than 255 bytes")
+		}
+		req := []byte{passAuthVer, byte(len(user))}
+		req = append(req, user...)
+		req = append(req, byte(len(pass)))
+		req = append(req, pass...)
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		var res [2]byte
+		if _, err := io.ReadFull(conn, res[:]); err != nil {
+			return err
+		}
+		if res[1] != 0 {
+			return ErrAuth
+		}
+	default:
+		return errors.New("socks: no acceptable authentication method")
+	}
+
+	// connect
+	req := []byte{socksVersion, cmdConnect, 0}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, atypIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, atypIPv6)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.New("socks: hostname longer than 255 bytes")
+		}
+		req = append(req, atypDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	var res [4]byte
+	if _, err := io.ReadFull(conn, res[:]); err != nil {
+		return err
+	}
+	if res[0] != socksVersion {
+		return errors.New("socks: bad proxy version")
+	}
+	if res[1] != replySucceeded {
+		msg, ok := replyErrors[res[1]]
+		if !ok {
+			msg = "unknown error " + strconv.Itoa(int(res[1]))
+		}
+		return fmt.Errorf("socks: connect %v: %v", net.JoinHostPort(host, strconv.Itoa(int(port))), msg)
+	}
+	// skip the bound address
+	var skip int
+	switch res[3] {
+	case atypIPv4:
+		skip = 4
+	case atypIPv6:
+		skip = 16
+	case atypDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return err
+		}
+		skip = int(n[0])
+	default:
+		return errors.New("socks: bad address type in reply")
+	}
+	_, err := io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
@@ -0,0 +1,351 @@
+package socks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/addrman"
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/node"
+	"code.dogecoin.org/gossip/peer"
+)
+
+// proxyServer is an in-process SOCKS5 stand-in. It connects CONNECT
+// requests for the hosts in targets to their real addresses, and
+// records each request.
+type proxyServer struct {
+	l        net.Listener
+	user     string // require these credentials if set
+	pass     string
+	targets  map[string]string // requested "host:port" -> address to dial
+	mu       sync.Mutex
+	requests []proxyRequest
+}
+
+type proxyRequest struct {
+	atyp byte
+	host string
+	port uint16
+	user string
+	pass string
+}
+
+func newProxy(t *testing.T, targets map[string]string) *proxyServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &proxyServer{l: l, targets: targets}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *proxyServer) addr() string {
+	return s.l.Addr().String()
+}
+
+func (s *proxyServer) log() []proxyRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]proxyRequest(nil), s.requests...)
+}
+
+func (s *proxyServer) serve(conn net.Conn) {
+	defer conn.Close()
+	var req proxyRequest
+	// method selection
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(conn, hdr); err != nil || hdr[0] != socksVersion {
+		return
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	method := byte(authNoAccept)
+	for _, m := range methods {
+		if (s.user == "" && m == authNone) || m == authPassword {
+			method = m
+		}
+	}
+	conn.Write([]byte{socksVersion, method})
+	if method == authNoAccept {
+		return
+	}
+	if method == authPassword {
+		ver := make([]byte, 2)
+		if _, err := io.ReadFull(conn, ver); err != nil {
+			return
+		}
+		user := make([]byte, ver[1])
+		io.ReadFull(conn, user)
+		n := make([]byte, 1)
+		io.ReadFull(conn, n)
+		pass := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, pass); err != nil {
+			return
+		}
+		req.user, req.pass = string(user), string(pass)
+		if s.user != "" && (req.user != s.user || req.pass != s.pass) {
+			conn.Write([]byte{passAuthVer, 1})
+			return
+		}
+		conn.Write([]byte{passAuthVer, 0})
+	}
+	// request
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil || head[1] != cmdConnect {
+		return
+	}
+	req.atyp = head[3]
+	switch req.atyp {
+	case atypIPv4, atypIPv6:
+		ip := make(net.IP, 4)
+		if req.atyp == atypIPv6 {
+			ip = make(net.IP, 16)
+		}
+		io.ReadFull(conn, ip)
+		req.host = ip.String()
+	case atypDomain:
+		n := make([]byte, 1)
+		io.ReadFull(conn, n)
+		name := make([]byte, n[0])
+		io.ReadFull(conn, name)
+		req.host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+	req.port = uint16(port[0])<<8 | uint16(port[1])
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+
+	target, ok := s.targets[net.JoinHostPort(req.host, strconv.Itoa(int(req.port)))]
+	var out net.Conn
+	if ok {
+		out, _ = net.Dial("tcp", target)
+	}
+	if out == nil {
+		conn.Write([]byte{socksVersion, 4, 0, atypIPv4, 0, 0, 0, 0, 0, 0}) // host unreachable
+		return
+	}
+	defer out.Close()
+	conn.Write([]byte{socksVersion, replySucceeded, 0, atypDomain, 4, 'b', 'o', 'u', 'n', 0, 1})
+	go io.Copy(out, conn)
+	io.Copy(conn, out)
+}
+
+// echoServer accepts connections and echoes what it reads.
+func echoServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func echo(t *testing.T, conn net.Conn) {
+	t.Helper()
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("wow")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "wow" {
+		t.Fatalf("echo %q %v", buf, err)
+	}
+}
+
+var onion = node.NetAddr{Network: node.NetTorV3, Addr: bytes.Repeat([]byte{7}, 32)}.String()
+
+func TestConnectByName(t *testing.T) {
+	target := echoServer(t)
+	proxy := newProxy(t, map[string]string{
+		onion + ":42069":          target,
+		"seed.example.test:22556": target,
+	})
+	d := New(Config{Proxy: proxy.addr()})
+
+	conn, err := d.DialContext(context.Background(), "tcp", onion+":42069")
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, conn)
+	conn, err = d.DialContext(context.Background(), "tcp", "seed.example.test:22556")
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, conn)
+
+	log := proxy.log()
+	if len(log) != 2 || log[0].atyp != atypDomain || log[0].host != onion || log[0].port != 42069 ||
+		log[1].atyp != atypDomain || log[1].host != "seed.example.test" {
+		t.Fatalf("requests %+v", log)
+	}
+	if _, err := d.DialContext(context.Background(), "tcp", "missing.example.test:1"); err == nil ||
+		!strings.Contains(err.Error(), "host unreachable") {
+		t.Fatalf("unreachable host: %v", err)
+	}
+}
+
+func TestConnectIP(t *testing.T) {
+	target := echoServer(t)
+	proxy := newProxy(t, map[string]string{"8.8.8.8:42069": target, "[2001:db8::1]:42069": target})
+	d := New(Config{Proxy: proxy.addr()})
+	for _, addr := range []string{"8.8.8.8:42069", "[2001:db8::1]:42069"} {
+		conn, err := d.DialContext(context.Background(), "tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		echo(t, conn)
+	}
+	log := proxy.log()
+	if len(log) != 2 || log[0].atyp != atypIPv4 || log[1].atyp != atypIPv6 {
+		t.Fatalf("requests %+v", log)
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	target := echoServer(t)
+	proxy := newProxy(t, map[string]string{onion + ":1": target})
+	proxy.user, proxy.pass = "doge", "wow"
+
+	if _, err := New(Config{Proxy: proxy.addr()}).DialContext(context.Background(), "tcp", onion+":1"); err == nil {
+		t.Fatal("connected without credentials")
+	}
+	if _, err := New(Config{Proxy: proxy.addr(), Username: "doge", Password: "bad"}).DialContext(context.Background(), "tcp", onion+":1"); !errors.Is(err, ErrAuth) {
+		t.Fatalf("bad password: %v", err)
+	}
+	conn, err := New(Config{Proxy: proxy.addr(), Username: "doge", Password: "wow"}).DialContext(context.Background(), "tcp", onion+":1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, conn)
+
+	// stream isolation: new credentials for each connection
+	proxy.user = ""
+	d := New(Config{Proxy: proxy.addr(), Isolate: true})
+	for i := 0; i < 2; i++ {
+		conn, err := d.DialContext(context.Background(), "tcp", onion+":1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		echo(t, conn)
+	}
+	log := proxy.log()
+	a, b := log[len(log)-2], log[len(log)-1]
+	if a.user == "" || a.user == b.user {
+		t.Fatalf("isolation credentials %q %q", a.user, b.user)
+	}
+}
+
+func TestProxySelection(t *testing.T) {
+	target := echoServer(t)
+	tor := newProxy(t, map[string]string{onion + ":1": target})
+	d := New(Config{Networks: map[node.NetworkID]string{node.NetTorV3: tor.addr()}})
+	if d.ProxyFor(onion) != tor.addr() || d.ProxyFor("8.8.8.8") != "" {
+		t.Fatal("wrong proxy selected")
+	}
+	conn, err := d.DialContext(context.Background(), "tcp", onion+":1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, conn)
+	// IP addresses are dialed directly
+	conn, err = d.DialContext(context.Background(), "tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, conn)
+	if len(tor.log()) != 1 {
+		t.Fatalf("requests %+v", tor.log())
+	}
+	i2p := node.NetAddr{Network: node.NetI2P, Addr: make([]byte, 32)}.String()
+	if _, err := d.DialContext(context.Background(), "tcp", i2p+":1"); err != ErrNoProxy {
+		t.Fatalf("i2p without a proxy: %v", err)
+	}
+	if Network(i2p) != node.NetI2P || Network("example.test") != NetworkName || Network("::1") != node.NetIPv6 {
+		t.Fatal("Network misclassified a host")
+	}
+}
+
+// TestPeerOverOnion dials an overlay address from the address manager
+// through the proxy, and completes the peer handshake.
+func TestPeerOverOnion(t *testing.T) {
+	listening := peer.NewManager(peer.Config{ListenAddr: "127.0.0.1:0"})
+	if err := listening.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	proxy := newProxy(t, map[string]string{onion + ":42069": listening.ListenAddr().String()})
+	d := New(Config{Proxy: proxy.addr()})
+	dialing := peer.NewManager(peer.Config{Dial: d.DialContext})
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		dialing.Close(ctx)
+		listening.Close(ctx)
+	})
+
+	addrs := addrman.New(addrman.Config{})
+	key, _ := dnet.GenerateKeyPair()
+	v2 := node.AddressV2Msg{Time: dnet.DogeNow(), Port: 42069, Owner: make([]byte, 32)}
+	v2.Address, _ = node.ParseNetAddr(onion)
+	msg, err := dnet.ReadMessage(bytes.NewReader(dnet.EncodeMessage(node.ChannelNode, node.TagAddrV2, key, v2.Encode())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := addrs.Add(msg, dnet.Address{}); err != nil {
+		t.Fatal(err)
+	}
+	e, ok := addrs.Get(*(*[32]byte)(msg.PubKey))
+	if !ok || !e.IsOverlay() {
+		t.Fatalf("selected %v", e)
+	}
+	p, err := dialing.ConnectHost(context.Background(), e.HostPort())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.HostPort() != onion+":42069" || p.State() != peer.StateConnected {
+		t.Fatalf("peer %v %v", p.HostPort(), p.State())
+	}
+	if log := proxy.log(); len(log) != 1 || log[0].atyp != atypDomain || log[0].host != onion {
+		t.Fatalf("requests %+v", log)
+	}
+}
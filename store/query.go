@@ -0,0 +1,102 @@
+package store
+
+import (
+	"sort"
+
+	"code.dogecoin.org/gossip/dnet"
+)
+
+// Query selects stored messages. Either Chan or PubKey must be set.
+type Query struct {
+	Chan   dnet.Tag4CC   // channel
+	Tag    dnet.Tag4CC   // optional; only this tag
+	PubKey *[32]byte     // optional; only messages signed by this key
+	Since  dnet.DogeTime // optional; stored at or after this time
+	Until  dnet.DogeTime // optional; stored before this time
+	After  Pos           // optional; only messages after this position (a cursor; needs Chan)
+	Limit  int           // optional; at most this many messages
+}
+
+func (q Query) match(r *record) bool {
+	return (q.Chan == 0 || r.Chan == q.Chan) &&
+		(q.Tag == 0 || r.Tag == q.Tag) &&
+		(q.PubKey == nil || r.PubKey == *q.PubKey) &&
+		r.Time >= q.Since &&
+		(q.Until == 0 || r.Time < q.Until) &&
+		(q.After == 0 || r.Pos > q.After)
+}
+
+// Entries returns the index entries matching a query, oldest first.
+func (s *Store) Entries(q Query) []Entry {
+	recs := s.find(q)
+	res := make([]Entry, len(recs))
+	for i, r := range recs {
+		res[i] = r.Entry
+	}
+	return res
+}
+
+// Iterate calls fn with each message matching a query, oldest first,
+// until fn returns false. Messages removed by retention while iterating
+// are skipped.
+func (s *Store) Iterate(q Query, fn func(e Entry, raw dnet.RawMessage) bool) error {
+	for _, r := range s.find(q) {
+		s.mu.RLock()
+		if r.deleted || s.closed {
+			closed := s.closed
+			s.mu.RUnlock()
+			if closed {
+				return ErrClosed
+			}
+			continue
+		}
+		raw, err := r.seg.read(r.off, r.Size)
+		s.mu.RUnlock()
+		if err != nil {
+			return err
+		}
+		if !fn(r.Entry, raw) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// find returns the records matching a query.
+func (s *Store) find(q Query) []*record {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var candidates []*record
+	if q.PubKey != nil {
+		if p := s.byPub[*q.PubKey]; p != nil {
+			candidates = p.recs
+		}
+	} else if cl := s.chans[q.Chan]; cl != nil {
+		live := cl.recs[cl.head:]
+		// times and positions increase along the log
+		start := sort.Search(len(live), func(i int) bool {
+			return live[i].Time >= q.Since && live[i].Pos > q.After
+		})
+		candidates = live[start:]
+	}
+	var res []*record
+	for _, r := range candidates {
+		if q.Until != 0 && q.PubKey == nil && r.Time >= q.Until {
+			break
+		}
+		if !r.deleted && q.match(r) {
+			res = append(res, r)
+			if q.Limit > 0 && len(res) >= q.Limit && q.PubKey == nil {
+				break
+			}
+		}
+	}
+	if q.PubKey != nil {
+		// across channels: order by time, then position
+		sort.SliceStable(res, func(i, j int) bool { return res[i].Time < res[j].Time })
+		if q.Limit > 0 && len(res) > q.Limit {
+			res = res[:q.Limit]
+		}
+	}
+	return res
+}
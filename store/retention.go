@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+)
+
+// RetentionFor returns the retention for a channel.
+func (s *Store) RetentionFor(ch dnet.Tag4CC) Retention {
+	if r, ok := s.cfg.Retention[ch]; ok {
+		return r
+	}
+	return *s.cfg.Default
+}
+
+// Prune removes messages outside the retention limits, returning the
+// number removed. Put prunes its channel; call Prune (or Run) to
+// expire messages by age on quiet channels.
+func (s *Store) Prune() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0
+	}
+	return s.prune()
+}
+
+// Run prunes the store every interval until the context is done.
+func (s *Store) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Prune()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// s.mu must be held.
+func (s *Store) prune() int {
+	now := s.cfg.Clock.Now()
+	n := 0
+	for _, cl := range s.chans {
+		n += s.pruneChannel(cl, now)
+	}
+	return n
+}
+
+// pruneChannel applies retention to one channel; s.mu must be held.
+func (s *Store) pruneChannel(cl *chanLog, now time.Time) int {
+	ret := s.RetentionFor(cl.ch)
+	var cutoff dnet.DogeTime
+	if ret.MaxAge > 0 {
+		cutoff = dnet.UnixToDoge(now.Add(-ret.MaxAge))
+	}
+	removed := 0
+	for cl.head < len(cl.recs) {
+		rec := cl.recs[cl.head]
+		live := len(cl.recs) - cl.head
+		if !(rec.Time < cutoff ||
+			(ret.MaxCount > 0 && live > ret.MaxCount) ||
+			(ret.MaxBytes > 0 && cl.bytes > ret.MaxBytes)) {
+			break
+		}
+		rec.deleted = true
+		delete(s.byID, rec.ID)
+		if !s.byPub[rec.PubKey].remove() {
+			delete(s.byPub, rec.PubKey)
+		}
+		rec.seg.live--
+		cl.bytes -= int64(rec.Size)
+		cl.recs[cl.head] = nil
+		cl.head++
+		removed++
+	}
+	if removed == 0 {
+		return 0
+	}
+	if cl.head > len(cl.recs)/2 {
+		cl.recs = append([]*record(nil), cl.recs[cl.head:]...)
+		cl.head = 0
+	}
+	// delete segment files with nothing left, except the one being appended to
+	for len(cl.segs) > 1 && cl.segs[0].live == 0 {
+		cl.segs[0].remove()
+		cl.segs = cl.segs[1:]
+	}
+	return removed
+}
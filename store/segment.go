@@ -0,0 +1,245 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/internal/atomicfile"
+)
+
+// Each segment file starts with a 8-byte file header, followed by records:
+//
+//	[4] size of header+payload (LE)
+//	[4] CRC-32C of time+header+payload (LE)
+//	[4] DogeTime the message was stored (LE)
+//	[108] message header
+//	[size-108] message payload
+var segMagic = dnet.NewTag("DNMS")
+
+const segVersion = 1
+const segHeaderSize = 8
+const recHeaderSize = 12
+const segExt = ".log"
+const tmpExt = ".tmp"
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errBadRecord = errors.New("bad record")
+var errShortSegment = errors.New("store: segment header incomplete")
+
+// segment is one append-only log file of a channel.
+type segment struct {
+	num  uint32
+	path string
+	file *os.File
+	size int64 // bytes written (valid records only)
+	live int   // records not yet removed by retention
+}
+
+func segPath(dir string, num uint32) string {
+	return filepath.Join(dir, fmt.Sprintf("%08x%s", num, segExt))
+}
+
+// listSegments returns the segment numbers in dir, in order.
+func listSegments(dir string) ([]uint32, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+segExt))
+	if err != nil {
+		return nil, err
+	}
+	var nums []uint32
+	for _, name := range names {
+		n, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), segExt), 16, 32)
+		if err == nil {
+			nums = append(nums, uint32(n))
+		}
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+	return nums, nil
+}
+
+// createSegment writes the file header to a temporary file and renames
+// it into place, so a crash never leaves a segment with a short header.
+func createSegment(dir string, num uint32) (*segment, error) {
+	path := segPath(dir, num)
+	if _, err := os.Lstat(path); err == nil {
+		return nil, fmt.Errorf("store: segment exists: %v", path)
+	}
+	tmp := path + tmpExt
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	var hdr [segHeaderSize]byte
+	binary.BigEndian.PutUint32(hdr[0:4], uint32(segMagic))
+	binary.LittleEndian.PutUint32(hdr[4:8], segVersion)
+	if _, err := f.Write(hdr[:]); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := atomicfile.SyncDir(dir); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &segment{num: num, path: path, file: f, size: segHeaderSize}, nil
+}
+
+// removeTemp removes segment files left half-created by a crash.
+func removeTemp(dir string) error {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+segExt+tmpExt))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := os.Remove(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openSegment opens a segment and calls fn for each valid record
+// (body is the message header and payload, valid only during the call.)
+// The file is truncated after the last valid record, discarding a
+// partial write from a crash.
+func openSegment(dir string, num uint32, fn func(off int64, at dnet.DogeTime, body []byte)) (*segment, error) {
+	path := segPath(dir, num)
+	f, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	var hdr [segHeaderSize]byte
+	if _, err := io.ReadFull(f, hdr[:]); err == io.EOF || err == io.ErrUnexpectedEOF {
+		f.Close()
+		return nil, errShortSegment
+	} else if err != nil ||
+		dnet.Tag4CC(binary.BigEndian.Uint32(hdr[0:4])) != segMagic ||
+		binary.LittleEndian.Uint32(hdr[4:8]) != segVersion {
+		f.Close()
+		return nil, fmt.Errorf("store: not a segment file: %v", path)
+	}
+	s := &segment{num: num, path: path, file: f, size: segHeaderSize}
+	r := &offsetReader{f: f, off: segHeaderSize}
+	for {
+		off := r.off
+		at, body, err := readRecord(r)
+		if err != nil {
+			if err != io.EOF {
+				// torn or corrupt tail: keep what was valid
+				if err := f.Truncate(off); err != nil {
+					f.Close()
+					return nil, err
+				}
+			}
+			break
+		}
+		fn(off, at, body)
+		s.size = r.off
+	}
+	return s, nil
+}
+
+// offsetReader reads a file sequentially, tracking the offset.
+type offsetReader struct {
+	f   *os.File
+	off int64
+	buf []byte
+}
+
+func (r *offsetReader) read(n int) ([]byte, error) {
+	if cap(r.buf) < n {
+		r.buf = make([]byte, n)
+	}
+	b := r.buf[:n]
+	got, err := r.f.ReadAt(b, r.off)
+	if got == n {
+		r.off += int64(n)
+		return b, nil
+	}
+	if got == 0 && err == io.EOF {
+		return nil, io.EOF
+	}
+	return nil, errBadRecord
+}
+
+// readRecord reads and checks the next record, returning its time
+// and the message header and payload.
+func readRecord(r *offsetReader) (dnet.DogeTime, []byte, error) {
+	rec, err := r.read(recHeaderSize)
+	if err != nil {
+		return 0, nil, err
+	}
+	rec = append([]byte(nil), rec...) // r.buf is reused for the body
+	size := int(binary.LittleEndian.Uint32(rec[0:4]))
+	sum := binary.LittleEndian.Uint32(rec[4:8])
+	at := dnet.DogeTime(binary.LittleEndian.Uint32(rec[8:12]))
+	if size < dnet.HeaderSize || size > dnet.HeaderSize+dnet.MaxMsgSize {
+		return 0, nil, errBadRecord
+	}
+	body, err := r.read(size)
+	if err != nil {
+		return 0, nil, errBadRecord
+	}
+	crc := crc32.Update(crc32.Checksum(rec[8:12], crcTable), crcTable, body)
+	if crc != sum || int(binary.LittleEndian.Uint32(body[8:12])) != size-dnet.HeaderSize {
+		return 0, nil, errBadRecord
+	}
+	return at, body, nil
+}
+
+// append writes a record, returning its offset.
+func (s *segment) append(at dnet.DogeTime, raw dnet.RawMessage, sync bool) (int64, error) {
+	size := len(raw.Header) + len(raw.Payload)
+	buf := make([]byte, recHeaderSize, recHeaderSize+size)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(size))
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(at))
+	buf = append(buf, raw.Header...)
+	buf = append(buf, raw.Payload...)
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(buf[8:], crcTable))
+	off := s.size
+	if _, err := s.file.WriteAt(buf, off); err != nil {
+		// leave s.size alone: the partial record is overwritten by the next
+		// append, or truncated on the next open.
+		return 0, err
+	}
+	if sync {
+		if err := s.file.Sync(); err != nil {
+			return 0, err
+		}
+	}
+	s.size += int64(len(buf))
+	return off, nil
+}
+
+// read returns the message stored at off.
+func (s *segment) read(off int64, size int) (dnet.RawMessage, error) {
+	buf := make([]byte, size)
+	if _, err := s.file.ReadAt(buf, off+recHeaderSize); err != nil {
+		return dnet.RawMessage{}, err
+	}
+	return dnet.RawMessage{Header: buf[:dnet.HeaderSize], Payload: buf[dnet.HeaderSize:]}, nil
+}
+
+func (s *segment) remove() error {
+	s.file.Close()
+	return os.Remove(s.path)
+}
@@ -0,0 +1,386 @@
+// Package store is an append-only, crash-safe message store.
+//
+// Messages are kept as their original header and payload bytes in
+// per-channel segment files, and indexed in memory by message ID,
+// channel and tag, pubkey and the DogeTime they were stored. The index
+// is rebuilt by scanning the segments on Open; a torn write at the end
+// of a segment is detected by its checksum and discarded, and new
+// segments are renamed into place once their header is on disk.
+//
+// Retention is set per channel by age, count and size. Expired messages
+// leave the index immediately; a segment file is deleted once none of
+// its messages remain.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/internal/atomicfile"
+)
+
+// Retention limits how much of a channel is kept. Zero fields are unlimited.
+type Retention struct {
+	MaxAge   time.Duration // drop messages stored longer ago than this
+	MaxCount int           // keep at most this many messages
+	MaxBytes int64         // keep at most this many bytes of messages
+}
+
+var DefaultRetention = Retention{MaxAge: 7 * 24 * time.Hour, MaxBytes: 256 << 20}
+
+type Config struct {
+	Dir         string                    // directory for segment files
+	Retention   map[dnet.Tag4CC]Retention // per-channel retention
+	Default     *Retention                // retention for other channels (default: DefaultRetention)
+	SegmentSize int64                     // start a new segment file after this size (default 8MB)
+	Sync        bool                      // fsync after every write
+	Clock       dnet.Clock                // optional; defaults to dnet.SystemClock
+}
+
+// Pos is the position of a message in its channel's log. Positions
+// increase with each message stored in a channel and stay valid across
+// restarts, so they can be used as cursors.
+type Pos uint64
+
+func makePos(seg uint32, off int64) Pos {
+	return Pos(uint64(seg)<<40 | uint64(off))
+}
+
+// Entry describes a stored message.
+type Entry struct {
+	ID     dnet.MsgID
+	Chan   dnet.Tag4CC
+	Tag    dnet.Tag4CC
+	PubKey [32]byte
+	Time   dnet.DogeTime // when the message was stored
+	Pos    Pos
+	Size   int // header and payload bytes
+}
+
+type record struct {
+	Entry
+	seg     *segment
+	off     int64
+	deleted bool
+}
+
+// chanLog is the log and index of one channel.
+type chanLog struct {
+	ch    dnet.Tag4CC
+	dir   string
+	segs  []*segment
+	recs  []*record // in log order; recs[head:] are live
+	head  int
+	bytes int64
+	last  dnet.DogeTime
+}
+
+// pubLog is the records signed by one key, in log order across
+// channels. Retention removes records from the oldest end of each
+// channel, which may be in the middle here, so deleted records are
+// skipped until they make up half the log.
+type pubLog struct {
+	recs []*record
+	dead int // deleted records in recs
+}
+
+// remove accounts for a deleted record, returning false once the log is empty.
+func (p *pubLog) remove() bool {
+	p.dead++
+	for len(p.recs) > 0 && p.recs[0].deleted {
+		p.recs[0] = nil
+		p.recs = p.recs[1:]
+		p.dead--
+	}
+	if p.dead > len(p.recs)/2 {
+		live := make([]*record, 0, len(p.recs)-p.dead)
+		for _, r := range p.recs {
+			if !r.deleted {
+				live = append(live, r)
+			}
+		}
+		p.recs, p.dead = live, 0
+	}
+	return len(p.recs) > 0
+}
+
+var ErrNotFound = errors.New("message not found")
+var ErrClosed = errors.New("store closed")
+
+type Store struct {
+	cfg    Config
+	mu     sync.RWMutex
+	chans  map[dnet.Tag4CC]*chanLog
+	byID   map[dnet.MsgID]*record
+	byPub  map[[32]byte]*pubLog
+	closed bool
+}
+
+// Open opens or creates a store in Config.Dir.
+func Open(cfg Config) (*Store, error) {
+	if cfg.Default == nil {
+		cfg.Default = &DefaultRetention
+	}
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = 8 << 20
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = dnet.SystemClock{}
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Store{
+		cfg:   cfg,
+		chans: make(map[dnet.Tag4CC]*chanLog),
+		byID:  make(map[dnet.MsgID]*record),
+		byPub: make(map[[32]byte]*pubLog),
+	}
+	dirs, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range dirs {
+		ch, err := strconv.ParseUint(d.Name(), 16, 32)
+		if !d.IsDir() || len(d.Name()) != 8 || err != nil {
+			continue
+		}
+		if err := s.loadChannel(dnet.Tag4CC(ch)); err != nil {
+			s.closeFiles()
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	s.prune()
+	s.mu.Unlock()
+	return s, nil
+}
+
+func (s *Store) loadChannel(ch dnet.Tag4CC) error {
+	cl := s.channel(ch)
+	if err := removeTemp(cl.dir); err != nil {
+		return err
+	}
+	nums, err := listSegments(cl.dir)
+	if err != nil {
+		return err
+	}
+	for i, num := range nums {
+		seg, err := openSegment(cl.dir, num, func(off int64, at dnet.DogeTime, body []byte) {
+			msg := dnet.DecodeHeader(body)
+			id := dnet.MessageID(body[:dnet.HeaderSize], body[dnet.HeaderSize:])
+			if _, dup := s.byID[id]; dup {
+				return
+			}
+			rec := &record{Entry: Entry{
+				ID:     id,
+				Chan:   msg.Chan,
+				Tag:    msg.Tag,
+				PubKey: *(*[32]byte)(msg.PubKey),
+				Time:   at,
+				Pos:    makePos(num, off),
+				Size:   len(body),
+			}, off: off}
+			s.index(cl, rec)
+		})
+		if err == errShortSegment && i == len(nums)-1 {
+			// created without a header before a crash: nothing was written
+			if err := os.Remove(segPath(cl.dir, num)); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// link the records just indexed to their segment
+		for i := len(cl.recs) - 1; i >= 0 && cl.recs[i].seg == nil; i-- {
+			cl.recs[i].seg = seg
+			seg.live++
+		}
+		cl.segs = append(cl.segs, seg)
+	}
+	return nil
+}
+
+// channel returns the log for a channel, creating it; s.mu must be held.
+func (s *Store) channel(ch dnet.Tag4CC) *chanLog {
+	cl := s.chans[ch]
+	if cl == nil {
+		cl = &chanLog{ch: ch, dir: filepath.Join(s.cfg.Dir, fmt.Sprintf("%08x", uint32(ch)))}
+		s.chans[ch] = cl
+	}
+	return cl
+}
+
+// index adds a record to the indexes; s.mu must be held.
+func (s *Store) index(cl *chanLog, rec *record) {
+	cl.recs = append(cl.recs, rec)
+	cl.bytes += int64(rec.Size)
+	if rec.Time > cl.last {
+		cl.last = rec.Time
+	}
+	s.byID[rec.ID] = rec
+	p := s.byPub[rec.PubKey]
+	if p == nil {
+		p = &pubLog{}
+		s.byPub[rec.PubKey] = p
+	}
+	p.recs = append(p.recs, rec)
+}
+
+// Put stores a verified message. Returns false if it was already stored.
+func (s *Store) Put(msg dnet.Message) (bool, error) {
+	hdr := msg.RawHdr
+	if len(hdr) != dnet.HeaderSize {
+		hdr = dnet.ReEncodeHeader(msg.Chan, msg.Tag, (*[32]byte)(msg.PubKey), msg.Signature, msg.Payload)
+	}
+	return s.PutRaw(dnet.RawMessage{Header: hdr, Payload: msg.Payload})
+}
+
+// PutRaw stores a message frame. Returns false if it was already stored.
+func (s *Store) PutRaw(raw dnet.RawMessage) (bool, error) {
+	if len(raw.Header) != dnet.HeaderSize {
+		return false, errors.New("store: bad message header")
+	}
+	id := raw.ID()
+	msg := dnet.DecodeHeader(raw.Header)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false, ErrClosed
+	}
+	if _, ok := s.byID[id]; ok {
+		return false, nil
+	}
+	cl := s.channel(msg.Chan)
+	// times never go backwards within a channel, so they can be searched
+	at := dnet.DogeNowAt(s.cfg.Clock)
+	if at < cl.last {
+		at = cl.last
+	}
+	size := len(raw.Header) + len(raw.Payload)
+	seg, err := s.activeSegment(cl, size)
+	if err != nil {
+		return false, err
+	}
+	off, err := seg.append(at, raw, s.cfg.Sync)
+	if err != nil {
+		return false, err
+	}
+	seg.live++
+	s.index(cl, &record{Entry: Entry{
+		ID:     id,
+		Chan:   msg.Chan,
+		Tag:    msg.Tag,
+		PubKey: *(*[32]byte)(msg.PubKey),
+		Time:   at,
+		Pos:    makePos(seg.num, off),
+		Size:   size,
+	}, seg: seg, off: off})
+	s.pruneChannel(cl, s.cfg.Clock.Now())
+	return true, nil
+}
+
+// activeSegment returns the segment to append to, starting a new one
+// when the current one is full; s.mu must be held.
+func (s *Store) activeSegment(cl *chanLog, size int) (*segment, error) {
+	if n := len(cl.segs); n > 0 {
+		seg := cl.segs[n-1]
+		if seg.size+int64(recHeaderSize+size) <= s.cfg.SegmentSize || seg.size == segHeaderSize {
+			return seg, nil
+		}
+	}
+	if err := os.Mkdir(cl.dir, 0o755); err == nil {
+		if err := atomicfile.SyncDir(s.cfg.Dir); err != nil {
+			return nil, err
+		}
+	} else if !os.IsExist(err) {
+		return nil, err
+	}
+	var num uint32
+	if n := len(cl.segs); n > 0 {
+		num = cl.segs[n-1].num + 1
+	}
+	seg, err := createSegment(cl.dir, num)
+	if err != nil {
+		return nil, err
+	}
+	cl.segs = append(cl.segs, seg)
+	return seg, nil
+}
+
+// Has reports whether a message is stored.
+func (s *Store) Has(id dnet.MsgID) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.byID[id]
+	return ok
+}
+
+// Get returns a stored message.
+func (s *Store) Get(id dnet.MsgID) (dnet.RawMessage, Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rec := s.byID[id]
+	if rec == nil {
+		return dnet.RawMessage{}, Entry{}, ErrNotFound
+	}
+	raw, err := rec.seg.read(rec.off, rec.Size)
+	return raw, rec.Entry, err
+}
+
+// ChannelStats describes the messages stored for a channel.
+type ChannelStats struct {
+	Chan   dnet.Tag4CC
+	Count  int
+	Bytes  int64
+	Oldest dnet.DogeTime
+	Newest dnet.DogeTime
+	Files  int
+}
+
+// Stats returns the stats for each channel.
+func (s *Store) Stats() []ChannelStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := make([]ChannelStats, 0, len(s.chans))
+	for ch, cl := range s.chans {
+		st := ChannelStats{Chan: ch, Count: len(cl.recs) - cl.head, Bytes: cl.bytes, Files: len(cl.segs)}
+		if st.Count > 0 {
+			st.Oldest = cl.recs[cl.head].Time
+			st.Newest = cl.recs[len(cl.recs)-1].Time
+		}
+		stats = append(stats, st)
+	}
+	return stats
+}
+
+// Close closes the segment files.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.closeFiles()
+}
+
+func (s *Store) closeFiles() error {
+	var first error
+	for _, cl := range s.chans {
+		for _, seg := range cl.segs {
+			if err := seg.file.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
@@ -0,0 +1,226 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"code.dogecoin.org/gossip/dnet"
+	"code.dogecoin.org/gossip/internal/testutil"
+)
+
+var testChan = dnet.NewTag("Test")
+var testTag = dnet.NewTag("Tst1")
+
+func openTest(t *testing.T, cfg Config) *Store {
+	t.Helper()
+	s, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func put(t *testing.T, s *Store, channel dnet.Tag4CC, key dnet.KeyPair, payload string) dnet.MsgID {
+	t.Helper()
+	raw := dnet.EncodeMessageRaw(channel, testTag, key, []byte(payload))
+	ok, err := s.PutRaw(raw)
+	if err != nil || !ok {
+		t.Fatalf("put: %v %v", ok, err)
+	}
+	return raw.ID()
+}
+
+// chanDir returns the directory of a channel's segments.
+func chanDir(dir string, channel dnet.Tag4CC) string {
+	return filepath.Join(dir, fmt.Sprintf("%08x", uint32(channel)))
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	s := openTest(t, Config{Dir: dir, SegmentSize: 512})
+	key := testutil.NewKey(t)
+	var ids []dnet.MsgID
+	for i := 0; i < 10; i++ {
+		ids = append(ids, put(t, s, testChan, key, fmt.Sprint("message ", i)))
+	}
+	s.Close()
+
+	s = openTest(t, Config{Dir: dir, SegmentSize: 512})
+	for i, id := range ids {
+		raw, e, err := s.Get(id)
+		if err != nil || string(raw.Payload) != fmt.Sprint("message ", i) || e.Chan != testChan {
+			t.Fatalf("message %d: %q %v", i, raw.Payload, err)
+		}
+	}
+	if st := s.Stats(); len(st) != 1 || st[0].Count != 10 || st[0].Files < 2 {
+		t.Fatalf("stats %+v", st)
+	}
+}
+
+func TestTornTail(t *testing.T) {
+	dir := t.TempDir()
+	s := openTest(t, Config{Dir: dir})
+	key := testutil.NewKey(t)
+	id := put(t, s, testChan, key, "kept")
+	s.Close()
+
+	// half a record at the end of the segment
+	path := segPath(chanDir(dir, testChan), 0)
+	before, _ := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{200, 0, 0, 0, 1, 2, 3, 4, 5})
+	f.Close()
+
+	s = openTest(t, Config{Dir: dir})
+	if !s.Has(id) {
+		t.Fatal("valid record lost")
+	}
+	if after, _ := os.Stat(path); after.Size() != before.Size() {
+		t.Fatalf("size %d after open, want %d", after.Size(), before.Size())
+	}
+	id2 := put(t, s, testChan, key, "after")
+	s.Close()
+	s = openTest(t, Config{Dir: dir})
+	if !s.Has(id) || !s.Has(id2) {
+		t.Fatal("records lost after append")
+	}
+}
+
+func TestShortHeaderSegment(t *testing.T) {
+	dir := t.TempDir()
+	s := openTest(t, Config{Dir: dir})
+	key := testutil.NewKey(t)
+	id := put(t, s, testChan, key, "kept")
+	s.Close()
+
+	// a crash while an older version created the next segment
+	short := segPath(chanDir(dir, testChan), 1)
+	if err := os.WriteFile(short, []byte("DNM"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s = openTest(t, Config{Dir: dir})
+	if !s.Has(id) {
+		t.Fatal("record lost")
+	}
+	if _, err := os.Stat(short); !os.IsNotExist(err) {
+		t.Fatal("short segment not removed")
+	}
+	put(t, s, testChan, key, "after")
+
+	// not at the end, it is an error
+	s.Close()
+	if err := os.WriteFile(segPath(chanDir(dir, testChan), 0), []byte("DNM"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(segPath(chanDir(dir, testChan), 5), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := Open(Config{Dir: dir}); err == nil {
+		s.Close()
+		t.Fatal("opened a store with a damaged segment")
+	}
+}
+
+func TestTempSegmentRemoved(t *testing.T) {
+	dir := t.TempDir()
+	s := openTest(t, Config{Dir: dir, SegmentSize: 256})
+	key := testutil.NewKey(t)
+	put(t, s, testChan, key, "one")
+	s.Close()
+
+	// a crash before the rename leaves only the temporary file
+	tmp := segPath(chanDir(dir, testChan), 1) + tmpExt
+	if err := os.WriteFile(tmp, []byte("DNM"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s = openTest(t, Config{Dir: dir, SegmentSize: 256})
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatal("temporary segment not removed")
+	}
+	for i := 0; i < 4; i++ {
+		put(t, s, testChan, key, fmt.Sprint("message ", i))
+	}
+	names, _ := filepath.Glob(filepath.Join(chanDir(dir, testChan), "*"))
+	for _, name := range names {
+		if filepath.Ext(name) != segExt {
+			t.Fatalf("left %v", name)
+		}
+	}
+	if len(names) < 2 {
+		t.Fatalf("segments %v", names)
+	}
+}
+
+func TestRetentionCount(t *testing.T) {
+	dir := t.TempDir()
+	other := dnet.NewTag("Oth1")
+	s := openTest(t, Config{Dir: dir, SegmentSize: 256, Retention: map[dnet.Tag4CC]Retention{testChan: {MaxCount: 3}}})
+	key := testutil.NewKey(t)
+	// the key's messages in the other channel stay ahead of the pruned ones
+	kept := put(t, s, other, key, "other")
+	var ids []dnet.MsgID
+	for i := 0; i < 20; i++ {
+		ids = append(ids, put(t, s, testChan, key, fmt.Sprint("message ", i)))
+	}
+	for i, id := range ids {
+		if s.Has(id) != (i >= 17) {
+			t.Fatalf("message %d stored: %v", i, s.Has(id))
+		}
+	}
+	es := s.Entries(Query{PubKey: key.Pub})
+	if len(es) != 4 || es[0].ID != kept || es[3].ID != ids[19] {
+		t.Fatalf("entries by key %d", len(es))
+	}
+	if p := s.byPub[*key.Pub]; len(p.recs) > 2*(len(p.recs)-p.dead) {
+		t.Fatalf("index holds %d records for %d live", len(p.recs), len(p.recs)-p.dead)
+	}
+	if st := s.Stats(); len(segFiles(t, chanDir(dir, testChan))) != statFiles(st, testChan) {
+		t.Fatal("segment files not removed")
+	}
+}
+
+func TestRetentionAge(t *testing.T) {
+	clock := testutil.NewClock()
+	s := openTest(t, Config{Dir: t.TempDir(), Default: &Retention{MaxAge: time.Hour}, Clock: clock})
+	key := testutil.NewKey(t)
+	old := put(t, s, testChan, key, "old")
+	clock.Advance(30 * time.Minute)
+	recent := put(t, s, testChan, key, "recent")
+	clock.Advance(45 * time.Minute)
+	if n := s.Prune(); n != 1 || s.Has(old) || !s.Has(recent) {
+		t.Fatalf("pruned %d", n)
+	}
+	if es := s.Entries(Query{PubKey: key.Pub}); len(es) != 1 || es[0].ID != recent {
+		t.Fatalf("entries by key %v", es)
+	}
+	clock.Advance(time.Hour)
+	s.Prune()
+	if _, ok := s.byPub[*key.Pub]; ok {
+		t.Fatal("empty key index kept")
+	}
+}
+
+func segFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "*"+segExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func statFiles(st []ChannelStats, channel dnet.Tag4CC) int {
+	for _, c := range st {
+		if c.Chan == channel {
+			return c.Files
+		}
+	}
+	return 0
+}